@@ -0,0 +1,167 @@
+// Package analyzer is the library API of the Go analyser: it collects the selected files of
+// a directory, measures them concurrently and summarises the results. Go files are measured
+// natively, the other languages by the backends (see Backend).
+//
+//	report, err := analyzer.Run(ctx, "./src", analyzer.WithWorkers(4))
+//	if err != nil { ... }
+//	fmt.Println(report.Summary["cc_p95"])
+//
+// The options & the results are the types of this package, the CLI (exp) shares the engine
+// underneath.
+package analyzer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// The results of a run
+type Report struct {
+	Project  string
+	Root     string                        // The analysed directory
+	Summary  map[string]float64            // Named as the project gate metrics (eg.: cc_p95)
+	Packages map[string]map[string]float64 // By directory relative to Root, named as the package gate metrics
+	Files    []File                        // Sorted by path, none if the run was streamed
+	// The nr of files measured by language
+	Languages map[string]int
+	// The thresholds of the gate in the configuration that are violated, whether the gate is
+	// enabled or not: the function & file violations first, by file
+	Violations []Violation
+	// The files that were skipped or measured partially, by path
+	Diagnostics []Diagnostic
+	// The files & directories that were not measured, in the order of the walk
+	Excluded []Exclusion
+	// The generated files, they are not in Files unless they are included
+	Generated Generated
+	// Whether the files were folded into the summaries as they were measured (streaming in the
+	// configuration). Then only the summaries, the violations & the diagnostics are kept.
+	Streamed bool
+}
+
+// Whether files were skipped or measured partially
+func (r *Report) Incomplete() bool {
+	return len(r.Diagnostics) > 0
+}
+
+// Sets an option of Run
+type Option func(*options)
+
+type options struct {
+	configFile string
+	workers    int
+	only       func(rel string) bool
+	cacheDir   string
+	noCache    bool
+	backends   []Backend
+	progress   func(Progress)
+}
+
+// The configuration file (.code-stats.yaml) to use, instead of the one discovered upward from
+// the directory
+func WithConfigFile(fileName string) Option {
+	return func(o *options) { o.configFile = fileName }
+}
+
+// Overrides the nr of workers of the configuration
+func WithWorkers(n int) Option {
+	return func(o *options) { o.workers = n }
+}
+
+// Restricts the run to the files (relative to the directory) only accepts
+func WithFilter(only func(rel string) bool) Option {
+	return func(o *options) { o.only = only }
+}
+
+// Caches the file metrics in dir, whether the configuration enables the cache or not
+func WithCacheDir(dir string) Option {
+	return func(o *options) { o.cacheDir, o.noCache = dir, false }
+}
+
+// Disables the file metrics cache of the configuration
+func WithoutCache() Option {
+	return func(o *options) { o.cacheDir, o.noCache = "", true }
+}
+
+// Adds backends of other languages, after the ones of the configuration. A file is measured by
+// the first backend accepting it, Go files always by the native one.
+func WithBackends(backends ...Backend) Option {
+	return func(o *options) { o.backends = append(o.backends, backends...) }
+}
+
+// Reports the progress of the measurements after every file, not concurrently
+func WithProgress(progress func(Progress)) Option {
+	return func(o *options) { o.progress = progress }
+}
+
+// Measures the selected files of dir and calculates the summary. Once ctx is cancelled the walk
+// stops, no new file is started and its error is returned. The generated files are only
+// counted, unless the configuration includes them.
+func Run(ctx context.Context, dir string, opts ...Option) (*Report, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var cfg config.Config
+	var err error
+	if o.configFile != "" {
+		cfg, err = config.Load(o.configFile)
+	} else {
+		cfg, _, err = config.ForDir(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load configuration: %w", err)
+	}
+	switch {
+	case o.noCache:
+		cfg.Cache.Enabled = false
+	case o.cacheDir != "":
+		cfg.Cache = config.Cache{Enabled: true, Dir: o.cacheDir}
+	}
+
+	var engineOpts = []engine.Option{engine.WithConfig(cfg), engine.WithWorkers(o.workers), engine.WithFilter(o.only)}
+	if o.progress != nil {
+		engineOpts = append(engineOpts, engine.WithProgress(func(p engine.Progress) { o.progress(Progress(p)) }))
+	}
+	for _, b := range o.backends {
+		engineOpts = append(engineOpts, engine.WithBackends(&backend{b}))
+	}
+	report, err := engine.Run(ctx, dir, engineOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return newReport(report), nil
+}
+
+func newReport(report *engine.Report) *Report {
+	var r = Report{
+		Project:     report.Project,
+		Root:        report.Root,
+		Summary:     report.Summary,
+		Packages:    report.Packages,
+		Files:       make([]File, 0, len(report.Files)),
+		Languages:   report.Languages(),
+		Violations:  []Violation{},
+		Diagnostics: make([]Diagnostic, 0, len(report.Diagnostics)),
+		Excluded:    make([]Exclusion, 0, len(report.Excluded)),
+		Generated:   Generated(report.Generated),
+		Streamed:    report.Streamed(),
+	}
+	for _, fs := range report.Files {
+		r.Files = append(r.Files, newFile(fs))
+	}
+	for _, v := range report.Violations() {
+		r.Violations = append(r.Violations, Violation{
+			Scope: string(v.Scope), Subject: v.Subject, Metric: v.Metric, Value: v.Value, Limit: v.Limit, Op: v.Op,
+		})
+	}
+	for _, d := range report.Diagnostics {
+		r.Diagnostics = append(r.Diagnostics, Diagnostic(d))
+	}
+	for _, e := range report.Excluded {
+		r.Excluded = append(r.Excluded, Exclusion(e))
+	}
+	return &r
+}
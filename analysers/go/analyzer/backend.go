@@ -0,0 +1,80 @@
+package analyzer
+
+import (
+	"context"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// Measures the files of another language (see WithBackends). The files are measured
+// concurrently, with the workers of the run.
+type Backend interface {
+	Language() string
+	// Whether the file is to be measured by the backend
+	Accepts(path string) bool
+	// Measures a file. Once ctx is done (the run is cancelled or the file timed out) the
+	// result is dropped.
+	Measure(ctx context.Context, path string) (Measurement, error)
+}
+
+// The metrics of a file measured by a backend, as the external analysers of the configuration
+// report them. The ABC size of the file is the sum of its functions; the plugins are not run,
+// they need the Go AST.
+type Measurement struct {
+	Lines     *Lines         // Nil if unknown, the LOC metrics are left empty then
+	Imports   []string       // The imported modules, packages, ...
+	Structs   int            // Classes, records, ...
+	Operators map[string]int // The Halstead operators of the file, by token
+	Operands  map[string]int // The Halstead operands of the file, by token
+	Functions []FunctionMeasurement
+}
+
+type Lines struct {
+	Code    int
+	Comment int
+	Blank   int
+}
+
+type FunctionMeasurement struct {
+	ID           string // 'Name' or 'Type.Name', unique within the file
+	Signature    string // 'Name(param, ...)'
+	StartLine    int
+	EndLine      int
+	CC           int
+	Assignments  int
+	Branches     int
+	Conditionals int
+	Operators    map[string]int
+	Operands     map[string]int
+}
+
+// Measures the files of a Backend as the engine's own backends do
+type backend struct {
+	Backend
+}
+
+func (b *backend) Measure(ctx context.Context, paths []string, opts engine.MeasureOptions) ([]metrics.FileMetric, []engine.Diagnostic, error) {
+	return engine.MeasureConcurrently(ctx, paths, opts, func(ctx context.Context, path string) (metrics.FileMetric, []engine.Diagnostic, error) {
+		m, err := b.Backend.Measure(ctx, path)
+		if err != nil {
+			return metrics.FileMetric{}, nil, err
+		}
+		return metrics.NewExternalFileMetric(path, b.Language(), m.external(), opts.Disabled), nil, nil
+	})
+}
+
+func (m *Measurement) external() metrics.ExternalFile {
+	var ef = metrics.ExternalFile{Imports: m.Imports, Structs: m.Structs, Operators: m.Operators, Operands: m.Operands}
+	if m.Lines != nil {
+		ef.Lines = &struct {
+			Code    int `json:"code"`
+			Comment int `json:"comment"`
+			Blank   int `json:"blank"`
+		}{m.Lines.Code, m.Lines.Comment, m.Lines.Blank}
+	}
+	for _, f := range m.Functions {
+		ef.Functions = append(ef.Functions, metrics.ExternalFunction(f))
+	}
+	return ef
+}
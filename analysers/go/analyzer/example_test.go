@@ -0,0 +1,35 @@
+package analyzer_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zkulcsar/metrics/analyzer"
+)
+
+func ExampleRun() {
+	report, err := analyzer.Run(context.Background(), "../testdata/project", analyzer.WithWorkers(2), analyzer.WithoutCache())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(report.Summary["functions"], report.Summary["cc_p95"])
+	for _, f := range report.Files {
+		for _, fn := range f.Functions {
+			fmt.Printf("%s %s cc %d\n", f.Path, fn.ID, fn.CC)
+		}
+	}
+	fmt.Println("generated:", report.Generated.Files)
+	for _, v := range report.Violations {
+		fmt.Printf("%s %s: %s %v %s %v\n", v.Scope, v.Subject, v.Metric, v.Value, v.Op, v.Limit)
+	}
+	// Output:
+	// 4 4.6
+	// main.go main cc 0
+	// pkg/classify.go Classify cc 5
+	// pkg/classify.go Counter.Add cc 1
+	// pkg/classify.go Counter.Value cc 0
+	// generated: [pkg/table.pb.go]
+	// function ../testdata/project/pkg/classify.go:Classify(n): cc 5 <= 2
+	// package ../testdata/project/pkg: functions 3 <= 2
+}
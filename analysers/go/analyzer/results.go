@@ -0,0 +1,131 @@
+package analyzer
+
+import (
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// The metrics of a file & its functions, as in the snapshots
+type File struct {
+	Path      string             `json:"path"`                // Relative to the analysed directory, slash separated
+	Language  string             `json:"language,omitempty"`  // Empty for Go
+	Generated bool               `json:"generated,omitempty"` // Only listed if the generated files are included
+	Metrics   map[string]float64 `json:"metrics"`             // Named as the file gate metrics
+	Functions []Function         `json:"functions"`
+}
+
+type Function struct {
+	ID                 string  `json:"id"` // Unique within the file: 'Name' or 'Type.Name'
+	Signature          string  `json:"signature"`
+	StartLine          int     `json:"start_line"`
+	EndLine            int     `json:"end_line"`
+	LOC                int     `json:"loc"`
+	CC                 int     `json:"cc"`
+	ABC                int     `json:"abc"`
+	Assignments        int     `json:"abc_assignments"`
+	Branches           int     `json:"abc_branches"`
+	Conditionals       int     `json:"abc_conditionals"`
+	HalsteadDifficulty float64 `json:"halstead_difficulty"`
+	HalsteadEffort     float64 `json:"halstead_effort"`
+	// The values of the function plugins, by name
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+func newFile(fs metrics.FileSnapshot) File {
+	var f = File{Path: fs.Path, Language: fs.Language, Generated: fs.Generated, Metrics: fs.Metrics,
+		Functions: make([]Function, 0, len(fs.Functions))}
+	for _, function := range fs.Functions {
+		f.Functions = append(f.Functions, Function(function))
+	}
+	return f
+}
+
+// The scopes of the thresholds
+const (
+	SCOPE_FUNCTION string = string(metrics.SCOPE_FUNCTION)
+	SCOPE_FILE     string = string(metrics.SCOPE_FILE)
+	SCOPE_PACKAGE  string = string(metrics.SCOPE_PACKAGE)
+	SCOPE_PROJECT  string = string(metrics.SCOPE_PROJECT)
+)
+
+// A threshold of the gate that is not met
+type Violation struct {
+	Scope   string // SCOPE_*
+	Subject string // Function signature, file name, package directory or project name
+	Metric  string
+	Value   float64
+	Limit   float64
+	Op      string // The comparison that failed: "<=" for a max, ">=" for a min
+}
+
+// The kinds of problems with a file
+const (
+	DIAGNOSTIC_SYNTAX     string = engine.DIAGNOSTIC_SYNTAX     // Syntax errors
+	DIAGNOSTIC_UNREADABLE string = engine.DIAGNOSTIC_UNREADABLE // The file can't be read
+	DIAGNOSTIC_LOC        string = engine.DIAGNOSTIC_LOC        // 'cloc' failed on the file, the LOC metrics are missing
+	DIAGNOSTIC_TIMEOUT    string = engine.DIAGNOSTIC_TIMEOUT    // The file took longer than the timeout of the configuration
+	DIAGNOSTIC_FAILED     string = engine.DIAGNOSTIC_FAILED     // Anything else, eg.: an error of a backend
+)
+
+// Returned (wrapped) for the files that took longer than the timeout of the configuration
+var ErrTimeout = engine.ErrTimeout
+
+// A problem with a file. Skipped files are not measured at all, the metrics of the others are
+// incomplete.
+type Diagnostic struct {
+	Path    string
+	Kind    string // DIAGNOSTIC_*
+	Err     error
+	Skipped bool
+}
+
+func (d Diagnostic) Error() string {
+	return d.Err.Error()
+}
+
+// Why a file or directory is not measured
+const (
+	EXCLUDED_PATTERN    string = engine.EXCLUDED_PATTERN    // Matches an exclude pattern
+	EXCLUDED_INCLUDE    string = engine.EXCLUDED_INCLUDE    // Matches none of the include patterns
+	EXCLUDED_GITIGNORE  string = engine.EXCLUDED_GITIGNORE  // Ignored by git
+	EXCLUDED_DEFAULT    string = engine.EXCLUDED_DEFAULT    // A vendor, testdata, hidden or _ directory
+	EXCLUDED_SYMLINK    string = engine.EXCLUDED_SYMLINK    // Not followed, or already walked
+	EXCLUDED_UNREADABLE string = engine.EXCLUDED_UNREADABLE // A directory that can't be listed, when going on with the rest
+)
+
+// A file or directory that is not measured
+type Exclusion struct {
+	Path   string
+	Reason string // EXCLUDED_*
+	Dir    bool
+}
+
+// The generated code among the measured files, reported separately
+type Generated struct {
+	Files   []string // Relative to the analysed directory, slash separated, sorted
+	CodeLOC int
+	Share   float64 // Of the code LOC of every measured file
+	// Whether they are measured with the rest of the files, otherwise they are left out of
+	// the summaries, the file results & the gate
+	Included bool
+}
+
+// The state of the measurements of a run
+type Progress struct {
+	Done    int    // Nr of files measured or skipped
+	Total   int    // Nr of files to measure
+	Path    string // The last file done
+	Elapsed time.Duration
+}
+
+// Files per second so far
+func (p Progress) Rate() float64 {
+	return engine.Progress(p).Rate()
+}
+
+// The estimated time left at the current rate, 0 if there is no rate yet
+func (p Progress) ETA() time.Duration {
+	return engine.Progress(p).ETA()
+}
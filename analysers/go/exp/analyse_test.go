@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/zkulcsar/metrics/analyzer"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// The library API runs the engine of the CLI: the same tree gives the same results through both
+func TestAnalyzerMatchesCLI(t *testing.T) {
+	const dir = "../testdata/project"
+	cfg, err := loadConfig("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Workers = 2
+	cli, err := analyse(context.Background(), dir, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib, err := analyzer.Run(context.Background(), dir, analyzer.WithWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot = cli.Snapshot()
+	if len(snapshot.Files) != 2 {
+		t.Fatalf("the CLI measured %d files, expected main.go & pkg/classify.go", len(snapshot.Files))
+	}
+	if lib.Project != cli.Project || lib.Root != cli.Root {
+		t.Errorf("project %s in %s, the CLI: %s in %s", lib.Project, lib.Root, cli.Project, cli.Root)
+	}
+	if !reflect.DeepEqual(lib.Summary, snapshot.Summary) {
+		t.Errorf("summary %v, the CLI: %v", lib.Summary, snapshot.Summary)
+	}
+	if !reflect.DeepEqual(lib.Packages, cli.Packages) {
+		t.Errorf("packages %v, the CLI: %v", lib.Packages, cli.Packages)
+	}
+	if len(lib.Files) != len(snapshot.Files) {
+		t.Fatalf("%d files, the CLI: %d", len(lib.Files), len(snapshot.Files))
+	}
+	for i, fs := range snapshot.Files {
+		var f = lib.Files[i]
+		if f.Path != fs.Path || f.Language != fs.Language || f.Generated != fs.Generated || !reflect.DeepEqual(f.Metrics, fs.Metrics) {
+			t.Errorf("file %+v, the CLI: %+v", f, fs)
+		}
+		if len(f.Functions) != len(fs.Functions) {
+			t.Errorf("%s: %d functions, the CLI: %d", f.Path, len(f.Functions), len(fs.Functions))
+			continue
+		}
+		for j := range fs.Functions {
+			if !reflect.DeepEqual(metrics.FunctionSnapshot(f.Functions[j]), fs.Functions[j]) {
+				t.Errorf("%s: function %+v, the CLI: %+v", f.Path, f.Functions[j], fs.Functions[j])
+			}
+		}
+	}
+
+	var violations = cli.Violations()
+	if len(violations) == 0 {
+		t.Fatal("the CLI found no violations, the fixture has some")
+	}
+	if len(lib.Violations) != len(violations) {
+		t.Fatalf("violations %+v, the CLI: %+v", lib.Violations, violations)
+	}
+	for i, v := range violations {
+		var expected = analyzer.Violation{Scope: string(v.Scope), Subject: v.Subject, Metric: v.Metric, Value: v.Value, Limit: v.Limit, Op: v.Op}
+		if lib.Violations[i] != expected {
+			t.Errorf("violation %+v, the CLI: %+v", lib.Violations[i], v)
+		}
+	}
+
+	if !reflect.DeepEqual(lib.Generated.Files, cli.Generated.Files) || lib.Generated.Files[0] != "pkg/table.pb.go" {
+		t.Errorf("generated files %v, the CLI: %v", lib.Generated.Files, cli.Generated.Files)
+	}
+	if len(lib.Excluded) != len(cli.Excluded) {
+		t.Fatalf("excluded %+v, the CLI: %+v", lib.Excluded, cli.Excluded)
+	}
+	for i, e := range cli.Excluded {
+		if lib.Excluded[i] != analyzer.Exclusion(e) {
+			t.Errorf("excluded %+v, the CLI: %+v", lib.Excluded[i], e)
+		}
+	}
+	if !reflect.DeepEqual(lib.Languages, cli.Languages()) {
+		t.Errorf("languages %v, the CLI: %v", lib.Languages, cli.Languages())
+	}
+}
@@ -0,0 +1,223 @@
+// Package analyzers wraps the metrics as go/analysis analyzers, so that they run under
+// 'go vet -vettool', in multichecker (see exp/vet) and in the other analysis drivers.
+//
+// The Measure analyzer generates the metrics of every file of a package once, the checks
+// (Cyclomatic, ABC, Halstead and Thresholds) use its result and report the functions that
+// are above their limits. 'cloc' is not run, the LOC metrics are not available.
+package analyzers
+
+import (
+	"fmt"
+	"go/ast"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// Every analyzer of the package, for multichecker
+var All = []*analysis.Analyzer{Measure, Cyclomatic, ABC, Halstead, Thresholds}
+
+var Measure = &analysis.Analyzer{
+	Name:       "measure",
+	Doc:        "measure the functions (CC, ABC & Halstead) and export the package totals as a fact",
+	Run:        measure,
+	ResultType: reflect.TypeOf((*Result)(nil)),
+	FactTypes:  []analysis.Fact{new(PackageFact)},
+}
+
+var Cyclomatic = &analysis.Analyzer{
+	Name:     "cyclomatic",
+	Doc:      "report the functions with a Cyclomatic Complexity above -max",
+	Run:      checkCyclomatic,
+	Requires: []*analysis.Analyzer{Measure},
+}
+
+var ABC = &analysis.Analyzer{
+	Name:     "abc",
+	Doc:      "report the functions with an ABC code size above -max",
+	Run:      checkABC,
+	Requires: []*analysis.Analyzer{Measure},
+}
+
+var Halstead = &analysis.Analyzer{
+	Name:     "halstead",
+	Doc:      "report the functions with a Halstead difficulty or effort above -max-difficulty or -max-effort (0 disables the check)",
+	Run:      checkHalstead,
+	Requires: []*analysis.Analyzer{Measure},
+}
+
+var Thresholds = &analysis.Analyzer{
+	Name:     "thresholds",
+	Doc:      "report the functions violating the function thresholds of the quality gate (from the .code-stats.yaml of the package, or -config)",
+	Run:      checkThresholds,
+	Requires: []*analysis.Analyzer{Measure},
+}
+
+// The limits of the checks, the defaults are the ones of the default gate
+var (
+	ccMax         int
+	abcMax        float64
+	maxDifficulty float64
+	maxEffort     float64
+	configFile    string
+)
+
+func init() {
+	var levels = metrics.DefaultSettings().Levels
+	Cyclomatic.Flags.IntVar(&ccMax, "max", levels.CCModerate, "Max Cyclomatic Complexity of a function")
+	ABC.Flags.Float64Var(&abcMax, "max", levels.ABCHigh*2, "Max ABC code size of a function")
+	Halstead.Flags.Float64Var(&maxDifficulty, "max-difficulty", 0, "Max Halstead difficulty of a function")
+	Halstead.Flags.Float64Var(&maxEffort, "max-effort", 0, "Max Halstead effort of a function")
+	Thresholds.Flags.StringVar(&configFile, "config", "", "Configuration file (default: discovered upward from the package)")
+}
+
+// The measured files of a package
+type Result struct {
+	Files []File
+}
+
+type File struct {
+	Metric    metrics.FileMetric
+	Decls     []*ast.FuncDecl // In the order of the function metrics
+	Functions []metrics.FunctionSnapshot
+}
+
+// The totals of a package, exported for the dependents
+type PackageFact struct {
+	Files          int
+	Functions      int
+	CCTotal        int
+	CCMax          int
+	ABCTotal       int
+	HalsteadEffort float64
+}
+
+func (*PackageFact) AFact() {}
+
+func (f *PackageFact) String() string {
+	return fmt.Sprintf("files=%d functions=%d cc=%d (max %d) abc=%d halstead_effort=%.0f",
+		f.Files, f.Functions, f.CCTotal, f.CCMax, f.ABCTotal, f.HalsteadEffort)
+}
+
+func measure(pass *analysis.Pass) (any, error) {
+	var result Result
+	var fact PackageFact
+	for _, tree := range pass.Files {
+		fileName := pass.Fset.File(tree.Pos()).Name()
+		if strings.HasSuffix(fileName, "_test.go") {
+			// Skipping over "test" files, as the analyser does
+			continue
+		}
+		var file = File{Metric: metrics.NewFileMetric(fileName)}
+		file.Metric.Disable(metrics.METRIC_LOC)
+		file.Metric.GenerateMetrics(pass.Fset, tree)
+		for _, decl := range tree.Decls {
+			if f, ok := decl.(*ast.FuncDecl); ok {
+				file.Decls = append(file.Decls, f)
+			}
+		}
+		file.Functions = file.Metric.Snapshot(filepath.Dir(fileName)).Functions
+		for _, f := range file.Functions {
+			fact.CCTotal += f.CC
+			fact.CCMax = max(fact.CCMax, f.CC)
+			fact.ABCTotal += f.ABC
+			fact.HalsteadEffort += f.HalsteadEffort
+		}
+		fact.Files++
+		fact.Functions += len(file.Functions)
+		result.Files = append(result.Files, file)
+	}
+	if fact.Files > 0 {
+		pass.ExportPackageFact(&fact)
+	}
+	return &result, nil
+}
+
+// Calls report with every function of the package that has a declaration
+func eachFunction(pass *analysis.Pass, report func(decl *ast.FuncDecl, f metrics.FunctionSnapshot)) {
+	for _, file := range pass.ResultOf[Measure].(*Result).Files {
+		for i := 0; i < min(len(file.Decls), len(file.Functions)); i++ {
+			report(file.Decls[i], file.Functions[i])
+		}
+	}
+}
+
+func checkCyclomatic(pass *analysis.Pass) (any, error) {
+	eachFunction(pass, func(decl *ast.FuncDecl, f metrics.FunctionSnapshot) {
+		if f.CC > ccMax {
+			pass.Reportf(decl.Name.Pos(), "cyclomatic complexity of %s is %d, expected <= %d", f.ID, f.CC, ccMax)
+		}
+	})
+	return nil, nil
+}
+
+func checkABC(pass *analysis.Pass) (any, error) {
+	eachFunction(pass, func(decl *ast.FuncDecl, f metrics.FunctionSnapshot) {
+		if float64(f.ABC) > abcMax {
+			pass.Reportf(decl.Name.Pos(), "ABC code size of %s is %d (a=%d b=%d c=%d), expected <= %.0f",
+				f.ID, f.ABC, f.Assignments, f.Branches, f.Conditionals, abcMax)
+		}
+	})
+	return nil, nil
+}
+
+func checkHalstead(pass *analysis.Pass) (any, error) {
+	eachFunction(pass, func(decl *ast.FuncDecl, f metrics.FunctionSnapshot) {
+		if maxDifficulty > 0 && f.HalsteadDifficulty > maxDifficulty {
+			pass.Reportf(decl.Name.Pos(), "Halstead difficulty of %s is %.1f, expected <= %.1f", f.ID, f.HalsteadDifficulty, maxDifficulty)
+		}
+		if maxEffort > 0 && f.HalsteadEffort > maxEffort {
+			pass.Reportf(decl.Name.Pos(), "Halstead effort of %s is %.0f, expected <= %.0f", f.ID, f.HalsteadEffort, maxEffort)
+		}
+	})
+	return nil, nil
+}
+
+// The gates by directory, the packages are analysed concurrently
+var gates sync.Map
+
+func checkThresholds(pass *analysis.Pass) (any, error) {
+	for _, file := range pass.ResultOf[Measure].(*Result).Files {
+		gc, err := gateFor(filepath.Dir(file.Metric.FileName()))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range gc.EvaluateFunctions(&file.Metric) {
+			if v.Function >= len(file.Decls) {
+				continue
+			}
+			pass.Report(analysis.Diagnostic{
+				Pos:      file.Decls[v.Function].Name.Pos(),
+				Category: v.Metric,
+				Message:  fmt.Sprintf("%s of %s is %.2f, expected %s %.2f", v.Metric, file.Functions[v.Function].ID, v.Value, v.Op, v.Limit),
+			})
+		}
+	}
+	return nil, nil
+}
+
+// The gate of the configuration set with -config, or discovered upward from dir
+func gateFor(dir string) (*metrics.GateConfig, error) {
+	if gc, ok := gates.Load(dir); ok {
+		return gc.(*metrics.GateConfig), nil
+	}
+	var cfg config.Config
+	var err error
+	if configFile != "" {
+		cfg, err = config.Load(configFile)
+	} else {
+		cfg, _, err = config.ForDir(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load configuration: %w", err)
+	}
+	var gc = cfg.GateConfig()
+	gates.Store(dir, &gc)
+	return &gc, nil
+}
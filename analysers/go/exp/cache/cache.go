@@ -0,0 +1,146 @@
+// Package cache stores the results of the analysis of single files on the disk, keyed by the
+// hash of their content, so that unchanged files don't have to be measured again.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The directory under the user cache directory
+const DIR_NAME string = "code-stats"
+
+type Cache struct {
+	dir  string
+	salt []byte
+}
+
+// Opens (creates) the cache in dir, or in the user cache directory if dir is empty. The salt
+// (eg.: the analyser version & the relevant configuration) is part of every key, results
+// stored with a different salt are not found.
+func Open(dir string, salt ...string) (*Cache, error) {
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(userDir, DIR_NAME)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var h = sha256.New()
+	for _, s := range salt {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return &Cache{dir: dir, salt: h.Sum(nil)}, nil
+}
+
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// The key of a file with the content
+func (c *Cache) Key(name string, content []byte) string {
+	var h = sha256.New()
+	h.Write(c.salt)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// The stored entry, false if there is none. The entry is marked as used (see Prune).
+func (c *Cache) Get(key string) ([]byte, bool) {
+	var path = c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var now = time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Stores the entry. The file is renamed into place so that concurrent runs never read a
+// partially written entry.
+func (c *Cache) Put(key string, data []byte) error {
+	var path = c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Removes every entry
+func (c *Cache) Clear() error {
+	entries, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(c.dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the entries that were not stored or used for maxAge, returns the nr of entries
+// removed. The entries of other analyser versions & configurations are never used, they go
+// eventually.
+func (c *Cache) Prune(maxAge time.Duration) (int, error) {
+	var removed int
+	var cutoff = time.Now().Add(-maxAge)
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Removed by a concurrent run
+			return nil
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Entries are spread over 256 subdirectories
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
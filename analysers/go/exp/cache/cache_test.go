@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	a, err := Open(t.TempDir(), "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(t.TempDir(), "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key = a.Key("pkg/a.go", []byte("package pkg"))
+	for _, tc := range []struct {
+		name  string
+		other string
+		same  bool
+	}{
+		{"same file", a.Key("pkg/a.go", []byte("package pkg")), true},
+		{"other content", a.Key("pkg/a.go", []byte("package pkg // x")), false},
+		{"other path", a.Key("pkg/b.go", []byte("package pkg")), false},
+		{"other salt", b.Key("pkg/a.go", []byte("package pkg")), false},
+	} {
+		if (tc.other == key) != tc.same {
+			t.Errorf("%s: key %s, got %s", tc.name, key, tc.other)
+		}
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	c, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key = c.Key("a.go", []byte("package a"))
+	if _, ok := c.Get(key); ok {
+		t.Fatal("empty cache has the entry")
+	}
+	if err := c.Put(key, []byte(`{"file":"a.go"}`)); err != nil {
+		t.Fatal(err)
+	}
+	data, ok := c.Get(key)
+	if !ok || string(data) != `{"file":"a.go"}` {
+		t.Fatalf("got %q, %v", data, ok)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var old, used, fresh = c.Key("old.go", nil), c.Key("used.go", nil), c.Key("fresh.go", nil)
+	for _, key := range []string{old, used, fresh} {
+		if err := c.Put(key, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var past = time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{old, used} {
+		if err := os.Chtimes(c.path(key), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading an entry keeps it
+	c.Get(used)
+
+	removed, err := c.Prune(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d entries, expected 1", removed)
+	}
+	for key, expected := range map[string]bool{old: false, used: true, fresh: true} {
+		if _, ok := c.Get(key); ok != expected {
+			t.Errorf("entry %s kept: %v, expected %v", key, ok, expected)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	var dir = filepath.Join(t.TempDir(), "cache")
+	c, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key = c.Key("a.go", nil)
+	if err := c.Put(key, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(key); ok {
+		t.Error("the entry survived Clear")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d entries left", len(entries))
+	}
+	// The directory itself is kept
+	if err := c.Put(key, []byte("{}")); err != nil {
+		t.Error(err)
+	}
+}
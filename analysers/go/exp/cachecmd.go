@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/cache"
+)
+
+// Handles the 'cache' subcommand: 'cache clear' and 'cache prune'
+func runCache(args []string) int {
+	var usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s cache clear [-config file | -d directory]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s cache prune [-age duration] [-config file | -d directory]\n", os.Args[0])
+	}
+	if len(args) == 0 || (args[0] != "clear" && args[0] != "prune") {
+		usage()
+		return EXIT_ERROR
+	}
+
+	fs := flag.NewFlagSet("cache "+args[0], flag.ExitOnError)
+	configFile := fs.String("config", "", "Configuration file with the cache directory")
+	dirname := fs.String("d", ".", "Directory to discover the configuration file from")
+	var age *time.Duration
+	if args[0] == "prune" {
+		age = fs.Duration("age", 30*24*time.Hour, "Remove the entries not used for this long")
+	}
+	fs.Parse(args[1:])
+
+	cfg, err := loadConfig(*configFile, *dirname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load configuration: %v\n", err)
+		return EXIT_ERROR
+	}
+	// The entries of every version & configuration are in the same directory
+	c, err := cache.Open(cfg.Cache.Dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open cache: %v\n", err)
+		return EXIT_ERROR
+	}
+	if age == nil {
+		if err := c.Clear(); err != nil {
+			fmt.Fprintf(os.Stderr, "clear cache: %v\n", err)
+			return EXIT_ERROR
+		}
+		fmt.Printf("Cleared %s\n", c.Dir())
+		return EXIT_OK
+	}
+	removed, err := c.Prune(*age)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prune cache: %v\n", err)
+		return EXIT_ERROR
+	}
+	fmt.Printf("Removed %d entries from %s\n", removed, c.Dir())
+	return EXIT_OK
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/git"
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// The changes of the working tree since the merge base with a base ref (eg.: the target
+// branch of a pull request)
+type changeSet struct {
+	*repoLog
+	ref       string
+	mergeBase string
+	files     map[string]git.FileChange // Changed .go files, relative to the analysed directory
+}
+
+func readChangeSet(dir string, ref string) (*changeSet, error) {
+	rl, err := openRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	mergeBase, err := git.MergeBase(rl.repo, ref, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	changes, err := git.Diff(rl.repo, mergeBase, rl.subDir)
+	if err != nil {
+		return nil, err
+	}
+	var cs = changeSet{repoLog: rl, ref: ref, mergeBase: mergeBase, files: map[string]git.FileChange{}}
+	for _, fc := range changes {
+		if rel, ok := rl.relPath(fc.Path); ok {
+			cs.files[rel] = fc
+		}
+	}
+	// New files that are not added yet, git diff leaves them out
+	untracked, err := git.UntrackedFiles(rl.repo, rl.subDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range untracked {
+		if rel, ok := rl.relPath(path); ok {
+			cs.files[rel] = createdFile(filepath.Join(rl.repo, filepath.FromSlash(path)), path)
+		}
+	}
+	return &cs, nil
+}
+
+// An untracked file as a change that adds every line of it
+func createdFile(fileName string, path string) git.FileChange {
+	var fc = git.FileChange{Path: path, Created: true}
+	if src, err := os.ReadFile(fileName); err == nil {
+		fc.Added = bytes.Count(src, []byte{'\n'})
+		if len(src) > 0 && src[len(src)-1] != '\n' {
+			fc.Added++
+		}
+	}
+	fc.Hunks = []git.Hunk{{Start: 1, Lines: fc.Added}}
+	return fc
+}
+
+// Selects the changed files that still exist, for analyseOnly
+func (cs *changeSet) selected(rel string) bool {
+	fc, ok := cs.files[filepath.ToSlash(rel)]
+	return ok && !fc.Deleted
+}
+
+type changedReport struct {
+	Ref       string
+	MergeBase string
+	Files     int                     // Nr of changed files that are measured
+	Functions []metrics.FunctionDelta // The functions touched by the changes
+	// The changed generated files, they are only listed unless the generated files are included
+	Generated []string
+	// The changed files whose merge base version doesn't parse, their functions count as added
+	Unparsed []string
+}
+
+// The functions of the changed files whose lines overlap a hunk, with their metrics before &
+// after. The before version is parsed from the merge base. Only the files the selection of
+// the configuration measures are compared, the generated ones are listed separately.
+func computeChanged(cs *changeSet, root string, cfg *config.Config, fileMetrics []metrics.FileMetric, generated engine.Generated) (changedReport, error) {
+	var report = changedReport{Ref: cs.ref, MergeBase: git.Commit{Hash: cs.mergeBase}.ShortHash(), Generated: []string{}, Unparsed: []string{}}
+	var after = map[string]metrics.FileSnapshot{}
+	for i := range fileMetrics {
+		fs := fileMetrics[i].Snapshot(root)
+		after[fs.Path] = fs
+	}
+	var skipped = map[string]bool{}
+	if !generated.Included {
+		for _, rel := range generated.Files {
+			skipped[rel] = true
+		}
+	}
+
+	for _, rel := range slices.Sorted(maps.Keys(cs.files)) {
+		fc := cs.files[rel]
+		current, measured := after[rel]
+		switch {
+		case skipped[rel]:
+			report.Generated = append(report.Generated, rel)
+			continue
+		case fc.Deleted:
+			// Gone from the disk, it's compared if it would still be selected
+			if !engine.Measured(root, filepath.Join(root, filepath.FromSlash(rel)), cfg) {
+				continue
+			}
+		case !measured:
+			continue
+		}
+		before, gen, err := cs.baseFunctions(root, rel, fc, cfg)
+		var syntax scanner.ErrorList
+		switch {
+		case errors.As(err, &syntax):
+			// Compared as if it was created
+			report.Unparsed = append(report.Unparsed, rel)
+			before, gen = map[string]*metrics.FunctionSnapshot{}, cfg.GeneratedPath(rel)
+		case err != nil:
+			return report, err
+		}
+		if gen && !cfg.Generated.Include {
+			report.Generated = append(report.Generated, rel)
+			continue
+		}
+		report.Files++
+		report.Functions = append(report.Functions, touchedFunctions(rel, fc, before, current.Functions)...)
+	}
+	return report, nil
+}
+
+// The functions of the merge base version of a changed file by ID, none if it's created, and
+// whether that version is generated
+func (cs *changeSet) baseFunctions(root string, rel string, fc git.FileChange, cfg *config.Config) (map[string]*metrics.FunctionSnapshot, bool, error) {
+	var before = map[string]*metrics.FunctionSnapshot{}
+	if fc.Created {
+		return before, false, nil
+	}
+	fs, err := cs.baseSnapshot(root, rel, cfg.DisabledMetrics())
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range fs.Functions {
+		before[fs.Functions[i].ID] = &fs.Functions[i]
+	}
+	return before, fs.Generated || cfg.GeneratedPath(rel), nil
+}
+
+// The deltas of the functions overlapping a hunk of the change, and the removed ones
+func touchedFunctions(rel string, fc git.FileChange, before map[string]*metrics.FunctionSnapshot, after []metrics.FunctionSnapshot) []metrics.FunctionDelta {
+	var deltas []metrics.FunctionDelta
+	var current = map[string]bool{}
+	for i := range after {
+		f := &after[i]
+		current[f.ID] = true
+		if slices.ContainsFunc(fc.Hunks, func(h git.Hunk) bool { return h.Overlaps(f.StartLine, f.EndLine) }) {
+			deltas = append(deltas, metrics.NewFunctionDelta(rel, f.ID, before[f.ID], f))
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(before)) {
+		if !current[id] {
+			deltas = append(deltas, metrics.NewFunctionDelta(rel, id, before[id], nil))
+		}
+	}
+	return deltas
+}
+
+// Measures the merge base version of a changed file
+func (cs *changeSet) baseSnapshot(root string, rel string, disabled []string) (metrics.FileSnapshot, error) {
+	var repoPath = rel
+	if cs.subDir != "." {
+		repoPath = cs.subDir + "/" + rel
+	}
+	src, err := git.Show(cs.repo, cs.mergeBase, repoPath)
+	if err != nil {
+		return metrics.FileSnapshot{}, err
+	}
+	// Named as the current version so that the paths match
+	var fileName = filepath.Join(root, filepath.FromSlash(rel))
+	var fset = token.NewFileSet()
+	tree, err := parser.ParseFile(fset, fileName, src, parser.AllErrors)
+	if err != nil {
+		return metrics.FileSnapshot{}, fmt.Errorf("parse %s at %s: %w", rel, cs.mergeBase, err)
+	}
+	fm := metrics.NewFileMetric(fileName)
+	// cloc would read the current version from the disk, and only the functions are compared
+	fm.Disable(append(slices.Clone(disabled), metrics.METRIC_LOC)...)
+	fm.GenerateMetrics(fset, tree)
+	fm.SetGenerated(ast.IsGenerated(tree))
+	return fm.Snapshot(root), nil
+}
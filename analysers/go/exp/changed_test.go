@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+func gitCmd(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	var cmd = exec.Command("git", append([]string{"-c", "user.name=T", "-c", "user.email=t@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func writeSource(t *testing.T, dir string, name string, src string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A file that doesn't parse at the merge base counts as created, the untracked files are
+// changed too
+func TestChangedSinceBase(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	var dir = t.TempDir()
+	gitCmd(t, dir, "init", "-q", "-b", "main")
+	writeSource(t, dir, "broken.go", "package p\n\nfunc Broken( {\n}\n")
+	writeSource(t, dir, "kept.go", "package p\n\nfunc Kept() int { return 1 }\n")
+	gitCmd(t, dir, "add", ".")
+	gitCmd(t, dir, "commit", "-q", "-m", "base")
+	gitCmd(t, dir, "checkout", "-q", "-b", "feature")
+	writeSource(t, dir, "broken.go", "package p\n\nfunc Broken() int {\n\treturn 0\n}\n")
+	writeSource(t, dir, "new.go", "package p\n\nfunc New() int {\n\treturn 2\n}\n")
+
+	cs, err := readChangeSet(dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.selected("new.go") || !cs.files["new.go"].Created || cs.selected("kept.go") {
+		t.Fatalf("got the changes %+v", cs.files)
+	}
+	cfg, err := loadConfig("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := analyseOnly(context.Background(), dir, &cfg, cs.selected, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := computeChanged(cs, dir, &cfg, report.FileMetrics(), report.Generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Files != 2 || !reflect.DeepEqual(changed.Unparsed, []string{"broken.go"}) {
+		t.Errorf("got %d files, unparsed %v", changed.Files, changed.Unparsed)
+	}
+	var functions []string
+	for _, f := range changed.Functions {
+		if f.Status != metrics.STATUS_ADDED {
+			t.Errorf("%s: %s, expected added", f.ID, f.Status)
+		}
+		functions = append(functions, f.Path+":"+f.ID)
+	}
+	if !reflect.DeepEqual(functions, []string{"broken.go:Broken", "new.go:New"}) {
+		t.Errorf("got the functions %v", functions)
+	}
+}
@@ -0,0 +1,410 @@
+// Package config holds the project level configuration of the analyser.
+//
+// The configuration is read from a YAML file that is discovered by walking upward from the
+// analysed directory; every value that is not set in the file keeps its default.
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v3"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// The file names searched for, in order of preference
+var FILE_NAMES = []string{".code-stats.yaml", ".code-stats.yml"}
+
+// Report formats
+const (
+	FORMAT_MARKDOWN    string = "markdown"
+	FORMAT_JSON        string = "json"
+	FORMAT_OPENMETRICS string = "openmetrics" // For the Prometheus textfile collector or a pushgateway
+)
+
+var Formats = []string{FORMAT_MARKDOWN, FORMAT_JSON, FORMAT_OPENMETRICS}
+
+// Symbolic link policies
+const (
+	SYMLINKS_SKIP   string = "skip"   // Neither files nor directories
+	SYMLINKS_FILES  string = "files"  // Files only
+	SYMLINKS_FOLLOW string = "follow" // Files & directories, loops are skipped
+)
+
+var SymlinkPolicies = []string{SYMLINKS_SKIP, SYMLINKS_FILES, SYMLINKS_FOLLOW}
+
+type Config struct {
+	Include         []string              `yaml:"include"`          // Patterns (doublestar) of files to analyse, everything if empty
+	Exclude         []string              `yaml:"exclude"`          // Patterns of files & directories to skip
+	Gitignore       bool                  `yaml:"gitignore"`        // Skip what git ignores (.gitignore, .git/info/exclude)
+	DefaultExcludes bool                  `yaml:"default_excludes"` // Skip vendor, testdata, hidden & _ directories, as the go tool does
+	Symlinks        string                `yaml:"symlinks"`         // The symbolic links to follow, see SymlinkPolicies
+	Workers         int                   `yaml:"workers"`          // Nr of workers, 0 means a share of the available cores
+	FileTimeout     time.Duration         `yaml:"file_timeout"`     // The limit of measuring a file (eg.: 30s), none if 0
+	KeepGoing       bool                  `yaml:"keep_going"`       // List the files that can't be measured instead of failing
+	Tolerant        bool                  `yaml:"tolerant"`         // Measure what parsed of the files with syntax errors, implies keep_going
+	Metrics         []string              `yaml:"metrics"`          // Enabled metric groups, see metrics.MetricGroups
+	DisabledPlugins []string              `yaml:"disabled_plugins"` // Registered plugins not to measure, see metrics.Register
+	Backends        []Backend             `yaml:"backends"`         // External analysers of the other languages
+	Settings        metrics.Settings      `yaml:",inline"`
+	Gate            Gate                  `yaml:"gate"`
+	Ratchet         metrics.RatchetConfig `yaml:"ratchet"`
+	Hotspots        Hotspots              `yaml:"hotspots"`
+	Coupling        Coupling              `yaml:"coupling"`
+	Ownership       Ownership             `yaml:"ownership"`
+	Cache           Cache                 `yaml:"cache"`
+	Streaming       Streaming             `yaml:"streaming"`
+	Generated       Generated             `yaml:"generated"`
+	Output          Output                `yaml:"output"`
+}
+
+// An external analyser, speaking the JSON-lines protocol of the analyzer package over
+// stdin/stdout (eg.: analysers/py)
+type Backend struct {
+	Language   string   `yaml:"language"`
+	Extensions []string `yaml:"extensions"` // The files it measures, eg.: [".py"]
+	Command    []string `yaml:"command"`    // Started once per run (and worker)
+	Dir        string   `yaml:"dir"`        // The working directory of the command, the current one if empty
+}
+
+type Gate struct {
+	Enabled    bool                `yaml:"enabled"`
+	Thresholds []metrics.Threshold `yaml:"thresholds"`
+}
+
+// Churn x complexity analysis over the local git history
+type Hotspots struct {
+	Enabled    bool   `yaml:"enabled"`
+	Since      string `yaml:"since"`       // The window, anything 'git log --since' accepts
+	MaxCommits int    `yaml:"max_commits"` // Limits the window, 0 means no limit
+	Top        int    `yaml:"top"`         // Nr of files & functions in the ranking
+}
+
+// Temporal (change) coupling between files over the local git history
+type Coupling struct {
+	Enabled      bool    `yaml:"enabled"`
+	Since        string  `yaml:"since"`         // The window, anything 'git log --since' accepts
+	MaxCommits   int     `yaml:"max_commits"`   // Limits the window, 0 means no limit
+	MinShared    int     `yaml:"min_shared"`    // Min nr of commits changing both files
+	MinDegree    float64 `yaml:"min_degree"`    // Min degree of coupling, in percent
+	MaxChangeset int     `yaml:"max_changeset"` // Commits changing more files are ignored (eg.: reformatting)
+	Top          int     `yaml:"top"`           // Nr of pairs listed
+}
+
+// Code ownership from the blame of the analysed files
+type Ownership struct {
+	Enabled bool `yaml:"enabled"`
+	// The authors (email or name) that left, their lines count as knowledge loss
+	Departed []string `yaml:"departed"`
+	// The share of the lines above which a file is considered to have a single owner
+	SingleOwnerShare float64 `yaml:"single_owner_share"`
+	Top              int     `yaml:"top"` // Nr of files in the single owner list
+}
+
+// The on-disk cache of the file metrics, keyed by the content of the files
+type Cache struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"` // The user cache directory if empty
+}
+
+// Fold the files into the summaries as they are measured, instead of keeping every file in
+// memory. There are no per file results then: no snapshot, baseline, history or changed-code
+// views.
+type Streaming struct {
+	Enabled   bool   `yaml:"enabled"`
+	Quantiles string `yaml:"quantiles"` // The estimator of the medians & percentiles, see metrics.Quantiles
+}
+
+// Generated code: the files with the standard '// Code generated ... DO NOT EDIT.' header
+// and the ones matching the patterns. They are left out of the metrics, only their volume is
+// reported, unless they are included.
+type Generated struct {
+	Include  bool     `yaml:"include"`  // Measure them with the rest of the files
+	Patterns []string `yaml:"patterns"` // Patterns (doublestar) of generated files without the header
+}
+
+type Output struct {
+	Formats []string `yaml:"formats"`
+	// The file to write the report into, stdout if empty. With more than one format the
+	// extension is replaced for each of them.
+	File     string `yaml:"file"`
+	Database string `yaml:"database"` // SQLite database every run is appended to, none if empty
+}
+
+func Default() Config {
+	var settings = metrics.DefaultSettings()
+	return Config{
+		Include:         []string{},
+		Exclude:         []string{},
+		Gitignore:       true,
+		DefaultExcludes: true,
+		Symlinks:        SYMLINKS_FILES,
+		Metrics:         slices.Clone(metrics.MetricGroups),
+		DisabledPlugins: []string{},
+		Backends:        []Backend{},
+		Settings:        settings,
+		Gate: Gate{
+			Thresholds: metrics.DefaultGateConfig(settings.Levels).Thresholds,
+		},
+		Ratchet: metrics.DefaultRatchetConfig(),
+		Hotspots: Hotspots{
+			Since: "6 months ago",
+			Top:   10,
+		},
+		Coupling: Coupling{
+			Since:        "6 months ago",
+			MinShared:    3,
+			MinDegree:    30,
+			MaxChangeset: 30,
+			Top:          10,
+		},
+		Ownership: Ownership{
+			Departed:         []string{},
+			SingleOwnerShare: 0.8,
+			Top:              10,
+		},
+		Cache: Cache{
+			Enabled: true,
+		},
+		Streaming: Streaming{
+			Quantiles: metrics.QUANTILES_TDIGEST,
+		},
+		Generated: Generated{
+			Patterns: []string{},
+		},
+		Output: Output{
+			Formats: []string{FORMAT_MARKDOWN},
+		},
+	}
+}
+
+// Searches for a configuration file in dir and its parents. Returns an empty string when
+// there is none.
+func Discover(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range FILE_NAMES {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Reads the configuration file over the defaults and validates the result
+func Load(fileName string) (cfg Config, err error) {
+	cfg = Default()
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	var decoder = yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// The thresholds of the file replace the defaults rather than being merged into them
+	cfg.Gate.Thresholds = nil
+	if err = decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("parse %q: %w", fileName, err)
+	}
+	if cfg.Gate.Thresholds == nil {
+		cfg.Gate.Thresholds = metrics.DefaultGateConfig(cfg.Settings.Levels).Thresholds
+	}
+	if err = cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid %q: %w", fileName, err)
+	}
+	return cfg, nil
+}
+
+// Discovers & loads the configuration for dir, or returns the defaults if there is none
+func ForDir(dir string) (cfg Config, fileName string, err error) {
+	fileName, err = Discover(dir)
+	if err != nil || fileName == "" {
+		return Default(), fileName, err
+	}
+	cfg, err = Load(fileName)
+	return
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.Workers < 0 {
+		return fmt.Errorf("workers: has to be >= 0, got %d", cfg.Workers)
+	}
+	if cfg.FileTimeout < 0 {
+		return fmt.Errorf("file_timeout: has to be >= 0, got %v", cfg.FileTimeout)
+	}
+	for _, pattern := range slices.Concat(cfg.Include, cfg.Exclude, cfg.Generated.Patterns) {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("pattern %q: %w", pattern, doublestar.ErrBadPattern)
+		}
+	}
+	if !slices.Contains(SymlinkPolicies, cfg.Symlinks) {
+		return fmt.Errorf("symlinks: unknown policy %q, expected one of %v", cfg.Symlinks, SymlinkPolicies)
+	}
+	for _, m := range cfg.Metrics {
+		if !slices.Contains(metrics.MetricGroups, m) {
+			return fmt.Errorf("metrics: unknown metric group %q, expected one of %v", m, metrics.MetricGroups)
+		}
+	}
+	for _, m := range metrics.RequiredMetricGroups {
+		if !slices.Contains(cfg.Metrics, m) {
+			return fmt.Errorf("metrics: %q can't be left out, %v are always measured", m, metrics.RequiredMetricGroups)
+		}
+	}
+	for _, p := range cfg.DisabledPlugins {
+		if !slices.Contains(metrics.PluginNames(), p) {
+			return fmt.Errorf("disabled_plugins: %q is not a registered plugin, expected one of %v", p, metrics.PluginNames())
+		}
+	}
+	if err := validateBackends(cfg.Backends); err != nil {
+		return fmt.Errorf("backends: %w", err)
+	}
+	if !slices.Contains(metrics.Quantiles, cfg.Streaming.Quantiles) {
+		return fmt.Errorf("streaming.quantiles: unknown estimator %q, expected one of %v", cfg.Streaming.Quantiles, metrics.Quantiles)
+	}
+	if len(cfg.Output.Formats) == 0 {
+		return fmt.Errorf("output.formats: at least one format is needed")
+	}
+	for _, f := range cfg.Output.Formats {
+		if !slices.Contains(Formats, f) {
+			return fmt.Errorf("output.formats: unknown format %q, expected one of %v", f, Formats)
+		}
+	}
+	if err := cfg.Settings.Validate(); err != nil {
+		return err
+	}
+	var gc = cfg.GateConfig()
+	if err := gc.Validate(); err != nil {
+		return fmt.Errorf("gate: %w", err)
+	}
+	if err := cfg.Ratchet.Validate(); err != nil {
+		return fmt.Errorf("ratchet: %w", err)
+	}
+	if cfg.Hotspots.MaxCommits < 0 || cfg.Hotspots.Top <= 0 {
+		return fmt.Errorf("hotspots: max_commits has to be >= 0 and top > 0")
+	}
+	if c := cfg.Coupling; c.MaxCommits < 0 || c.MinShared < 1 || c.MinDegree < 0 || c.MinDegree > 100 || c.MaxChangeset < 2 || c.Top <= 0 {
+		return fmt.Errorf("coupling: expected max_commits >= 0, min_shared >= 1, 0 <= min_degree <= 100, max_changeset >= 2 and top > 0")
+	}
+	if o := cfg.Ownership; o.SingleOwnerShare <= 0 || o.SingleOwnerShare > 1 || o.Top <= 0 {
+		return fmt.Errorf("ownership: expected 0 < single_owner_share <= 1 and top > 0")
+	}
+	return nil
+}
+
+func validateBackends(backends []Backend) error {
+	var languages = map[string]bool{metrics.LANGUAGE_GO: true}
+	var extensions = map[string]bool{".go": true}
+	for _, b := range backends {
+		if b.Language == "" || languages[b.Language] {
+			return fmt.Errorf("language %q is empty or taken", b.Language)
+		}
+		languages[b.Language] = true
+		if len(b.Command) == 0 {
+			return fmt.Errorf("%s: the command is empty", b.Language)
+		}
+		if len(b.Extensions) == 0 {
+			return fmt.Errorf("%s: at least one extension is needed", b.Language)
+		}
+		for _, ext := range b.Extensions {
+			if len(ext) < 2 || ext[0] != '.' || extensions[ext] {
+				return fmt.Errorf("%s: extension %q is invalid or taken", b.Language, ext)
+			}
+			extensions[ext] = true
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) GateConfig() metrics.GateConfig {
+	return metrics.GateConfig{Thresholds: cfg.Gate.Thresholds}
+}
+
+// The metric groups that are not enabled and the disabled plugins
+func (cfg *Config) DisabledMetrics() (disabled []string) {
+	for _, m := range metrics.MetricGroups {
+		if !slices.Contains(cfg.Metrics, m) {
+			disabled = append(disabled, m)
+		}
+	}
+	return append(disabled, cfg.DisabledPlugins...)
+}
+
+func (cfg *Config) Enabled(group string) bool {
+	return slices.Contains(cfg.Metrics, group)
+}
+
+// Reports if the path (relative to the analysed directory) passes the include & exclude
+// patterns, see matchAny
+func (cfg *Config) Selected(relPath string) bool {
+	return cfg.Included(relPath) && !cfg.Excluded(relPath)
+}
+
+// Whether the file matches the include patterns, if there are any
+func (cfg *Config) Included(relPath string) bool {
+	return len(cfg.Include) == 0 || matchAny(cfg.Include, relPath)
+}
+
+// Whether the file or directory matches one of the exclude patterns
+func (cfg *Config) Excluded(relPath string) bool {
+	return matchAny(cfg.Exclude, relPath)
+}
+
+// Whether the file matches one of the generated patterns
+func (cfg *Config) GeneratedPath(relPath string) bool {
+	return matchAny(cfg.Generated.Patterns, relPath)
+}
+
+// The patterns are matched against the whole relative path, the ones without a '/' against
+// the name too
+func matchAny(patterns []string, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, p := range patterns {
+		if doublestar.MatchUnvalidated(p, relPath) {
+			return true
+		}
+		if !strings.Contains(p, "/") && doublestar.MatchUnvalidated(p, path.Base(relPath)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identifies the effective configuration: the hash of its YAML form
+func (cfg *Config) Hash() (string, error) {
+	data, err := cfg.YAML()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (cfg *Config) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+	var encoder = yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+func writeFile(t *testing.T, fileName string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	var cfg = Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	var defaultThresholds = Default().Gate.Thresholds
+	for _, tc := range []struct {
+		name    string
+		content string
+		check   func(t *testing.T, cfg Config)
+		err     string // Part of the error, none if empty
+	}{
+		{
+			name:    "empty file keeps the defaults",
+			content: "",
+			check: func(t *testing.T, cfg Config) {
+				if !reflect.DeepEqual(cfg, Default()) {
+					t.Errorf("got %+v, expected the defaults", cfg)
+				}
+			},
+		},
+		{
+			name:    "values over the defaults",
+			content: "workers: 3\nfile_timeout: 30s\nexclude: [gen/**]\nhotspots:\n  top: 5\n",
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Workers != 3 || cfg.FileTimeout != 30*time.Second || !reflect.DeepEqual(cfg.Exclude, []string{"gen/**"}) {
+					t.Errorf("got workers %d, file_timeout %v, exclude %v", cfg.Workers, cfg.FileTimeout, cfg.Exclude)
+				}
+				// The rest of the section keeps its defaults
+				if cfg.Hotspots.Top != 5 || cfg.Hotspots.Since != Default().Hotspots.Since {
+					t.Errorf("got hotspots %+v", cfg.Hotspots)
+				}
+			},
+		},
+		{
+			name:    "thresholds replace the defaults",
+			content: "gate:\n  thresholds:\n    - scope: project\n      metric: cc_p95\n      max: 4\n",
+			check: func(t *testing.T, cfg Config) {
+				var max = 4.0
+				var expected = []metrics.Threshold{{Scope: metrics.SCOPE_PROJECT, Metric: "cc_p95", Max: &max}}
+				if !reflect.DeepEqual(cfg.Gate.Thresholds, expected) {
+					t.Errorf("got %+v, expected %+v", cfg.Gate.Thresholds, expected)
+				}
+			},
+		},
+		{
+			name:    "gate without thresholds keeps the default ones",
+			content: "gate:\n  enabled: true\n",
+			check: func(t *testing.T, cfg Config) {
+				if !cfg.Gate.Enabled || !reflect.DeepEqual(cfg.Gate.Thresholds, defaultThresholds) {
+					t.Errorf("got %+v", cfg.Gate)
+				}
+			},
+		},
+		{
+			name:    "unknown key",
+			content: "worker: 3\n",
+			err:     "field worker not found",
+		},
+		{
+			name:    "unknown nested key",
+			content: "cache:\n  enable: false\n",
+			err:     "field enable not found",
+		},
+		{
+			name:    "invalid value",
+			content: "workers: -1\n",
+			err:     "workers: has to be >= 0",
+		},
+		{
+			name:    "not YAML",
+			content: "workers: [\n",
+			err:     "parse",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var fileName = filepath.Join(t.TempDir(), FILE_NAMES[0])
+			writeFile(t, fileName, tc.content)
+			cfg, err := Load(fileName)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, expected one with %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, cfg)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(cfg *Config)
+		err    string // Part of the error, none if empty
+	}{
+		{"defaults", func(cfg *Config) {}, ""},
+		{"without loc & halstead", func(cfg *Config) { cfg.Metrics = []string{metrics.METRIC_CC, metrics.METRIC_ABC} }, ""},
+		{"without cc", func(cfg *Config) { cfg.Metrics = []string{metrics.METRIC_LOC, metrics.METRIC_ABC} }, `"cc" can't be left out`},
+		{"without abc", func(cfg *Config) { cfg.Metrics = []string{metrics.METRIC_CC, metrics.METRIC_HALSTEAD} }, `"abc" can't be left out`},
+		{"no metric group", func(cfg *Config) { cfg.Metrics = []string{} }, `"cc" can't be left out`},
+		{"unknown metric group", func(cfg *Config) { cfg.Metrics = append(cfg.Metrics, "lines") }, `unknown metric group "lines"`},
+		{"unknown plugin", func(cfg *Config) { cfg.DisabledPlugins = []string{"nope"} }, `"nope" is not a registered plugin`},
+		{"negative timeout", func(cfg *Config) { cfg.FileTimeout = -time.Second }, "file_timeout"},
+		{"bad pattern", func(cfg *Config) { cfg.Exclude = []string{"gen/[a"} }, `pattern "gen/[a"`},
+		{"unknown symlink policy", func(cfg *Config) { cfg.Symlinks = "all" }, `unknown policy "all"`},
+		{"unknown quantiles", func(cfg *Config) { cfg.Streaming.Quantiles = "p2" }, `unknown estimator "p2"`},
+		{"no format", func(cfg *Config) { cfg.Output.Formats = nil }, "at least one format"},
+		{"unknown format", func(cfg *Config) { cfg.Output.Formats = []string{"xml"} }, `unknown format "xml"`},
+		{"unknown gate metric", func(cfg *Config) {
+			cfg.Gate.Thresholds = []metrics.Threshold{{Scope: metrics.SCOPE_FILE, Metric: "nope", Max: new(float64)}}
+		}, "gate: "},
+		{"backend without command", func(cfg *Config) {
+			cfg.Backends = []Backend{{Language: "python", Extensions: []string{".py"}}}
+		}, "the command is empty"},
+		{"backend taking .go", func(cfg *Config) {
+			cfg.Backends = []Backend{{Language: "gopy", Extensions: []string{".go"}, Command: []string{"x"}}}
+		}, `extension ".go" is invalid or taken`},
+		{"backend of go", func(cfg *Config) {
+			cfg.Backends = []Backend{{Language: metrics.LANGUAGE_GO, Extensions: []string{".g"}, Command: []string{"x"}}}
+		}, "is empty or taken"},
+		{"coupling degree", func(cfg *Config) { cfg.Coupling.MinDegree = 120 }, "coupling:"},
+		{"ownership share", func(cfg *Config) { cfg.Ownership.SingleOwnerShare = 0 }, "ownership:"},
+		{"hotspots top", func(cfg *Config) { cfg.Hotspots.Top = 0 }, "hotspots:"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg = Default()
+			tc.change(&cfg)
+			var err = cfg.Validate()
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("got error %v, expected one with %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	var root = t.TempDir()
+	var sub = filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	found, err := Discover(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A configuration above the temporary directory would be found too
+	if strings.HasPrefix(found, root) {
+		t.Fatalf("found %s without a configuration file", found)
+	}
+
+	writeFile(t, filepath.Join(root, ".code-stats.yml"), "workers: 1\n")
+	for _, tc := range []struct {
+		name     string
+		setup    func()
+		dir      string
+		expected string
+	}{
+		{"from a parent", func() {}, sub, filepath.Join(root, ".code-stats.yml")},
+		{"the closest one", func() { writeFile(t, filepath.Join(root, "a", ".code-stats.yaml"), "workers: 2\n") }, sub, filepath.Join(root, "a", ".code-stats.yaml")},
+		{".yaml before .yml", func() { writeFile(t, filepath.Join(root, ".code-stats.yaml"), "workers: 3\n") }, root, filepath.Join(root, ".code-stats.yaml")},
+		{"a directory is not a file", func() {
+			if err := os.MkdirAll(filepath.Join(sub, ".code-stats.yaml"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}, sub, filepath.Join(root, "a", ".code-stats.yaml")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.setup()
+			found, err := Discover(tc.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tc.expected {
+				t.Errorf("found %q, expected %q", found, tc.expected)
+			}
+		})
+	}
+
+	cfg, fileName, err := ForDir(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileName != filepath.Join(root, "a", ".code-stats.yaml") || cfg.Workers != 2 {
+		t.Errorf("loaded %s with %d workers, expected the one of 'a' with 2", fileName, cfg.Workers)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zkulcsar/metrics/exp/config"
+)
+
+// Handles the 'config' subcommand: 'config validate' and 'config print-defaults'
+func runConfig(args []string) int {
+	var usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s config validate [-config file | -d directory]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s config print-defaults\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return EXIT_ERROR
+	}
+
+	switch args[0] {
+	case "print-defaults":
+		var cfg = config.Default()
+		out, err := cfg.YAML()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marshal defaults: %v\n", err)
+			return EXIT_ERROR
+		}
+		os.Stdout.Write(out)
+		return EXIT_OK
+	case "validate":
+		fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+		configFile := fs.String("config", "", "Configuration file to validate")
+		dirname := fs.String("d", ".", "Directory to discover the configuration file from")
+		fs.Parse(args[1:])
+
+		var fileName = *configFile
+		if fileName == "" {
+			var err error
+			if fileName, err = config.Discover(*dirname); err != nil {
+				fmt.Fprintf(os.Stderr, "discover configuration: %v\n", err)
+				return EXIT_ERROR
+			}
+			if fileName == "" {
+				fmt.Fprintf(os.Stderr, "no configuration file found from %q upward\n", *dirname)
+				return EXIT_ERROR
+			}
+		}
+		if _, err := config.Load(fileName); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return EXIT_ERROR
+		}
+		fmt.Printf("%s is valid\n", fileName)
+		return EXIT_OK
+	default:
+		usage()
+		return EXIT_ERROR
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/git"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// Two files that changed together
+type couplingPair struct {
+	FileA        string // Relative to the analysed directory, FileA < FileB
+	FileB        string
+	Shared       int     // Nr of commits changing both
+	Degree       float64 // Shared commits / average nr of commits changing either, in percent
+	ConfidenceAB float64 // Shared commits / commits changing A, in percent
+	ConfidenceBA float64 // Shared commits / commits changing B, in percent
+	CrossPackage bool
+	// The static dependency between the packages of the files: "A → B", "B → A", "both" or
+	// empty if none of them imports the other
+	Dependency string
+}
+
+func (cp *couplingPair) Hidden() bool {
+	return cp.CrossPackage && cp.Dependency == ""
+}
+
+type couplingReport struct {
+	Since   string
+	Commits int // Nr of commits considered
+	Hidden  []couplingPair
+	Pairs   []couplingPair
+}
+
+// Finds the files of the current tree that repeatedly change in the same commits
+func computeCoupling(rl *repoLog, root string, fileMetrics []metrics.FileMetric, cfg config.Coupling) couplingReport {
+	var imports = packageImports(root, fileMetrics)
+	var current = map[string]bool{}
+	for _, fm := range fileMetrics {
+		if rel, err := filepath.Rel(root, fm.FileName()); err == nil {
+			current[filepath.ToSlash(rel)] = true
+		}
+	}
+
+	var report = couplingReport{Since: cfg.Since}
+	var revisions = map[string]int{}
+	var shared = map[[2]string]int{}
+	for _, change := range rl.changes {
+		var files []string
+		for _, fc := range change.Files {
+			if rel, ok := rl.relPath(fc.Path); ok && current[rel] {
+				files = append(files, rel)
+			}
+		}
+		if len(files) == 0 || len(files) > cfg.MaxChangeset {
+			continue
+		}
+		report.Commits++
+		sort.Strings(files)
+		for i, a := range files {
+			revisions[a]++
+			for _, b := range files[i+1:] {
+				shared[[2]string{a, b}]++
+			}
+		}
+	}
+
+	for pair, n := range shared {
+		if n < cfg.MinShared {
+			continue
+		}
+		a, b := pair[0], pair[1]
+		cp := couplingPair{
+			FileA:        a,
+			FileB:        b,
+			Shared:       n,
+			Degree:       100 * float64(n) / (float64(revisions[a]+revisions[b]) / 2),
+			ConfidenceAB: 100 * float64(n) / float64(revisions[a]),
+			ConfidenceBA: 100 * float64(n) / float64(revisions[b]),
+			CrossPackage: path.Dir(a) != path.Dir(b),
+		}
+		if cp.Degree < cfg.MinDegree {
+			continue
+		}
+		if cp.CrossPackage {
+			ab, ba := imports[path.Dir(a)][path.Dir(b)], imports[path.Dir(b)][path.Dir(a)]
+			switch {
+			case ab && ba:
+				cp.Dependency = "both"
+			case ab:
+				cp.Dependency = "A → B"
+			case ba:
+				cp.Dependency = "B → A"
+			}
+		}
+		report.Pairs = append(report.Pairs, cp)
+	}
+	sort.Slice(report.Pairs, func(i, j int) bool {
+		pi, pj := report.Pairs[i], report.Pairs[j]
+		if pi.Degree != pj.Degree {
+			return pi.Degree > pj.Degree
+		}
+		if pi.Shared != pj.Shared {
+			return pi.Shared > pj.Shared
+		}
+		return pi.FileA+pi.FileB < pj.FileA+pj.FileB
+	})
+	for _, cp := range report.Pairs {
+		if cp.Hidden() && len(report.Hidden) < cfg.Top {
+			report.Hidden = append(report.Hidden, cp)
+		}
+	}
+	if len(report.Pairs) > cfg.Top {
+		report.Pairs = report.Pairs[:cfg.Top]
+	}
+	return report
+}
+
+// The static import graph between the directories (relative to root, slash separated) of
+// the analysed files. Import paths are resolved with the module path of the closest go.mod.
+func packageImports(root string, fileMetrics []metrics.FileMetric) map[string]map[string]bool {
+	var graph = map[string]map[string]bool{}
+	modRoot, modPath := findModule(root)
+	if modPath == "" {
+		return graph
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return graph
+	}
+	// The import path of every analysed directory
+	var dirs = map[string]string{}
+	for _, fm := range fileMetrics {
+		rel, err := filepath.Rel(root, filepath.Dir(fm.FileName()))
+		if err != nil {
+			continue
+		}
+		inMod, err := filepath.Rel(modRoot, filepath.Join(absRoot, rel))
+		if err != nil {
+			continue
+		}
+		dirs[path.Join(modPath, filepath.ToSlash(inMod))] = filepath.ToSlash(rel)
+	}
+	for _, fm := range fileMetrics {
+		rel, err := filepath.Rel(root, filepath.Dir(fm.FileName()))
+		if err != nil {
+			continue
+		}
+		from := filepath.ToSlash(rel)
+		for _, imp := range fm.Imports() {
+			if to, ok := dirs[imp]; ok {
+				if graph[from] == nil {
+					graph[from] = map[string]bool{}
+				}
+				graph[from][to] = true
+			}
+		}
+	}
+	return graph
+}
+
+// Searches for go.mod in dir and its parents, returns its directory and the module path
+func findModule(dir string) (modRoot string, modPath string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", ""
+	}
+	for {
+		if f, err := os.Open(filepath.Join(dir, "go.mod")); err == nil {
+			defer f.Close()
+			var scanner = bufio.NewScanner(f)
+			for scanner.Scan() {
+				if rest, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+					return dir, strings.Trim(strings.TrimSpace(rest), `"`)
+				}
+			}
+			return dir, ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+func runCoupling(dir string, fileMetrics []metrics.FileMetric, cfg config.Coupling) (couplingReport, error) {
+	rl, err := readRepoLog(dir, git.LogOptions{Since: cfg.Since, MaxCommits: cfg.MaxCommits})
+	if err != nil {
+		return couplingReport{}, fmt.Errorf("read git log: %w", err)
+	}
+	return computeCoupling(rl, dir, fileMetrics, cfg), nil
+}
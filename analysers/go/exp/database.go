@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/git"
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/exp/store"
+)
+
+// Appends the run to the SQLite database of the configuration
+func saveRun(dir string, fileMetrics []metrics.FileMetric, snapshot *metrics.Snapshot, cfg *config.Config) (int64, error) {
+	configHash, err := cfg.Hash()
+	if err != nil {
+		return 0, err
+	}
+	var run = store.Run{
+		CreatedAt:       snapshot.CreatedAt,
+		Project:         snapshot.Project,
+		AnalyserVersion: metrics.ANALYSER_VERSION,
+		ConfigHash:      configHash,
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	run.Directory = absDir
+	if repo, err := git.TopLevel(dir); err == nil {
+		head, err := git.HeadOf(repo)
+		if err != nil {
+			return 0, err
+		}
+		run.Repository, run.Commit, run.Branch, run.Dirty = head.Remote, head.Commit, head.Branch, head.Dirty
+		if run.Repository == "" {
+			run.Repository = repo
+		}
+		if rel, err := filepath.Rel(repo, absDir); err == nil {
+			run.Directory = filepath.ToSlash(rel)
+		}
+	}
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now()
+	}
+
+	db, err := store.Open(cfg.Output.Database)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return db.Save(run, snapshot, metrics.PackageValues(dir, fileMetrics, cfg.Settings))
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// The flags of the analysis. The ones that are set explicitly override the configuration.
+type analysisFlags struct {
+	fs         *flag.FlagSet
+	dir        string
+	configFile string
+	// Measurements
+	workers     int
+	fileTimeout time.Duration
+	keepGoing   bool
+	tolerant    bool
+	stream      bool
+	quantiles   string
+	useCache    bool
+	// File selection
+	include          string
+	exclude          string
+	gitignore        bool
+	defaultExcludes  bool
+	symlinks         string
+	includeGenerated bool
+	base             string
+	// Output & gate
+	gate       bool
+	thresholds string
+	formats    string
+	output     string
+	database   string
+	// Snapshots
+	snapshotFile  string
+	baselineFile  string
+	ratchetFile   string
+	ratchetUpdate bool
+	// Git analyses
+	hotspots  bool
+	since     string
+	coupling  bool
+	ownership bool
+	departed  string
+	// Logging
+	logLevel  string
+	logFormat string
+	progress  string
+}
+
+// Parses the command line of the analysis and sets up the logging
+func parseAnalysisFlags(args []string) (*analysisFlags, error) {
+	var af = analysisFlags{fs: flag.NewFlagSet(os.Args[0], flag.ExitOnError)}
+	af.fs.StringVar(&af.dir, "d", "", "Directory containing Go files to parse")
+	af.fs.StringVar(&af.configFile, "config", "", "Configuration file (default: discovered upward from the directory)")
+	af.defineMeasurement()
+	af.defineSelection()
+	af.defineOutput()
+	af.defineGit()
+	af.defineLogging()
+	af.fs.Parse(args)
+
+	if err := setupLogging(af.logLevel, af.logFormat); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if af.dir == "" {
+		af.fs.Usage()
+		return nil, errors.New("invalid arguments: the directory (-d) is missing")
+	}
+	info, err := os.Stat(af.dir)
+	if err != nil {
+		return nil, fmt.Errorf("directory %q not found: %w", af.dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", af.dir)
+	}
+	if af.base != "" && (af.snapshotFile != "" || af.baselineFile != "" || af.ratchetFile != "") {
+		return nil, errors.New("-base can't be combined with -snapshot, -baseline or -ratchet, those need the whole tree")
+	}
+	return &af, nil
+}
+
+func (af *analysisFlags) defineMeasurement() {
+	// We default to engine.WORKER_PERCENT (80) percent of the available cores, unless it's explicitly set
+	af.fs.IntVar(&af.workers, "w", engine.DefaultWorkers(), "Nr of workers")
+	af.fs.DurationVar(&af.fileTimeout, "timeout", 0, "The limit of measuring a file, eg.: 30s (default: none)")
+	af.fs.BoolVar(&af.keepGoing, "keep-going", false, "List the files that can't be measured and report on the rest, instead of failing")
+	af.fs.BoolVar(&af.tolerant, "tolerant", false, "Measure what parsed of the files with syntax errors (implies -keep-going)")
+	af.fs.BoolVar(&af.stream, "stream", false, "Fold the files into the summaries as they are measured, memory doesn't grow with the nr of files")
+	af.fs.StringVar(&af.quantiles, "quantiles", "", "The estimator of the medians & percentiles of streaming: "+strings.Join(metrics.Quantiles, ", ")+" (default: tdigest)")
+	af.fs.BoolVar(&af.useCache, "cache", true, "Reuse the metrics of unchanged files from the on-disk cache")
+}
+
+func (af *analysisFlags) defineLogging() {
+	af.fs.StringVar(&af.logLevel, "log-level", "warn", "Log from this level on, to stderr: debug, info, warn or error")
+	af.fs.StringVar(&af.logFormat, "log-format", LOG_TEXT, "Log format: "+strings.Join(LogFormats, ", "))
+	af.fs.StringVar(&af.progress, "progress", PROGRESS_AUTO, "Progress reporting on stderr: "+strings.Join(ProgressModes, ", "))
+}
+
+func (af *analysisFlags) defineSelection() {
+	af.fs.StringVar(&af.include, "include", "", "Comma separated patterns (eg.: cmd/**/*.go) of files to analyse")
+	af.fs.StringVar(&af.exclude, "exclude", "", "Comma separated patterns of files & directories to skip")
+	af.fs.BoolVar(&af.gitignore, "gitignore", true, "Skip what git ignores (.gitignore, .git/info/exclude)")
+	af.fs.BoolVar(&af.defaultExcludes, "default-excludes", true, "Skip vendor, testdata, hidden & _ directories")
+	af.fs.StringVar(&af.symlinks, "symlinks", "", "The symbolic links to follow: "+strings.Join(config.SymlinkPolicies, ", ")+" (default: files)")
+	af.fs.BoolVar(&af.includeGenerated, "include-generated", false, "Measure the generated files with the rest, instead of only counting them")
+	af.fs.StringVar(&af.base, "base", "", "Only analyse the files changed since the merge base with this ref (eg.: main)")
+}
+
+func (af *analysisFlags) defineOutput() {
+	af.fs.BoolVar(&af.gate, "gate", false, "Evaluate the quality gate and exit with a non-zero code on violations")
+	af.fs.StringVar(&af.thresholds, "t", "", "YAML (or JSON) file with the quality gate thresholds (overrides the configuration)")
+	af.fs.StringVar(&af.formats, "f", "", "Comma separated report formats: "+strings.Join(config.Formats, ", "))
+	af.fs.StringVar(&af.output, "o", "", "File to write the report into (default: stdout)")
+	af.fs.StringVar(&af.database, "db", "", "SQLite database to append the run to")
+	af.fs.StringVar(&af.snapshotFile, "snapshot", "", "Save the full results of the run into this snapshot file")
+	af.fs.StringVar(&af.baselineFile, "baseline", "", "Snapshot file to compare the results to")
+	af.fs.StringVar(&af.ratchetFile, "ratchet", "", "Snapshot file to fail on regressions against (ratchet mode)")
+	af.fs.BoolVar(&af.ratchetUpdate, "ratchet-update", false, "Rewrite the ratchet snapshot when the metrics improved")
+}
+
+func (af *analysisFlags) defineGit() {
+	af.fs.BoolVar(&af.hotspots, "hotspots", false, "Rank the files & functions by churn (from the git log) x complexity")
+	af.fs.StringVar(&af.since, "since", "", "The window of the git history analyses, anything 'git log --since' accepts")
+	af.fs.BoolVar(&af.coupling, "coupling", false, "List the files that change together (from the git log)")
+	af.fs.BoolVar(&af.ownership, "ownership", false, "Report the ownership & bus factor of the packages (from git blame)")
+	af.fs.StringVar(&af.departed, "departed", "", "Comma separated authors (email or name) that left, for the ownership report")
+}
+
+// Loads the configuration, applies the explicitly set flags over it and validates the result
+func (af *analysisFlags) config() (config.Config, error) {
+	cfg, err := loadConfig(af.configFile, af.dir)
+	if err != nil {
+		return cfg, fmt.Errorf("load configuration: %w", err)
+	}
+	var setErr error
+	af.fs.Visit(func(f *flag.Flag) {
+		if err := af.override(&cfg, f.Name); err != nil && setErr == nil {
+			setErr = err
+		}
+	})
+	if setErr == nil {
+		setErr = cfg.Validate()
+	}
+	if setErr == nil {
+		setErr = af.validate(&cfg)
+	}
+	if setErr != nil {
+		return cfg, fmt.Errorf("invalid arguments: %w", setErr)
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = engine.DefaultWorkers()
+	}
+	return cfg, nil
+}
+
+// Sets the value of the flag in the configuration, if it's one of the overrides
+func (af *analysisFlags) override(cfg *config.Config, name string) error {
+	af.overrideMeasurement(cfg, name)
+	af.overrideSelection(cfg, name)
+	af.overrideGit(cfg, name)
+	return af.overrideOutput(cfg, name)
+}
+
+func (af *analysisFlags) overrideMeasurement(cfg *config.Config, name string) {
+	switch name {
+	case "w":
+		cfg.Workers = af.workers
+	case "timeout":
+		cfg.FileTimeout = af.fileTimeout
+	case "keep-going":
+		cfg.KeepGoing = af.keepGoing
+	case "tolerant":
+		cfg.Tolerant = af.tolerant
+	case "stream":
+		cfg.Streaming.Enabled = af.stream
+	case "quantiles":
+		cfg.Streaming.Quantiles = af.quantiles
+	case "cache":
+		cfg.Cache.Enabled = af.useCache
+	}
+}
+
+func (af *analysisFlags) overrideSelection(cfg *config.Config, name string) {
+	switch name {
+	case "include":
+		cfg.Include = splitList(af.include)
+	case "exclude":
+		cfg.Exclude = splitList(af.exclude)
+	case "gitignore":
+		cfg.Gitignore = af.gitignore
+	case "default-excludes":
+		cfg.DefaultExcludes = af.defaultExcludes
+	case "symlinks":
+		cfg.Symlinks = af.symlinks
+	case "include-generated":
+		cfg.Generated.Include = af.includeGenerated
+	}
+}
+
+func (af *analysisFlags) overrideOutput(cfg *config.Config, name string) error {
+	switch name {
+	case "gate":
+		cfg.Gate.Enabled = af.gate
+	case "t":
+		gc, err := metrics.LoadGateConfig(af.thresholds)
+		if err != nil {
+			return err
+		}
+		cfg.Gate.Thresholds = gc.Thresholds
+	case "f":
+		cfg.Output.Formats = splitList(af.formats)
+	case "o":
+		cfg.Output.File = af.output
+	case "db":
+		cfg.Output.Database = af.database
+	}
+	return nil
+}
+
+func (af *analysisFlags) overrideGit(cfg *config.Config, name string) {
+	switch name {
+	case "hotspots":
+		cfg.Hotspots.Enabled = af.hotspots
+	case "since":
+		cfg.Hotspots.Since = af.since
+		cfg.Coupling.Since = af.since
+	case "coupling":
+		cfg.Coupling.Enabled = af.coupling
+	case "ownership":
+		cfg.Ownership.Enabled = af.ownership
+	case "departed":
+		cfg.Ownership.Departed = splitList(af.departed)
+	}
+}
+
+// The combinations of the flags & the configuration that can't work
+func (af *analysisFlags) validate(cfg *config.Config) error {
+	if af.base != "" && cfg.Output.Database != "" {
+		return errors.New("-base can't be combined with output.database, the runs cover the whole tree")
+	}
+	if cfg.Streaming.Enabled && (af.base != "" || af.snapshotFile != "" || af.baselineFile != "" || af.ratchetFile != "" ||
+		cfg.Hotspots.Enabled || cfg.Coupling.Enabled || cfg.Ownership.Enabled || cfg.Output.Database != "") {
+		return errors.New("streaming keeps no per file results, it can't be combined with -base, -snapshot, -baseline, -ratchet, hotspots, coupling, ownership or output.database")
+	}
+	if cfg.Streaming.Enabled && cfg.Streaming.Quantiles == metrics.QUANTILES_EXACT {
+		slog.Warn("exact quantiles keep a value per function & file, the memory of streaming grows with the nr of files")
+	}
+	return nil
+}
+
+// The snapshots to compare the run to, nil if not given
+func (af *analysisFlags) snapshots() (baseline *metrics.Snapshot, ratchet *metrics.Snapshot, err error) {
+	if af.baselineFile != "" {
+		s, err := metrics.LoadSnapshot(af.baselineFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load baseline: %w", err)
+		}
+		baseline = &s
+	}
+	if af.ratchetFile != "" {
+		s, err := metrics.LoadSnapshot(af.ratchetFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load ratchet snapshot: %w", err)
+		}
+		ratchet = &s
+	}
+	return baseline, ratchet, nil
+}
@@ -0,0 +1,87 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// The lines of a file last changed by one author
+type Authorship struct {
+	Name  string
+	Email string // Lower case, identifies the author
+	Lines int
+}
+
+// The author git blames the uncommitted lines on
+const NOT_COMMITTED_EMAIL = "not.committed.yet"
+
+// Blames the working tree version of the file and sums the lines up per author. The
+// uncommitted lines have no author yet, they are left out.
+func Blame(repo string, path string) (map[string]*Authorship, error) {
+	out, err := Run(repo, "blame", "--line-porcelain", "-w", "--", path)
+	if err != nil {
+		return nil, err
+	}
+	return parseBlame(bytes.NewReader(out))
+}
+
+// Sums up the output of git blame --line-porcelain per author
+func parseBlame(r io.Reader) (map[string]*Authorship, error) {
+	var authors = map[string]*Authorship{}
+	var name string
+	var scanner = bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "author "):
+			name = strings.TrimPrefix(line, "author ")
+		case strings.HasPrefix(line, "author-mail "):
+			email := strings.ToLower(strings.Trim(strings.TrimPrefix(line, "author-mail "), "<>"))
+			if email == NOT_COMMITTED_EMAIL {
+				continue
+			}
+			a, ok := authors[email]
+			if !ok {
+				a = &Authorship{Name: name, Email: email}
+				authors[email] = a
+			}
+			// Every line of the file has its own header with --line-porcelain
+			a.Lines++
+		}
+	}
+	return authors, scanner.Err()
+}
+
+// The files of the index, relative to the top level of the repository, slash separated
+func TrackedFiles(repo string) (map[string]bool, error) {
+	out, err := Run(repo, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	var files = map[string]bool{}
+	for _, f := range bytes.Split(out, []byte{0}) {
+		if len(f) > 0 {
+			files[string(f)] = true
+		}
+	}
+	return files, nil
+}
+
+// The untracked files that git doesn't ignore under the paths (relative to the top level of
+// the repository, all of them if none), slash separated
+func UntrackedFiles(repo string, paths ...string) ([]string, error) {
+	out, err := Run(repo, append([]string{"ls-files", "--others", "--exclude-standard", "-z", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range bytes.Split(out, []byte{0}) {
+		if len(f) > 0 {
+			files = append(files, string(f))
+		}
+	}
+	return files, nil
+}
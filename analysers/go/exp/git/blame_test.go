@@ -0,0 +1,38 @@
+package git
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBlame(t *testing.T) {
+	// git blame --line-porcelain of a file with 2 lines of Ann, 1 of Bob & 2 uncommitted ones,
+	// one of them looking like a header
+	f, err := os.Open("testdata/blame.porcelain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	authors, err := parseBlame(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = map[string]*Authorship{
+		"ann@example.com": {Name: "Ann", Email: "ann@example.com", Lines: 2},
+		"bob@example.com": {Name: "Bob B", Email: "bob@example.com", Lines: 1},
+	}
+	if !reflect.DeepEqual(authors, expected) {
+		t.Errorf("got %v, expected %v", authors, expected)
+	}
+
+	// A file with uncommitted lines only
+	authors, err = parseBlame(strings.NewReader("0000000000000000000000000000000000000000 1 1 1\nauthor Not Committed Yet\nauthor-mail <not.committed.yet>\n\tx\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authors == nil || len(authors) != 0 {
+		t.Errorf("got %v, expected no authors", authors)
+	}
+}
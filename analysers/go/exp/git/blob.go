@@ -0,0 +1,73 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Reads the content of files at revisions through a single 'git cat-file --batch' process,
+// instead of running 'git show' for each
+type BlobReader struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func NewBlobReader(repo string) (*BlobReader, error) {
+	var cmd = exec.Command("git", "-C", repo, "cat-file", "--batch")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("git cat-file --batch: %w", err)
+	}
+	return &BlobReader{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// The content of the file at the revision, an error if it's not a file there
+func (br *BlobReader) Read(rev string, path string) ([]byte, error) {
+	var object = rev + ":" + path
+	if strings.ContainsRune(object, '\n') {
+		return nil, fmt.Errorf("%q can't be read in a batch", object)
+	}
+	if _, err := io.WriteString(br.in, object+"\n"); err != nil {
+		return nil, err
+	}
+	// '<hash> <type> <size>', or '<object> missing'
+	header, err := br.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	header = strings.TrimSuffix(header, "\n")
+	fields := strings.Fields(header)
+	if strings.HasSuffix(header, " missing") || strings.HasSuffix(header, " ambiguous") || len(fields) != 3 {
+		return nil, fmt.Errorf("%s: %s", object, header)
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("%s: unexpected header %q", object, header)
+	}
+	// The content is followed by a line break
+	var content = make([]byte, size+1)
+	if _, err := io.ReadFull(br.out, content); err != nil {
+		return nil, err
+	}
+	if fields[1] != "blob" {
+		return nil, fmt.Errorf("%s is a %s", object, fields[1])
+	}
+	return content[:size], nil
+}
+
+func (br *BlobReader) Close() error {
+	br.in.Close()
+	return br.cmd.Wait()
+}
@@ -0,0 +1,244 @@
+// Package git reads the history of a local repository by running the 'git' command line tool.
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A commit of the history
+type Commit struct {
+	Hash    string
+	Date    time.Time // Committer date
+	Subject string
+	Ref     string // The tag the commit was selected by, if any
+}
+
+func (c Commit) ShortHash() string {
+	if len(c.Hash) > 10 {
+		return c.Hash[:10]
+	}
+	return c.Hash
+}
+
+// Runs git in dir and returns its standard output
+func Run(dir string, args ...string) ([]byte, error) {
+	var cmd = exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// The longest line Stream passes on, the rest of a longer line (eg.: minified code in a patch)
+// is dropped
+const MAX_LINE = 64 * 1024
+
+// Runs git in dir and calls fn with every line of its standard output (without the line
+// break) as it's read, so that the output is never kept whole. Stops at the first error of fn.
+func Stream(dir string, fn func(line string) error, args ...string) error {
+	var cmd = exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	var r = bufio.NewReaderSize(stdout, MAX_LINE)
+	for {
+		line, err := readLine(r)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = fn(line)
+		}
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Reads a line without the line break, only the first MAX_LINE bytes of a longer one
+func readLine(r *bufio.Reader) (string, error) {
+	slice, err := r.ReadSlice('\n')
+	var line = string(slice)
+	for err == bufio.ErrBufferFull {
+		_, err = r.ReadSlice('\n')
+	}
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+// The top level directory of the repository dir belongs to
+func TopLevel(dir string) (string, error) {
+	out, err := Run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(strings.TrimSpace(string(out))), nil
+}
+
+// The range of commits to select
+type Selection struct {
+	Rev   string    // The revision to walk back from, HEAD if empty
+	Every int       // Take every Nth commit (the newest is always taken)
+	Tags  bool      // Take the tags reachable from Rev instead of the commits
+	Since time.Time // Ignored if zero
+	Until time.Time // Ignored if zero
+}
+
+const logFormat = "--format=%H%x1f%cI%x1f%s"
+
+// Lists the selected commits, oldest first. Merged branches are not followed, only the
+// first parents.
+func Commits(dir string, sel Selection) ([]Commit, error) {
+	if sel.Rev == "" {
+		sel.Rev = "HEAD"
+	}
+	var commits []Commit
+	var err error
+	if sel.Tags {
+		commits, err = taggedCommits(dir, sel.Rev)
+	} else {
+		commits, err = logCommits(dir, "--first-parent", "--reverse", sel.Rev)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var selected = make([]Commit, 0, len(commits))
+	for _, c := range commits {
+		if (!sel.Since.IsZero() && c.Date.Before(sel.Since)) || (!sel.Until.IsZero() && c.Date.After(sel.Until)) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	if sel.Every <= 1 {
+		return selected, nil
+	}
+	// Count back from the newest, so that it is always part of the selection
+	var sampled = make([]Commit, 0, len(selected)/sel.Every+1)
+	for i := len(selected) - 1; i >= 0; i -= sel.Every {
+		sampled = append(sampled, selected[i])
+	}
+	for i, j := 0, len(sampled)-1; i < j; i, j = i+1, j-1 {
+		sampled[i], sampled[j] = sampled[j], sampled[i]
+	}
+	return sampled, nil
+}
+
+func logCommits(dir string, args ...string) ([]Commit, error) {
+	out, err := Run(dir, append([]string{"log", logFormat}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\x1f", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git log line %q", line)
+		}
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", fields[0], err)
+		}
+		commits = append(commits, Commit{Hash: fields[0], Date: date, Subject: fields[2]})
+	}
+	return commits, nil
+}
+
+func taggedCommits(dir string, rev string) ([]Commit, error) {
+	out, err := Run(dir, "tag", "--merged", rev)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, tag := range strings.Fields(string(out)) {
+		c, err := logCommits(dir, "-1", tag+"^{commit}")
+		if err != nil {
+			return nil, err
+		}
+		if len(c) == 1 {
+			c[0].Ref = tag
+			commits = append(commits, c[0])
+		}
+	}
+	sort.SliceStable(commits, func(i, j int) bool { return commits[i].Date.Before(commits[j].Date) })
+	return commits, nil
+}
+
+// A detached worktree of the repository that can be moved between commits
+type Worktree struct {
+	repo string
+	Dir  string
+}
+
+// Adds a detached worktree of the repository in dir (which must not exist) at the revision
+func AddWorktree(repo string, dir string, rev string) (*Worktree, error) {
+	if _, err := Run(repo, "worktree", "add", "--detach", "--force", dir, rev); err != nil {
+		return nil, err
+	}
+	return &Worktree{repo: repo, Dir: dir}, nil
+}
+
+func (wt *Worktree) Checkout(rev string) error {
+	_, err := Run(wt.Dir, "checkout", "--detach", "--force", "--quiet", rev)
+	return err
+}
+
+func (wt *Worktree) Remove() error {
+	_, err := Run(wt.repo, "worktree", "remove", "--force", wt.Dir)
+	return err
+}
+
+// The checked out state of a repository
+type Head struct {
+	Commit string // Empty before the first commit
+	Branch string // Empty for a detached head
+	Dirty  bool   // There are uncommitted changes
+	Remote string // The URL of 'origin', if any
+}
+
+func HeadOf(dir string) (Head, error) {
+	var head Head
+	if out, err := Run(dir, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		head.Commit = strings.TrimSpace(string(out))
+	}
+	if out, err := Run(dir, "symbolic-ref", "--quiet", "--short", "HEAD"); err == nil {
+		head.Branch = strings.TrimSpace(string(out))
+	}
+	out, err := Run(dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return head, err
+	}
+	head.Dirty = len(bytes.TrimSpace(out)) > 0
+	if out, err := Run(dir, "remote", "get-url", "origin"); err == nil {
+		head.Remote = strings.TrimSpace(string(out))
+	}
+	return head, nil
+}
@@ -0,0 +1,263 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A changed region in the new version of a file
+type Hunk struct {
+	Start    int // First line, for a pure deletion the line after which lines were removed
+	Lines    int // Nr of lines in the new version, 0 for a pure deletion
+	OldLines int // Nr of lines replaced in the old version, 0 for a pure addition
+}
+
+// Whether the hunk touches the lines from start to end (inclusive) of the new version
+func (h Hunk) Overlaps(start int, end int) bool {
+	// A pure deletion has no lines in the new version, it happened right after Start
+	last := h.Start + max(h.Lines, 1) - 1
+	return h.Start <= end && last >= start
+}
+
+// The changes of one file in one commit
+type FileChange struct {
+	Path    string // Relative to the top level of the repository, slash separated
+	Created bool   // The file was added by the commit
+	Deleted bool   // The file was removed by the commit
+	Added   int    // Nr of added lines
+	Removed int    // Nr of removed lines
+	Hunks   []Hunk
+}
+
+// A commit with the files it changed
+type Change struct {
+	Commit
+	Author string
+	Email  string
+	Files  []FileChange
+}
+
+// What to read from the log
+type LogOptions struct {
+	Rev        string   // The revision to walk back from, HEAD if empty
+	Since      string   // Anything 'git log --since' accepts (eg.: '6 months ago'), ignored if empty
+	MaxCommits int      // Ignored if 0
+	Paths      []string // Limit the log to these paths (relative to the repository)
+	Hunks      bool     // Also read the changed line ranges of the files, from a '-U0' patch
+}
+
+// Reads the non-merge commits with the files they changed, newest first. The added & removed
+// lines come from '--numstat', the output is parsed as it's streamed.
+func Log(repo string, opts LogOptions) ([]Change, error) {
+	if opts.Rev == "" {
+		opts.Rev = "HEAD"
+	}
+	var args = []string{"-c", "core.quotePath=false", "log", "--no-merges", "--no-renames", "--no-color",
+		"--numstat", "--summary", "--format=%x1e%H%x1f%cI%x1f%an%x1f%ae%x1f%s"}
+	if opts.Hunks {
+		args = append(args, "-p", "-U0", "--no-ext-diff")
+	}
+	if opts.Since != "" {
+		args = append(args, "--since="+opts.Since)
+	}
+	if opts.MaxCommits > 0 {
+		args = append(args, "-n", strconv.Itoa(opts.MaxCommits))
+	}
+	args = append(args, opts.Rev, "--")
+	args = append(args, opts.Paths...)
+	var lp logParser
+	if err := Stream(repo, lp.parse, args...); err != nil {
+		return nil, err
+	}
+	lp.flush()
+	return lp.changes, nil
+}
+
+// Collects the commits of a '--numstat --summary' log line by line, with the hunks of the
+// patch if there is one
+type logParser struct {
+	changes []Change
+	files   map[string]int // The files of the last commit by path, to index its Files
+	diff    *diffParser    // The patch of the last commit
+}
+
+func (lp *logParser) parse(line string) error {
+	switch {
+	case strings.HasPrefix(line, "\x1e"):
+		lp.flush()
+		c, err := parseCommitHeader(line[1:])
+		if err != nil {
+			return err
+		}
+		lp.changes = append(lp.changes, c)
+		lp.files = map[string]int{}
+		lp.diff = nil
+	case len(lp.changes) == 0 || line == "":
+		return nil
+	case lp.diff != nil || strings.HasPrefix(line, "diff --git "):
+		// The patch follows the stats, its lines can look like them
+		if lp.diff == nil {
+			lp.diff = &diffParser{}
+		}
+		return lp.diff.parse(line)
+	default:
+		lp.parseStat(line)
+	}
+	return nil
+}
+
+// '<commit>\x1f<date>\x1f<author>\x1f<email>\x1f<subject>'
+func parseCommitHeader(header string) (Change, error) {
+	fields := strings.SplitN(header, "\x1f", 5)
+	if len(fields) != 5 {
+		return Change{}, fmt.Errorf("unexpected git log header %q", header)
+	}
+	date, err := time.Parse(time.RFC3339, fields[1])
+	if err != nil {
+		return Change{}, fmt.Errorf("commit %s: %w", fields[0], err)
+	}
+	return Change{
+		Commit: Commit{Hash: fields[0], Date: date, Subject: fields[4]},
+		Author: fields[2],
+		Email:  fields[3],
+	}, nil
+}
+
+// '<added>\t<removed>\t<path>' ('-' for binary files) & ' create mode <mode> <path>' or
+// ' delete mode <mode> <path>', the rest of the summary is ignored
+func (lp *logParser) parseStat(line string) {
+	var c = &lp.changes[len(lp.changes)-1]
+	if rest, ok := strings.CutPrefix(line, " create mode "); ok {
+		if i, ok := lp.files[afterMode(rest)]; ok {
+			c.Files[i].Created = true
+		}
+		return
+	}
+	if rest, ok := strings.CutPrefix(line, " delete mode "); ok {
+		if i, ok := lp.files[afterMode(rest)]; ok {
+			c.Files[i].Deleted = true
+		}
+		return
+	}
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) != 3 {
+		return
+	}
+	added, _ := strconv.Atoi(fields[0])
+	removed, _ := strconv.Atoi(fields[1])
+	lp.files[fields[2]] = len(c.Files)
+	c.Files = append(c.Files, FileChange{Path: fields[2], Added: added, Removed: removed})
+}
+
+func afterMode(s string) string {
+	_, path, _ := strings.Cut(s, " ")
+	return path
+}
+
+// Moves the hunks of the patch of the last commit to its files
+func (lp *logParser) flush() {
+	if lp.diff == nil {
+		return
+	}
+	var c = &lp.changes[len(lp.changes)-1]
+	for _, fc := range lp.diff.files {
+		if i, ok := lp.files[fc.Path]; ok {
+			c.Files[i].Hunks = fc.Hunks
+		}
+	}
+	lp.diff = nil
+}
+
+// Collects the file changes of a '-U0' patch line by line
+type diffParser struct {
+	files []FileChange
+	file  *FileChange
+}
+
+func (dp *diffParser) parse(line string) error {
+	switch {
+	case strings.HasPrefix(line, "diff --git "):
+		dp.files = append(dp.files, FileChange{})
+		dp.file = &dp.files[len(dp.files)-1]
+	case dp.file == nil:
+		return nil
+	case len(dp.file.Hunks) == 0 && strings.HasPrefix(line, "--- "):
+		if path := strings.TrimPrefix(line, "--- "); path != "/dev/null" {
+			dp.file.Path = strings.TrimPrefix(path, "a/")
+		} else {
+			dp.file.Created = true
+		}
+	case len(dp.file.Hunks) == 0 && strings.HasPrefix(line, "+++ "):
+		if path := strings.TrimPrefix(line, "+++ "); path != "/dev/null" {
+			dp.file.Path = strings.TrimPrefix(path, "b/")
+		} else {
+			dp.file.Deleted = true
+		}
+	case strings.HasPrefix(line, "@@ "):
+		hunk, err := parseHunkHeader(line)
+		if err != nil {
+			return err
+		}
+		dp.file.Hunks = append(dp.file.Hunks, hunk)
+	case strings.HasPrefix(line, "+"):
+		dp.file.Added++
+	case strings.HasPrefix(line, "-"):
+		dp.file.Removed++
+	}
+	return nil
+}
+
+// Parses the ranges of '@@ -a,b +c,d @@ ...'
+func parseHunkHeader(line string) (hunk Hunk, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return hunk, fmt.Errorf("unexpected hunk header %q", line)
+	}
+	if _, hunk.OldLines, err = parseRange(fields[1][1:]); err != nil {
+		return hunk, fmt.Errorf("hunk header %q: %w", line, err)
+	}
+	if hunk.Start, hunk.Lines, err = parseRange(fields[2][1:]); err != nil {
+		return hunk, fmt.Errorf("hunk header %q: %w", line, err)
+	}
+	return hunk, nil
+}
+
+// Parses 'start,count', where the count defaults to 1
+func parseRange(r string) (start int, count int, err error) {
+	s, c, found := strings.Cut(r, ",")
+	if start, err = strconv.Atoi(s); err != nil {
+		return
+	}
+	if !found {
+		return start, 1, nil
+	}
+	count, err = strconv.Atoi(c)
+	return
+}
+
+// Reads the content of a file at a revision
+func Show(repo string, rev string, path string) ([]byte, error) {
+	return Run(repo, "show", rev+":"+path)
+}
+
+// The common ancestor of two revisions
+func MergeBase(repo string, a string, b string) (string, error) {
+	out, err := Run(repo, "merge-base", a, b)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// The changes of the working tree (including the uncommitted ones, but not the untracked
+// files) since the revision
+func Diff(repo string, rev string, paths ...string) ([]FileChange, error) {
+	var args = append([]string{"-c", "core.quotePath=false", "diff", "--no-renames", "--no-color", "--no-ext-diff", "-U0", rev, "--"}, paths...)
+	var diff diffParser
+	if err := Stream(repo, diff.parse, args...); err != nil {
+		return nil, err
+	}
+	return diff.files, nil
+}
@@ -0,0 +1,153 @@
+package git
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseHunkHeader(t *testing.T) {
+	var tests = []struct {
+		line    string
+		want    Hunk
+		wantErr bool
+	}{
+		{"@@ -1,2 +1,3 @@", Hunk{Start: 1, Lines: 3, OldLines: 2}, false},
+		{"@@ -10 +12 @@ func main() {", Hunk{Start: 12, Lines: 1, OldLines: 1}, false},
+		{"@@ -5,0 +6,4 @@", Hunk{Start: 6, Lines: 4, OldLines: 0}, false},
+		{"@@ -7,3 +6,0 @@", Hunk{Start: 6, Lines: 0, OldLines: 3}, false},
+		{"@@ -0,0 +1,20 @@", Hunk{Start: 1, Lines: 20, OldLines: 0}, false},
+		{"@@ -1,2 @@", Hunk{}, true},
+		{"@@ +1,2 -1,2 @@", Hunk{}, true},
+		{"@@ -a,2 +1,2 @@", Hunk{}, true},
+		{"@@ -1,2 +1,b @@", Hunk{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseHunkHeader(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHunkOverlaps(t *testing.T) {
+	var tests = []struct {
+		name       string
+		hunk       Hunk
+		start, end int
+		want       bool
+	}{
+		{"inside", Hunk{Start: 5, Lines: 2}, 1, 10, true},
+		{"before", Hunk{Start: 1, Lines: 2}, 3, 10, false},
+		{"after", Hunk{Start: 11, Lines: 2}, 3, 10, false},
+		{"first line", Hunk{Start: 10, Lines: 5}, 3, 10, true},
+		{"pure deletion inside", Hunk{Start: 4, Lines: 0, OldLines: 3}, 3, 10, true},
+		{"pure deletion before", Hunk{Start: 2, Lines: 0, OldLines: 3}, 3, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hunk.Overlaps(tt.start, tt.end); got != tt.want {
+				t.Errorf("Overlaps(%d, %d) = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogParser(t *testing.T) {
+	var lines = []string{
+		"\x1eabc\x1f2024-01-02T03:04:05Z\x1fAnn\x1fann@example.com\x1fSubject",
+		"",
+		"3\t1\ta.go",
+		"-\t-\tlogo.png",
+		"5\t0\tnew.go",
+		"0\t2\told.go",
+		" create mode 100644 new.go",
+		" delete mode 100644 old.go",
+		"diff --git a/a.go b/a.go",
+		"--- a/a.go",
+		"+++ b/a.go",
+		"@@ -10 +10,3 @@ func A() {",
+		"-1\t1\tnot.go",
+		"+x",
+		"diff --git a/new.go b/new.go",
+		"--- /dev/null",
+		"+++ b/new.go",
+		"@@ -0,0 +1,5 @@",
+		"\x1edef\x1f2024-01-01T00:00:00Z\x1fBob\x1fbob@example.com\x1fFirst",
+		"",
+		"1\t0\tb.go",
+	}
+	var lp logParser
+	for _, line := range lines {
+		if err := lp.parse(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lp.flush()
+	if len(lp.changes) != 2 {
+		t.Fatalf("%d commits, want 2", len(lp.changes))
+	}
+	var c = lp.changes[0]
+	if c.Hash != "abc" || c.Author != "Ann" || c.Email != "ann@example.com" || c.Subject != "Subject" {
+		t.Errorf("unexpected commit %+v", c.Commit)
+	}
+	var want = []FileChange{
+		{Path: "a.go", Added: 3, Removed: 1, Hunks: []Hunk{{Start: 10, Lines: 3, OldLines: 1}}},
+		{Path: "logo.png"},
+		{Path: "new.go", Created: true, Added: 5, Hunks: []Hunk{{Start: 1, Lines: 5}}},
+		{Path: "old.go", Deleted: true, Removed: 2},
+	}
+	if len(c.Files) != len(want) {
+		t.Fatalf("%d files, want %d: %+v", len(c.Files), len(want), c.Files)
+	}
+	for i, fc := range c.Files {
+		w := want[i]
+		if fc.Path != w.Path || fc.Created != w.Created || fc.Deleted != w.Deleted || fc.Added != w.Added ||
+			fc.Removed != w.Removed || len(fc.Hunks) != len(w.Hunks) || (len(w.Hunks) > 0 && fc.Hunks[0] != w.Hunks[0]) {
+			t.Errorf("file %d = %+v, want %+v", i, fc, w)
+		}
+	}
+	if files := lp.changes[1].Files; len(files) != 1 || files[0].Path != "b.go" || files[0].Hunks != nil {
+		t.Errorf("unexpected files of the second commit %+v", files)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	var long = strings.Repeat("x", MAX_LINE+10)
+	var tests = []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"lines", "a\nb\n", []string{"a", "b"}},
+		{"no final line break", "a\nb", []string{"a", "b"}},
+		{"empty lines", "\n\na\n", []string{"", "", "a"}},
+		{"truncated", long + "\nb\n", []string{long[:MAX_LINE], "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = bufio.NewReaderSize(strings.NewReader(tt.input), MAX_LINE)
+			var got []string
+			for {
+				line, err := readLine(r)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, line)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %d lines %.20q, want %d lines %.20q", len(got), got, len(tt.want), tt.want)
+			}
+		})
+	}
+}
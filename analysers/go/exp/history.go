@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/git"
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// History output formats
+const (
+	HISTORY_JSON     string = "json"
+	HISTORY_CSV      string = "csv"
+	HISTORY_MARKDOWN string = "markdown" // Table & Mermaid charts
+)
+
+var historyFormats = []string{HISTORY_JSON, HISTORY_CSV, HISTORY_MARKDOWN}
+
+// The metrics of the tree at one commit
+type historyPoint struct {
+	Commit           string    `json:"commit"`
+	Ref              string    `json:"ref,omitempty"`
+	Date             time.Time `json:"date"`
+	Subject          string    `json:"subject"`
+	TotalNrOfFiles   int       `json:"files"`
+	TotalCodeLOC     int       `json:"code_loc"`
+	CyclCMedian      float64   `json:"cc_median"`
+	CyclCP95         float64   `json:"cc_p95"`
+	HalEffortPerkLOC float64   `json:"halstead_effort_per_kloc"`
+	CompositeScore   float64   `json:"composite_score"`
+}
+
+// Handles the 'history' subcommand: analyses the tree at a series of commits
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	dirname := fs.String("d", "", "Directory (in a git repository) containing Go files to parse")
+	configFile := fs.String("config", "", "Configuration file (default: discovered upward from the directory)")
+	nrOfWorkers := fs.Int("w", 0, "Nr of workers (default: from the configuration)")
+	rev := fs.String("rev", "HEAD", "Revision to walk the history back from")
+	every := fs.Int("every", 1, "Analyse every Nth commit only")
+	tags := fs.Bool("tags", false, "Analyse the tags reachable from -rev instead of the commits")
+	since := fs.String("since", "", "Skip the commits before this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "Skip the commits after this date (YYYY-MM-DD)")
+	format := fs.String("f", HISTORY_MARKDOWN, "Output format: "+strings.Join(historyFormats, ", "))
+	output := fs.String("o", "", "File to write the time series into (default: stdout)")
+	fs.Parse(args)
+
+	if *dirname == "" || !slices.Contains(historyFormats, *format) {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+	sel, err := historySelection(*rev, *every, *tags, *since, *until)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+	cfg, err := loadConfig(*configFile, *dirname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load configuration: %v\n", err)
+		return EXIT_ERROR
+	}
+	if *nrOfWorkers > 0 {
+		cfg.Workers = *nrOfWorkers
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = engine.DefaultWorkers()
+	}
+
+	absDir, err := filepath.Abs(*dirname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+	// An interrupt stops the analysis, the worktree is still removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	points, err := analyseHistory(ctx, absDir, sel, &cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return EXIT_ERROR
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeHistory(w, *format, filepath.Base(absDir), points); err != nil {
+		fmt.Fprintf(os.Stderr, "write history: %v\n", err)
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+// The commits to analyse, the dates are YYYY-MM-DD (or empty), both inclusive
+func historySelection(rev string, every int, tags bool, since string, until string) (git.Selection, error) {
+	var sel = git.Selection{Rev: rev, Every: every, Tags: tags}
+	for _, d := range []struct {
+		value string
+		t     *time.Time
+	}{{since, &sel.Since}, {until, &sel.Until}} {
+		if d.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, d.value)
+		if err != nil {
+			return sel, fmt.Errorf("invalid date %q: %w", d.value, err)
+		}
+		*d.t = t
+	}
+	if !sel.Until.IsZero() {
+		// Until is inclusive
+		sel.Until = sel.Until.Add(24*time.Hour - time.Nanosecond)
+	}
+	return sel, nil
+}
+
+// Analyses dir at the selected commits of its repository, in a temporary worktree. The commits
+// the directory didn't exist at are skipped.
+func analyseHistory(ctx context.Context, dir string, sel git.Selection, cfg *config.Config) ([]historyPoint, error) {
+	repo, err := git.TopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	subDir, err := filepath.Rel(repo, dir)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := git.Commits(repo, sel)
+	if err != nil {
+		return nil, fmt.Errorf("list commits: %w", err)
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commits selected")
+	}
+
+	tmp, err := os.MkdirTemp("", "code-stats-history-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	wt, err := git.AddWorktree(repo, filepath.Join(tmp, "worktree"), commits[0].Hash)
+	if err != nil {
+		return nil, err
+	}
+	defer wt.Remove()
+
+	var points = make([]historyPoint, 0, len(commits))
+	for _, c := range commits {
+		if err := wt.Checkout(c.Hash); err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(wt.Dir, subDir)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			// The directory didn't exist (yet) at this commit
+			continue
+		}
+		report, err := analyse(ctx, dir, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", c.ShortHash(), err)
+		}
+		points = append(points, newHistoryPoint(c, report.SummaryMetrics()))
+	}
+	return points, nil
+}
+
+func newHistoryPoint(c git.Commit, sm *metrics.SummaryMetrics) historyPoint {
+	return historyPoint{
+		Commit:           c.Hash,
+		Ref:              c.Ref,
+		Date:             c.Date,
+		Subject:          c.Subject,
+		TotalNrOfFiles:   sm.TotalNrOfFiles(),
+		TotalCodeLOC:     sm.TotalCodeLOC(),
+		CyclCMedian:      sm.CyclCMedian(),
+		CyclCP95:         sm.CyclCP95(),
+		HalEffortPerkLOC: sm.HalEffortPerkLOC(),
+		CompositeScore:   sm.CompositeScore(),
+	}
+}
+
+func writeHistory(w io.Writer, format string, project string, points []historyPoint) error {
+	switch format {
+	case HISTORY_JSON:
+		for i := range points {
+			points[i].HalEffortPerkLOC = finiteOrZero(points[i].HalEffortPerkLOC)
+			points[i].CompositeScore = finiteOrZero(points[i].CompositeScore)
+		}
+		var encoder = json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(points)
+	case HISTORY_CSV:
+		var cw = csv.NewWriter(w)
+		cw.Write([]string{"commit", "ref", "date", "files", "code_loc", "cc_median", "cc_p95", "halstead_effort_per_kloc", "composite_score"})
+		for _, p := range points {
+			cw.Write([]string{
+				p.Commit, p.Ref, p.Date.Format(time.RFC3339),
+				strconv.Itoa(p.TotalNrOfFiles), strconv.Itoa(p.TotalCodeLOC),
+				formatFloat(p.CyclCMedian), formatFloat(p.CyclCP95),
+				formatFloat(p.HalEffortPerkLOC), formatFloat(p.CompositeScore),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tmpl, err := template.New("history.md.tmpl").Funcs(template.FuncMap{
+			"series": historySeries,
+			"label":  historyLabel,
+		}).ParseFiles("exp/templates/history.md.tmpl")
+		if err != nil {
+			return err
+		}
+		return tmpl.Execute(w, struct {
+			Project string
+			Points  []historyPoint
+		}{project, points})
+	}
+}
+
+// Formats one metric of every point as a Mermaid data series
+func historySeries(points []historyPoint, metric string) string {
+	var values = make([]string, 0, len(points))
+	for _, p := range points {
+		var v float64
+		switch metric {
+		case "code_loc":
+			v = float64(p.TotalCodeLOC)
+		case "cc_median":
+			v = p.CyclCMedian
+		case "cc_p95":
+			v = p.CyclCP95
+		case "halstead_effort_per_kloc":
+			v = p.HalEffortPerkLOC
+		case "composite_score":
+			v = p.CompositeScore
+		}
+		values = append(values, formatFloat(finiteOrZero(v)))
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+// The x axis labels: the tag or the short hash
+func historyLabel(points []historyPoint) string {
+	var labels = make([]string, 0, len(points))
+	for _, p := range points {
+		label := p.Ref
+		if label == "" {
+			label = p.Commit[:min(7, len(p.Commit))]
+		}
+		labels = append(labels, strconv.Quote(label))
+	}
+	return "[" + strings.Join(labels, ", ") + "]"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+func finiteOrZero(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
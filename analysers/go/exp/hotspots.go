@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/git"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// A file or function ranked by change frequency x complexity
+type hotspot struct {
+	Path     string // Relative to the analysed directory
+	Function string // Empty for files
+	Commits  int    // Nr of commits that changed it in the window
+	Churn    int    // Nr of lines added & removed in the window
+	CC       int    // Cyclomatic Complexity, summed up over the functions for a file
+	ABC      int    // ABC code size
+	Score    float64
+	// The position on the churn vs complexity chart, normalised to [0, 1]
+	X float64
+	Y float64
+}
+
+type hotspotReport struct {
+	Since     string
+	Commits   int
+	Files     []hotspot
+	Functions []hotspot
+}
+
+// The git log of the analysed directory, relative to the repository
+type repoLog struct {
+	repo    string
+	subDir  string // The analysed directory relative to the repository, slash separated
+	changes []git.Change
+}
+
+func readRepoLog(dir string, opts git.LogOptions) (*repoLog, error) {
+	rl, err := openRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	opts.Paths = []string{rl.subDir}
+	if rl.changes, err = git.Log(rl.repo, opts); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// The repository of the analysed directory, without its log
+func openRepo(dir string) (*repoLog, error) {
+	repo, err := git.TopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	subDir, err := filepath.Rel(repo, absDir)
+	if err != nil {
+		return nil, err
+	}
+	return &repoLog{repo: repo, subDir: filepath.ToSlash(subDir)}, nil
+}
+
+// The path of a changed file relative to the analysed directory, false for files outside of
+// it or that are not measured
+func (rl *repoLog) relPath(repoPath string) (string, bool) {
+	if !strings.HasSuffix(repoPath, ".go") || strings.HasSuffix(repoPath, "_test.go") {
+		return "", false
+	}
+	if rl.subDir == "." {
+		return repoPath, true
+	}
+	if !strings.HasPrefix(repoPath, rl.subDir+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(repoPath, rl.subDir+"/"), true
+}
+
+// Ranks the files & functions of the snapshot by the number of commits changing them times
+// their complexity. The changed functions are found by parsing the files at their commits.
+func computeHotspots(rl *repoLog, snapshot *metrics.Snapshot, cfg config.Hotspots) (hotspotReport, error) {
+	var files = map[string]*hotspot{}
+	var functions = map[string]*hotspot{}
+	for _, fs := range snapshot.Files {
+		var cc, abc int
+		for _, f := range fs.Functions {
+			functions[fs.Path+":"+f.ID] = &hotspot{Path: fs.Path, Function: f.ID, CC: f.CC, ABC: f.ABC}
+			cc += f.CC
+			abc += f.ABC
+		}
+		files[fs.Path] = &hotspot{Path: fs.Path, CC: cc, ABC: abc}
+	}
+
+	blobs, err := git.NewBlobReader(rl.repo)
+	if err != nil {
+		return hotspotReport{}, err
+	}
+	defer blobs.Close()
+	for _, change := range rl.changes {
+		for _, fc := range change.Files {
+			rel, ok := rl.relPath(fc.Path)
+			if !ok || fc.Deleted {
+				continue
+			}
+			file, ok := files[rel]
+			if !ok {
+				// Not part of the current tree (anymore)
+				continue
+			}
+			file.Commits++
+			file.Churn += fc.Added + fc.Removed
+			for id, churn := range changedFunctions(blobs, change.Hash, fc) {
+				if f, ok := functions[rel+":"+id]; ok {
+					f.Commits++
+					f.Churn += churn
+				}
+			}
+		}
+	}
+
+	var report = hotspotReport{Since: cfg.Since, Commits: len(rl.changes)}
+	report.Files = rankHotspots(files, cfg.Top)
+	report.Functions = rankHotspots(functions, cfg.Top)
+	return report, nil
+}
+
+// The functions of the file at the commit that overlap a hunk, with the nr of lines churned
+func changedFunctions(blobs *git.BlobReader, rev string, fc git.FileChange) map[string]int {
+	src, err := blobs.Read(rev, fc.Path)
+	if err != nil {
+		return nil
+	}
+	var fset = token.NewFileSet()
+	tree, err := parser.ParseFile(fset, fc.Path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	var changed = map[string]int{}
+	for _, fr := range metrics.FunctionRanges(fset, tree) {
+		for _, h := range fc.Hunks {
+			if h.Overlaps(fr.StartLine, fr.EndLine) {
+				changed[fr.ID] += h.Lines + h.OldLines
+			}
+		}
+	}
+	return changed
+}
+
+func rankHotspots(candidates map[string]*hotspot, top int) []hotspot {
+	var ranked = make([]hotspot, 0, len(candidates))
+	var maxCommits, maxCC int
+	for _, h := range candidates {
+		if h.Commits == 0 {
+			continue
+		}
+		h.Score = float64(h.Commits * h.CC)
+		maxCommits = max(maxCommits, h.Commits)
+		maxCC = max(maxCC, h.CC)
+		ranked = append(ranked, *h)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		if ranked[i].Churn != ranked[j].Churn {
+			return ranked[i].Churn > ranked[j].Churn
+		}
+		return ranked[i].Path+ranked[i].Function < ranked[j].Path+ranked[j].Function
+	})
+	if len(ranked) > top {
+		ranked = ranked[:top]
+	}
+	for i := range ranked {
+		ranked[i].X = float64(ranked[i].Commits) / float64(maxCommits)
+		if maxCC > 0 {
+			ranked[i].Y = float64(ranked[i].CC) / float64(maxCC)
+		}
+	}
+	return ranked
+}
+
+// Mermaid point labels can't contain colons & brackets
+func chartLabel(h hotspot) string {
+	var label = path.Base(h.Path)
+	if h.Function != "" {
+		label = h.Function
+	}
+	return strings.NewReplacer(":", " ", "[", "(", "]", ")").Replace(label)
+}
+
+func runHotspots(dir string, snapshot *metrics.Snapshot, cfg config.Hotspots) (hotspotReport, error) {
+	rl, err := readRepoLog(dir, git.LogOptions{Since: cfg.Since, MaxCommits: cfg.MaxCommits, Hunks: true})
+	if err != nil {
+		return hotspotReport{}, fmt.Errorf("read git log: %w", err)
+	}
+	report, err := computeHotspots(rl, snapshot, cfg)
+	if err != nil {
+		return report, fmt.Errorf("read changed files: %w", err)
+	}
+	return report, nil
+}
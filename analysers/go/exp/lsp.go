@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// JSON-RPC error codes
+const (
+	LSP_METHOD_NOT_FOUND       int = -32601
+	LSP_INVALID_PARAMS         int = -32602
+	LSP_INTERNAL_ERROR         int = -32603
+	LSP_SERVER_NOT_INITIALIZED int = -32002
+)
+
+const (
+	LSP_SYNC_FULL        int    = 1 // The client sends the whole buffer on every change
+	LSP_SEVERITY_WARNING int    = 2
+	LSP_SOURCE           string = "code-stats"
+)
+
+// A request, a notification or the response to a request of the server
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"` // Missing for notifications
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type lspResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"` // Has to be present (null) on success
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int    `json:"id,omitempty"` // Zero for notifications
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Zero based, the character is counted in UTF-16 code units
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspCodeLens struct {
+	Range   lspRange   `json:"range"`
+	Command lspCommand `json:"command"`
+}
+
+// Lenses without a command are only displayed
+type lspCommand struct {
+	Title   string `json:"title"`
+	Command string `json:"command"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextDocument struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+// An open document, with the results of the last buffer that could be parsed
+type lspDocument struct {
+	lenses      []lspCodeLens
+	diagnostics []lspDiagnostic
+}
+
+// A language server over stdio, measuring the open buffers. The messages are handled one
+// by one, measuring a single file is fast enough to keep up with the typing.
+type lspServer struct {
+	in          *bufio.Reader
+	out         io.Writer
+	configFile  string
+	initialized bool
+	shutdown    bool
+	root        string
+	cfg         config.Config
+	gate        metrics.GateConfig
+	disabled    []string
+	documents   map[string]*lspDocument
+	// The client asks for the lenses again when told to
+	refreshLenses bool
+	nextID        int
+}
+
+// Handles the 'lsp' subcommand: serves code lenses & diagnostics to an editor over stdio
+func runLSP(args []string) int {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	configFile := fs.String("config", "", "Configuration file (default: discovered upward from the workspace)")
+	fs.Parse(args)
+
+	var s = lspServer{
+		in:         bufio.NewReader(os.Stdin),
+		out:        os.Stdout,
+		configFile: *configFile,
+		documents:  map[string]*lspDocument{},
+	}
+	return s.run()
+}
+
+func (s *lspServer) run() int {
+	for {
+		msg, err := s.read()
+		if err != nil {
+			if errors.Is(err, io.EOF) && s.shutdown {
+				return EXIT_OK
+			}
+			fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+			return EXIT_ERROR
+		}
+		if msg.Method == "" {
+			// The response to a refresh request
+			continue
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return EXIT_OK
+			}
+			return EXIT_ERROR
+		}
+		result, lerr := s.handle(msg)
+		if msg.ID == nil {
+			if lerr != nil {
+				fmt.Fprintf(os.Stderr, "lsp: %s: %s\n", msg.Method, lerr.Message)
+			}
+			continue
+		}
+		var resp = lspResponse{JSONRPC: "2.0", ID: msg.ID, Error: lerr}
+		if lerr == nil {
+			if resp.Result, err = json.Marshal(result); err != nil {
+				resp.Result, resp.Error = nil, &lspError{LSP_INTERNAL_ERROR, err.Error()}
+			}
+		}
+		if err := s.write(resp); err != nil {
+			fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+			return EXIT_ERROR
+		}
+	}
+}
+
+// Reads a message framed by the Content-Length header
+func (s *lspServer) read() (msg lspMessage, err error) {
+	var length = -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return msg, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return msg, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return msg, fmt.Errorf("missing Content-Length header")
+	}
+	var body = make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return msg, err
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return msg, fmt.Errorf("invalid message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(data)
+	return err
+}
+
+// Sends a notification, or a request when expectsResponse is set (its response is ignored)
+func (s *lspServer) send(method string, params any, expectsResponse bool) {
+	var req = lspRequest{JSONRPC: "2.0", Method: method, Params: params}
+	if expectsResponse {
+		s.nextID++
+		req.ID = s.nextID
+	}
+	if err := s.write(req); err != nil {
+		fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+	}
+}
+
+func (s *lspServer) handle(msg lspMessage) (any, *lspError) {
+	if !s.initialized && msg.Method != "initialize" {
+		return nil, &lspError{LSP_SERVER_NOT_INITIALIZED, "the server is not initialized"}
+	}
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			RootURI      string `json:"rootUri"`
+			Capabilities struct {
+				Workspace struct {
+					CodeLens struct {
+						RefreshSupport bool `json:"refreshSupport"`
+					} `json:"codeLens"`
+				} `json:"workspace"`
+			} `json:"capabilities"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{LSP_INVALID_PARAMS, err.Error()}
+		}
+		return s.initialize(uriPath(params.RootURI), params.Capabilities.Workspace.CodeLens.RefreshSupport)
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{LSP_INVALID_PARAMS, err.Error()}
+		}
+		s.update(params.TextDocument)
+		return nil, nil
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   lspTextDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{LSP_INVALID_PARAMS, err.Error()}
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// Full sync: the last change is the whole buffer
+		params.TextDocument.Text = params.ContentChanges[len(params.ContentChanges)-1].Text
+		s.update(params.TextDocument)
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{LSP_INVALID_PARAMS, err.Error()}
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.publishDiagnostics(params.TextDocument.URI, nil)
+		return nil, nil
+	case "textDocument/codeLens":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{LSP_INVALID_PARAMS, err.Error()}
+		}
+		var lenses = []lspCodeLens{}
+		if doc, ok := s.documents[params.TextDocument.URI]; ok && doc.lenses != nil {
+			lenses = doc.lenses
+		}
+		return lenses, nil
+	}
+	if msg.ID == nil {
+		// Unknown notifications (eg.: '$/cancelRequest') can be ignored
+		return nil, nil
+	}
+	return nil, &lspError{LSP_METHOD_NOT_FOUND, "unsupported method " + msg.Method}
+}
+
+// Loads the configuration of the workspace and announces the capabilities
+func (s *lspServer) initialize(root string, refreshLenses bool) (any, *lspError) {
+	if root == "" {
+		root = "."
+	}
+	cfg, err := loadConfig(s.configFile, root)
+	if err != nil {
+		return nil, &lspError{LSP_INTERNAL_ERROR, fmt.Sprintf("load configuration: %v", err)}
+	}
+	s.root, s.cfg, s.gate = root, cfg, cfg.GateConfig()
+	// The buffers are not on disk, 'cloc' can't count them
+	s.disabled = append(cfg.DisabledMetrics(), metrics.METRIC_LOC)
+	s.refreshLenses = refreshLenses
+	s.initialized = true
+	return map[string]any{
+		"capabilities": map[string]any{
+			"textDocumentSync": LSP_SYNC_FULL,
+			"codeLensProvider": map[string]any{"resolveProvider": false},
+		},
+		"serverInfo": map[string]string{"name": LSP_SOURCE},
+	}, nil
+}
+
+// Measures the buffer and publishes the results. A buffer that doesn't parse is most likely
+// in the middle of an edit, the results of the last one that did are kept.
+func (s *lspServer) update(td lspTextDocument) {
+	var path = uriPath(td.URI)
+	if path == "" || !engine.Measured(s.root, path, &s.cfg) {
+		return
+	}
+	var src = []byte(td.Text)
+	fset := token.NewFileSet()
+	tree, err := parser.ParseFile(fset, path, src, parser.AllErrors)
+	if err != nil {
+		return
+	}
+	fm := metrics.NewFileMetric(path)
+	fm.Disable(s.disabled...)
+	fm.GenerateMetrics(fset, tree)
+
+	// The functions are measured in the order of the declarations
+	var names []lspRange
+	for _, decl := range tree.Decls {
+		if f, ok := decl.(*ast.FuncDecl); ok {
+			names = append(names, lspRange{
+				Start: lspPositionOf(src, fset.Position(f.Name.Pos())),
+				End:   lspPositionOf(src, fset.Position(f.Name.End())),
+			})
+		}
+	}
+	var doc = lspDocument{lenses: []lspCodeLens{}, diagnostics: []lspDiagnostic{}}
+	for i, f := range fm.Snapshot(s.root).Functions {
+		if i >= len(names) {
+			break
+		}
+		doc.lenses = append(doc.lenses, lspCodeLens{Range: names[i], Command: lspCommand{Title: s.lensTitle(f)}})
+	}
+	for _, v := range s.gate.EvaluateFunctions(&fm) {
+		if v.Function >= len(names) {
+			continue
+		}
+		doc.diagnostics = append(doc.diagnostics, lspDiagnostic{
+			Range:    names[v.Function],
+			Severity: LSP_SEVERITY_WARNING,
+			Code:     v.Metric,
+			Source:   LSP_SOURCE,
+			Message:  fmt.Sprintf("%s is %.2f, expected %s %.2f", v.Metric, v.Value, v.Op, v.Limit),
+		})
+	}
+	// Most of the edits don't change the metrics, the client is only asked again for the
+	// lenses if they moved or changed
+	var previous, known = s.documents[td.URI]
+	s.documents[td.URI] = &doc
+	s.publishDiagnostics(td.URI, doc.diagnostics)
+	if s.refreshLenses && (!known || !slices.Equal(previous.lenses, doc.lenses)) {
+		s.send("workspace/codeLens/refresh", nil, true)
+	}
+}
+
+// Eg.: "CC 14 · ABC 22 · Halstead D 31", the Halstead difficulty only if it's enabled
+func (s *lspServer) lensTitle(f metrics.FunctionSnapshot) string {
+	var title = fmt.Sprintf("CC %d · ABC %d", f.CC, f.ABC)
+	if s.cfg.Enabled(metrics.METRIC_HALSTEAD) {
+		title += fmt.Sprintf(" · Halstead D %.0f", f.HalsteadDifficulty)
+	}
+	return title
+}
+
+func (s *lspServer) publishDiagnostics(uri string, diagnostics []lspDiagnostic) {
+	if diagnostics == nil {
+		diagnostics = []lspDiagnostic{}
+	}
+	s.send("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": diagnostics}, false)
+}
+
+// The local path of a 'file' URI, empty for other schemes
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// Converts the byte based column of the position into UTF-16 code units
+func lspPositionOf(src []byte, pos token.Position) lspPosition {
+	var lineStart = pos.Offset - (pos.Column - 1)
+	var character int
+	for b := src[lineStart:pos.Offset]; len(b) > 0; {
+		r, size := utf8.DecodeRune(b)
+		character += utf16.RuneLen(r)
+		b = b[size:]
+	}
+	return lspPosition{Line: pos.Line - 1, Character: character}
+}
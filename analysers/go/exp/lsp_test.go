@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func frame(body string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func TestLSPRead(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		input  string
+		method string
+		err    string // Part of the error, none if empty
+	}{
+		{"framed", frame(`{"method":"initialized"}`), "initialized", ""},
+		{"other headers", "Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: 24\r\n\r\n" + `{"method":"initialized"}`, "initialized", ""},
+		{"without \\r", "Content-Length: 24\n\n" + `{"method":"initialized"}`, "initialized", ""},
+		{"multi-byte body", frame(`{"method":"é"}`), "é", ""},
+		{"missing length", "Content-Type: x\r\n\r\n{}", "", "missing Content-Length"},
+		{"invalid length", "Content-Length: x\r\n\r\n{}", "", "invalid header"},
+		{"short body", "Content-Length: 30\r\n\r\n{}", "", "unexpected EOF"},
+		{"not JSON", frame("{"), "", "invalid message"},
+		{"nothing", "", "", "EOF"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var s = lspServer{in: bufio.NewReader(strings.NewReader(tc.input))}
+			msg, err := s.read()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, expected one with %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Method != tc.method {
+				t.Errorf("got %q, expected %q", msg.Method, tc.method)
+			}
+		})
+	}
+
+	// One message after the other, the body isn't read beyond its length
+	var s = lspServer{in: bufio.NewReader(strings.NewReader(frame(`{"method":"a"}`) + frame(`{"method":"b"}`)))}
+	for _, expected := range []string{"a", "b"} {
+		if msg, err := s.read(); err != nil || msg.Method != expected {
+			t.Errorf("got %q, %v, expected %q", msg.Method, err, expected)
+		}
+	}
+}
+
+func TestLSPWrite(t *testing.T) {
+	var out bytes.Buffer
+	var s = lspServer{out: &out}
+	s.send("window/logMessage", map[string]string{"message": "é"}, false)
+	s.send("workspace/codeLens/refresh", nil, true)
+	var expected = frame(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"message":"é"}}`) +
+		frame(`{"jsonrpc":"2.0","id":1,"method":"workspace/codeLens/refresh"}`)
+	if out.String() != expected {
+		t.Errorf("got %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestLSPPositionOf(t *testing.T) {
+	var src = []byte("package p\n\nvar é, 世, 😀, x = 1, 2, 3, 4\n")
+	var line = strings.Index(string(src), "var")
+	for _, tc := range []struct {
+		name      string
+		character int // In UTF-16 code units
+	}{
+		{"var", 0},
+		{"é", 4},  // 2 bytes, 1 code unit
+		{"世", 7},  // 3 bytes, 1 code unit
+		{"😀", 10}, // 4 bytes, a surrogate pair
+		{"x", 14},
+	} {
+		var offset = strings.Index(string(src), tc.name)
+		var pos = token.Position{Offset: offset, Line: 3, Column: offset - line + 1}
+		if got := lspPositionOf(src, pos); got != (lspPosition{Line: 2, Character: tc.character}) {
+			t.Errorf("%s: got %+v, expected line 2, character %d", tc.name, got, tc.character)
+		}
+	}
+	if got := lspPositionOf(src, token.Position{Offset: 0, Line: 1, Column: 1}); got != (lspPosition{}) {
+		t.Errorf("got %+v at the start", got)
+	}
+}
+
+// The client is asked for the lenses again only when they change
+func TestLSPRefreshLenses(t *testing.T) {
+	var root = t.TempDir()
+	var uri = (&url.URL{Scheme: "file", Path: root + "/main.go"}).String()
+	var input strings.Builder
+	var message = func(id int, method string, params any) {
+		var msg = map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+		if id > 0 {
+			msg["id"] = id
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input.WriteString(frame(string(data)))
+	}
+	var change = func(text string) {
+		message(0, "textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri},
+			"contentChanges": []map[string]string{{"text": text}},
+		})
+	}
+	const src = "package main\n\nfunc f(x int) int {\n\tif x > 0 {\n\t\treturn 1\n\t}\n\treturn 0\n}\n"
+	message(1, "initialize", map[string]any{
+		"rootUri":      (&url.URL{Scheme: "file", Path: root}).String(),
+		"capabilities": map[string]any{"workspace": map[string]any{"codeLens": map[string]bool{"refreshSupport": true}}},
+	})
+	message(0, "initialized", map[string]any{})
+	message(0, "textDocument/didOpen", map[string]any{"textDocument": map[string]string{"uri": uri, "text": src}})
+	change(src + "// A comment\n")           // Same lenses
+	change(src + "func g() {")               // Doesn't parse
+	change(src + "\nfunc g() {}\n")          // A new lens
+	change("\n" + src + "\nfunc g() {}\n")   // Moved
+	change("\n" + src + "\nfunc g() {}\n\n") // Same lenses
+	message(2, "shutdown", nil)
+	message(0, "exit", nil)
+
+	var out bytes.Buffer
+	var s = lspServer{in: bufio.NewReader(strings.NewReader(input.String())), out: &out, documents: map[string]*lspDocument{}}
+	if code := s.run(); code != EXIT_OK {
+		t.Fatalf("exited with %d", code)
+	}
+	var reader = lspServer{in: bufio.NewReader(&out)}
+	var refreshes, diagnostics int
+	for {
+		msg, err := reader.read()
+		if err != nil {
+			break
+		}
+		switch msg.Method {
+		case "workspace/codeLens/refresh":
+			refreshes++
+		case "textDocument/publishDiagnostics":
+			diagnostics++
+		}
+	}
+	// Opened, a function added then moved
+	if refreshes != 3 {
+		t.Errorf("%d refresh requests, expected 3", refreshes)
+	}
+	// Every measured version
+	if diagnostics != 5 {
+		t.Errorf("%d diagnostics, expected 5", diagnostics)
+	}
+}
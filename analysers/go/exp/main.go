@@ -1,150 +1,229 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
-	"io/fs"
-	"math"
 	"os"
-	"path/filepath"
-	"runtime"
+	"os/signal"
 	"strings"
-	"text/template"
 
+	"github.com/zkulcsar/metrics/exp/config"
 	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
 )
 
+// Exit codes
 const (
-	WORKER_PERCENT float64 = 0.8 // The percentage of the total number of cores to be used
+	EXIT_OK          int = 0 // Successful run, the gate (if enabled) passed
+	EXIT_ERROR       int = 1 // Invalid arguments or the analysis failed
+	EXIT_GATE_FAILED int = 2 // The analysis ran, but at least one threshold is violated or regressed
+	EXIT_INCOMPLETE  int = 3 // The gate (if enabled) passed, but files were skipped or measured partially (-keep-going, -tolerant)
 )
 
 func main() {
-	dirname := flag.String("d", "", "Directory containing Go files to parse")
-	// We default to WORKER_PERCENT (80) percent of the available cores, unless it's explicitly set
-	nrOfWorkers := flag.Int("w", int(math.Floor(float64(runtime.NumCPU())*WORKER_PERCENT)), "Nr of workers")
-	flag.Parse()
+	// Quiet, unless -log-level says otherwise
+	setupLogging("warn", LOG_TEXT)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "cache":
+			os.Exit(runCache(os.Args[2:]))
+		case "history":
+			os.Exit(runHistory(os.Args[2:]))
+		case "watch":
+			os.Exit(runWatch(os.Args[2:]))
+		case "serve":
+			os.Exit(runServe(os.Args[2:]))
+		case "lsp":
+			os.Exit(runLSP(os.Args[2:]))
+		}
+	}
+
+	os.Exit(runAnalysis(os.Args[1:]))
+}
+
+// Handles the analysis of a directory, the default command
+func runAnalysis(args []string) int {
+	af, err := parseAnalysisFlags(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+	progress, finishProgress, err := newProgress(af.progress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid arguments: %v\n", err)
+		return EXIT_ERROR
+	}
+	baseline, ratchetBaseline, err := af.snapshots()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+	cfg, err := af.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
 
-	fileMetrics := make([]metrics.FileMetric, 0)
-	paths := make([]string, 0)
+	report, changes, err := analyseChanges(af, &cfg, progress)
+	finishProgress()
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "interrupted\n")
+		return EXIT_ERROR
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+	var data = newSummaryData(report.Project, af.dir, report.FileMetrics(), report.SummaryMetrics(), report.Diagnostics, &cfg)
+	data.setReport(report)
+	printIncomplete(&data)
+	failed, err := secondaryReports(&data, af, &cfg, report, changes, baseline, ratchetBaseline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+	if err := writeReports(data, cfg.Output); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
 	switch {
-	// At least one has to be specified
-	case *dirname == "":
-		flag.Usage()
-		os.Exit(1)
-	// Check directory & walk
-	case *dirname != "":
-		info, err := os.Stat(*dirname)
+	case failed:
+		return EXIT_GATE_FAILED
+	case report.Incomplete() && (cfg.KeepGoing || cfg.Tolerant):
+		// Otherwise only 'cloc' failures leave the results incomplete, that's not worth failing for
+		return EXIT_INCOMPLETE
+	}
+	return EXIT_OK
+}
+
+// Analyses the directory of the flags, only the files changed since -base if it's set
+func analyseChanges(af *analysisFlags, cfg *config.Config, progress func(engine.Progress)) (*engine.Report, *changeSet, error) {
+	var changes *changeSet
+	var only func(rel string) bool
+	if af.base != "" {
+		var err error
+		if changes, err = readChangeSet(af.dir, af.base); err != nil {
+			return nil, nil, fmt.Errorf("read changes: %w", err)
+		}
+		only = changes.selected
+	}
+	// An interrupt stops walking & measuring, afterwards it terminates the process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := analyseOnly(ctx, af.dir, cfg, only, progress)
+	return report, changes, err
+}
+
+// Adds the reports that go beyond the summary to data, failed is set if the gate or the
+// ratchet failed
+func secondaryReports(data *summaryData, af *analysisFlags, cfg *config.Config, report *engine.Report, changes *changeSet,
+	baseline *metrics.Snapshot, ratchetBaseline *metrics.Snapshot) (failed bool, err error) {
+	if changes != nil {
+		changed, err := computeChanged(changes, af.dir, cfg, report.FileMetrics(), report.Generated)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "directory %q not found: %v\n", *dirname, err)
-			os.Exit(1)
+			return false, fmt.Errorf("changed functions: %w", err)
+		}
+		data.Changed = &changed
+	}
+	if af.snapshotFile != "" || baseline != nil || ratchetBaseline != nil || cfg.Hotspots.Enabled || cfg.Ownership.Enabled || cfg.Output.Database != "" {
+		snapshot := report.Snapshot()
+		if err := saveSnapshot(af, cfg, report, &snapshot); err != nil {
+			return false, err
 		}
-		if !info.IsDir() {
-			fmt.Fprintf(os.Stderr, "%q is not a directory\n", *dirname)
-			os.Exit(1)
+		if failed, err = compareSnapshot(data, af, cfg, &snapshot, baseline, ratchetBaseline); err != nil {
+			return false, err
 		}
-		//fmt.Fprintf(os.Stdout, "*** walking directory %s\n", *dirname)
-		if err := collectPaths(*dirname, &paths); err != nil {
-			fmt.Fprintf(os.Stdout, "walk directory %q: %v\n", *dirname, err)
-			os.Exit(1)
+		if err := historyReports(data, af.dir, cfg, &snapshot); err != nil {
+			return false, err
 		}
 	}
-
-	if len(paths) > 0 {
-		var err error
-		fmt.Printf("Parsing the '%s' folder with %d workers.\n", *dirname, *nrOfWorkers)
-		fileMetrics, err = parseConcurrently(paths, *nrOfWorkers)
+	if cfg.Coupling.Enabled {
+		coupling, err := runCoupling(af.dir, report.FileMetrics(), cfg.Coupling)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "parse files: %v\n", err)
-			os.Exit(1)
+			return false, fmt.Errorf("coupling: %w", err)
 		}
+		data.Coupling = &coupling
 	}
-
-	var sm = metrics.SummaryMetrics{}
-	sm.CalculateMetrics(fileMetrics)
-	tmpl, err := template.ParseFiles("exp/templates/summary.md.tmpl")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse template: %v\n", err)
-		os.Exit(1)
-	}
-	// TODO: do better, SummaryMetrics should handle all of this
-	data := struct {
-		Project            string
-		CompositeScore     float64
-		TotalNrOfFiles     int
-		TotalCodeLOC       int
-		TotalCommentLOC    int
-		NrOfDImports       int
-		NrOfStructs        int
-		NrOfFunctions      int
-		NrOfComplexFuncs   int
-		FunPerFMedian      float64
-		StrucPerFMedian    float64
-		LocPerFMedian      float64
-		CommentDensity     float64
-		CyclDestinyPerkLOC float64
-		CyclCAverage       float64
-		CyclCMedian        float64
-		CyclCP95           float64
-		CyclCHighRate      float64
-		CyclCConcentration float64
-		HalVolumePerkLOC   float64
-		HalEffortPerkLOC   float64
-		HalDifMedian       float64
-		ABCCodeSizePerFun  float64
-		ABCBranCondRatio   float64
-		ABCHighRate        float64
-	}{
-		Project:            filepath.Base(*dirname),
-		CompositeScore:     sm.CompositeScore(),
-		TotalNrOfFiles:     sm.TotalNrOfFiles(),
-		TotalCodeLOC:       sm.TotalCodeLOC(),
-		TotalCommentLOC:    sm.TotalCommentLOC(),
-		NrOfDImports:       sm.NrOfDImports(),
-		NrOfStructs:        sm.NrOfStructs(),
-		NrOfFunctions:      sm.NrOfFunctions(),
-		NrOfComplexFuncs:   sm.NrOfComplexFuncs(),
-		FunPerFMedian:      sm.FunPerFMedian(),
-		StrucPerFMedian:    sm.StrucPerFMedian(),
-		LocPerFMedian:      sm.LocPerFMedian(),
-		CommentDensity:     sm.CommentDensity(),
-		CyclDestinyPerkLOC: sm.CyclDestinyPerkLOC(),
-		CyclCAverage:       sm.CyclCAverage(),
-		CyclCMedian:        sm.CyclCMedian(),
-		CyclCP95:           sm.CyclCP95(),
-		CyclCHighRate:      sm.CyclCHighRate(),
-		CyclCConcentration: sm.CyclCConcentration(),
-		HalVolumePerkLOC:   sm.HalVolumePerkLOC(),
-		HalEffortPerkLOC:   sm.HalEffortPerkLOC(),
-		HalDifMedian:       sm.HalDifMedian(),
-		ABCCodeSizePerFun:  sm.ABCCodeSizePerFun(),
-		ABCBranCondRatio:   sm.ABCBranCondRatio(),
-		ABCHighRate:        sm.ABCHighRate(),
-	}
-	// TODO: add the option to generate into a file
-	if err := tmpl.Execute(os.Stdout, data); err != nil {
-		// TODO: after this all of it should be handled as log rather than \W?[p]rintf()
-		fmt.Fprintf(os.Stderr, "execute template: %v\n", err)
+	if cfg.Gate.Enabled {
+		data.Gate = &gateReport{Violations: append([]metrics.Violation{}, report.Violations()...), NrOfThresholds: len(cfg.Gate.Thresholds)}
+		failed = failed || len(data.Gate.Violations) > 0
 	}
+	return failed, nil
 }
 
-func collectPaths(root string, paths *[]string) error {
-	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+// Appends the run to the database and saves the snapshot file, if they are configured
+func saveSnapshot(af *analysisFlags, cfg *config.Config, report *engine.Report, snapshot *metrics.Snapshot) error {
+	if cfg.Output.Database != "" {
+		if _, err := saveRun(af.dir, report.FileMetrics(), snapshot, cfg); err != nil {
+			return fmt.Errorf("save run: %w", err)
+		}
+	}
+	if af.snapshotFile != "" {
+		if err := snapshot.Save(af.snapshotFile); err != nil {
+			return fmt.Errorf("save snapshot: %w", err)
 		}
-		if d.IsDir() {
-			return nil
+	}
+	return nil
+}
+
+// Compares the snapshot to the baseline & the ratchet snapshot, if they are given. Reports
+// whether anything regressed against the latter.
+func compareSnapshot(data *summaryData, af *analysisFlags, cfg *config.Config, snapshot *metrics.Snapshot,
+	baseline *metrics.Snapshot, ratchetBaseline *metrics.Snapshot) (bool, error) {
+	if baseline != nil {
+		diff := metrics.Compare(baseline, snapshot)
+		data.Diff = &diff
+	}
+	if ratchetBaseline == nil {
+		return false, nil
+	}
+	data.Ratchet = &ratchetReport{RatchetResult: cfg.Ratchet.Check(ratchetBaseline, snapshot, cfg.GateConfig())}
+	if data.Ratchet.Improved && af.ratchetUpdate {
+		if err := snapshot.Save(af.ratchetFile); err != nil {
+			return false, fmt.Errorf("update ratchet snapshot: %w", err)
 		}
-		if filepath.Ext(path) != ".go" {
-			// We can't measure but go source code only
-			return nil
+		data.Ratchet.Updated = af.ratchetFile
+	}
+	return len(data.Ratchet.Regressions) > 0, nil
+}
+
+// The git analyses that rank the files of the snapshot
+func historyReports(data *summaryData, dir string, cfg *config.Config, snapshot *metrics.Snapshot) error {
+	if cfg.Hotspots.Enabled {
+		hotspots, err := runHotspots(dir, snapshot, cfg.Hotspots)
+		if err != nil {
+			return fmt.Errorf("hotspots: %w", err)
 		}
-		if strings.Contains(path, "_test.go") {
-			// Skipping over "test" files
-			return nil
+		data.Hotspots = &hotspots
+	}
+	if cfg.Ownership.Enabled {
+		ownership, err := computeOwnership(dir, snapshot, cfg.Workers, cfg.Ownership)
+		if err != nil {
+			return fmt.Errorf("ownership: %w", err)
 		}
-		*paths = append(*paths, path)
-		return nil
-	})
+		data.Ownership = &ownership
+	}
 	return nil
 }
+
+// Loads the explicitly given configuration file, or discovers one upward from dir
+func loadConfig(fileName string, dir string) (config.Config, error) {
+	if fileName != "" {
+		return config.Load(fileName)
+	}
+	cfg, _, err := config.ForDir(dir)
+	return cfg, err
+}
+
+func splitList(list string) []string {
+	var items = []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,572 @@
+package metrics
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+	"sort"
+)
+
+// The quantile estimators of the aggregation
+const (
+	QUANTILES_EXACT   string = "exact"   // Keeps every value, one float64 per function & file
+	QUANTILES_TDIGEST string = "tdigest" // A t-digest sketch, approximate but in bounded memory
+)
+
+var Quantiles = []string{QUANTILES_EXACT, QUANTILES_TDIGEST}
+
+// The compression of the t-digests, roughly the max nr of centroids kept
+const TDIGEST_COMPRESSION float64 = 100
+
+// A stream of values, summarised by their count, sum, max, mean, standard deviation and
+// quantiles
+type distribution struct {
+	n      int
+	sum    *big.Float // Big, as sumFloatBig
+	fsum   float64    // Plain, as the plugin sums
+	max    float64
+	mean   float64 // Welford's running mean
+	m2     float64 // and sum of the squared differences from it
+	values []float64
+	digest *tDigest // Instead of the values, for approximate quantiles
+}
+
+func newDistribution(quantiles string) *distribution {
+	var d = distribution{sum: new(big.Float)}
+	if quantiles == QUANTILES_TDIGEST {
+		d.digest = newTDigest(TDIGEST_COMPRESSION)
+	}
+	return &d
+}
+
+func (d *distribution) add(v float64) {
+	if d.n == 0 || v > d.max {
+		d.max = v
+	}
+	d.n++
+	d.fsum += v
+	if !math.IsNaN(v) {
+		d.sum.Add(d.sum, new(big.Float).SetFloat64(v))
+	}
+	var delta = v - d.mean
+	d.mean += delta / float64(d.n)
+	d.m2 += delta * (v - d.mean)
+	if d.digest == nil {
+		d.values = append(d.values, v)
+	} else if !math.IsNaN(v) {
+		d.digest.add(v)
+	}
+}
+
+// Adds the values of o, which has the same estimator
+func (d *distribution) merge(o *distribution) {
+	if o.n == 0 {
+		return
+	}
+	if d.n == 0 || o.max > d.max {
+		d.max = o.max
+	}
+	// Chan's parallel variant of Welford's
+	var n = d.n + o.n
+	var delta = o.mean - d.mean
+	d.m2 += o.m2 + delta*delta*float64(d.n)*float64(o.n)/float64(n)
+	d.mean += delta * float64(o.n) / float64(n)
+	d.n = n
+	d.fsum += o.fsum
+	d.sum.Add(d.sum, o.sum)
+	if d.digest == nil {
+		d.values = append(d.values, o.values...)
+	} else {
+		d.digest.merge(o.digest)
+	}
+}
+
+// The sum, as sumFloatBig (NaNs count as 0)
+func (d *distribution) total() *big.Float {
+	return new(big.Float).Set(d.sum)
+}
+
+func (d *distribution) average() float64 {
+	if d.n == 0 {
+		return 0
+	}
+	return div(d.total(), float64(d.n))
+}
+
+func (d *distribution) median() float64 {
+	if d.digest == nil {
+		return medianFloat64(d.values)
+	}
+	return d.digest.quantile(0.5)
+}
+
+func (d *distribution) percentile(p float64) float64 {
+	if d.digest == nil {
+		return percentileFloat64(d.values, p)
+	}
+	return d.digest.quantile(p / 100)
+}
+
+// The z-score of value within the distribution (population standard deviation)
+func (d *distribution) zScore(value float64) float64 {
+	if d.n == 0 {
+		return 0
+	}
+	var mean, std float64
+	if d.digest == nil {
+		mean = meanFloat64(d.values)
+		std = stddevFloat64(d.values, mean)
+	} else {
+		mean, std = d.mean, math.Sqrt(d.m2/float64(d.n))
+	}
+	if std == 0 {
+		return 0
+	}
+	return (value - mean) / std
+}
+
+func (d *distribution) aggregate(a Aggregation) float64 {
+	if d.digest == nil {
+		return aggregate(a, d.values)
+	}
+	switch a {
+	case AGGREGATION_SUM:
+		return d.fsum
+	case AGGREGATION_MAX:
+		return d.max
+	case AGGREGATION_MEAN:
+		return d.average()
+	case AGGREGATION_MEDIAN:
+		return d.median()
+	case AGGREGATION_P95:
+		return d.percentile(95)
+	}
+	return 0
+}
+
+// A merging t-digest (Dunning & Ertl): the values are buffered, then merged into centroids
+// whose size is bounded by the k1 scale function, so the tails stay accurate
+type tDigest struct {
+	compression float64
+	centroids   []centroid // Sorted by mean
+	buffer      []centroid // Not merged yet
+	count       float64
+	min, max    float64
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+func newTDigest(compression float64) *tDigest {
+	return &tDigest{compression: compression}
+}
+
+func (td *tDigest) add(v float64) {
+	if td.count == 0 || v < td.min {
+		td.min = v
+	}
+	if td.count == 0 || v > td.max {
+		td.max = v
+	}
+	td.count++
+	td.buffer = append(td.buffer, centroid{mean: v, weight: 1})
+	if len(td.buffer) >= int(5*td.compression) {
+		td.compress()
+	}
+}
+
+// Adds the centroids of o, as if its values were added
+func (td *tDigest) merge(o *tDigest) {
+	if o.count == 0 {
+		return
+	}
+	if td.count == 0 || o.min < td.min {
+		td.min = o.min
+	}
+	if td.count == 0 || o.max > td.max {
+		td.max = o.max
+	}
+	td.count += o.count
+	td.buffer = append(td.buffer, o.centroids...)
+	td.buffer = append(td.buffer, o.buffer...)
+	td.compress()
+}
+
+func (td *tDigest) compress() {
+	if len(td.buffer) == 0 {
+		return
+	}
+	var all = make([]centroid, 0, len(td.centroids)+len(td.buffer))
+	all = append(all, td.centroids...)
+	all = append(all, td.buffer...)
+	td.buffer = td.buffer[:0]
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	var merged = make([]centroid, 0, int(td.compression))
+	var current = all[0]
+	var before float64 // The weight of the centroids before current
+	var kLeft = td.scale(0)
+	for _, c := range all[1:] {
+		var proposed = current.weight + c.weight
+		if td.scale((before+proposed)/td.count)-kLeft <= 1 {
+			current.mean += (c.mean - current.mean) * c.weight / proposed
+			current.weight = proposed
+			continue
+		}
+		before += current.weight
+		kLeft = td.scale(before / td.count)
+		merged = append(merged, current)
+		current = c
+	}
+	td.centroids = append(merged, current)
+}
+
+// The k1 scale function: a centroid spans at most 1 on this scale, which is the finest at the
+// tails and bounds the nr of centroids by the compression
+func (td *tDigest) scale(q float64) float64 {
+	return td.compression / (2 * math.Pi) * math.Asin(2*min(max(q, 0), 1)-1)
+}
+
+// The q-quantile (0 <= q <= 1), interpolated between the centres of the centroids. Without
+// merged centroids it's the same as percentileFloat64.
+func (td *tDigest) quantile(q float64) float64 {
+	td.compress()
+	switch {
+	case td.count == 0:
+		return 0
+	case q <= 0:
+		return td.min
+	case q >= 1:
+		return td.max
+	}
+	var index = q*(td.count-1) + 0.5
+	var before float64
+	for i, c := range td.centroids {
+		var centre = before + c.weight/2
+		if index <= centre {
+			if i == 0 {
+				return td.min + (c.mean-td.min)*index/centre
+			}
+			var prev = td.centroids[i-1]
+			var prevCentre = before - prev.weight/2
+			return prev.mean + (c.mean-prev.mean)*(index-prevCentre)/(centre-prevCentre)
+		}
+		before += c.weight
+	}
+	var last = td.centroids[len(td.centroids)-1]
+	var lastCentre = td.count - last.weight/2
+	return last.mean + (td.max-last.mean)*(index-lastCentre)/(td.count-lastCentre)
+}
+
+// Folds the files into the summary one by one, so that they don't have to be kept in memory.
+// SummaryMetrics.CalculateMetrics is the same over a slice, with exact quantiles.
+type Aggregator struct {
+	settings  Settings
+	quantiles string
+
+	files        int
+	codeLOC      int
+	commentLOC   int
+	imports      map[string]bool
+	structs      int
+	functions    int
+	complexFuncs int
+
+	// Over the functions with ABC code size > 0
+	cc      *distribution
+	abc     *distribution
+	ccHigh  int
+	abcHigh int
+	ccTop   []float64 // The CC of the top N functions, descending
+	// Over the files
+	halVolume        *big.Float
+	halEffort        *big.Float
+	halEffortPerK    *distribution
+	commentDensities *distribution
+	funPerFile       *distribution
+	structsPerFile   *distribution
+	locPerFunction   *distribution
+	// The file & function values of the plugins, by name
+	plugins map[string]*distribution
+}
+
+// An aggregator with the quantile estimator of Quantiles, exact if empty
+func NewAggregator(settings Settings, quantiles string) *Aggregator {
+	if quantiles == "" {
+		quantiles = QUANTILES_EXACT
+	}
+	return &Aggregator{
+		settings:         settings,
+		quantiles:        quantiles,
+		imports:          map[string]bool{},
+		cc:               newDistribution(quantiles),
+		abc:              newDistribution(quantiles),
+		halVolume:        new(big.Float),
+		halEffort:        new(big.Float),
+		halEffortPerK:    newDistribution(quantiles),
+		commentDensities: newDistribution(quantiles),
+		funPerFile:       newDistribution(quantiles),
+		structsPerFile:   newDistribution(quantiles),
+		locPerFunction:   newDistribution(quantiles),
+		plugins:          map[string]*distribution{},
+	}
+}
+
+// Folds the file into the summaries: the simple metrics, the built-in groups & the plugins
+func (a *Aggregator) Add(fm *FileMetric) {
+	a.files++
+	for imp := range fm.imports {
+		a.imports[imp] = true
+	}
+	a.structs += fm.nrOfStructs
+
+	funsInFile := len(fm.abcMetrics)
+	a.functions += funsInFile
+	a.funPerFile.add(float64(funsInFile))
+	a.structsPerFile.add(float64(fm.nrOfStructs))
+
+	codeLOC := fm.nrOfLines.Go.Code
+	commentLOC := fm.nrOfLines.Go.Comment
+	a.codeLOC += codeLOC
+	a.commentLOC += commentLOC
+
+	funsWithMetrics := 0
+	for _, abcm := range fm.abcMetrics {
+		if abcm.CodeSize() > 0 {
+			funsWithMetrics++
+		}
+	}
+	if funsWithMetrics > 0 {
+		a.locPerFunction.add(float64(codeLOC) / float64(funsWithMetrics))
+	}
+	a.complexFuncs += funsWithMetrics
+	if codeLOC+commentLOC > 0 {
+		a.commentDensities.add(float64(commentLOC) / float64(codeLOC+commentLOC))
+	}
+
+	for _, m := range builtins() {
+		m.aggregate(a, fm)
+	}
+	for _, m := range plugins() {
+		var name = m.Name()
+		if v, ok := fm.values[name]; ok {
+			a.plugin(name).add(v)
+		}
+		for j := range fm.functions {
+			if v, ok := fm.functions[j].values[name]; ok {
+				a.plugin(name).add(v)
+			}
+		}
+	}
+}
+
+// Folds the files added to o into a, as if they were added to a. Both must have the same
+// quantile estimator, the settings of a are kept.
+func (a *Aggregator) Merge(o *Aggregator) {
+	if a.quantiles != o.quantiles {
+		panic(fmt.Sprintf("metrics: merging %s quantiles into %s ones", o.quantiles, a.quantiles))
+	}
+	a.files += o.files
+	a.codeLOC += o.codeLOC
+	a.commentLOC += o.commentLOC
+	maps.Copy(a.imports, o.imports)
+	a.structs += o.structs
+	a.functions += o.functions
+	a.complexFuncs += o.complexFuncs
+
+	a.cc.merge(o.cc)
+	a.abc.merge(o.abc)
+	a.ccHigh += o.ccHigh
+	a.abcHigh += o.abcHigh
+	for _, cc := range o.ccTop {
+		a.addTop(cc)
+	}
+	a.halVolume.Add(a.halVolume, o.halVolume)
+	a.halEffort.Add(a.halEffort, o.halEffort)
+	a.halEffortPerK.merge(o.halEffortPerK)
+	a.commentDensities.merge(o.commentDensities)
+	a.funPerFile.merge(o.funPerFile)
+	a.structsPerFile.merge(o.structsPerFile)
+	a.locPerFunction.merge(o.locPerFunction)
+	for _, name := range slices.Sorted(maps.Keys(o.plugins)) {
+		a.plugin(name).merge(o.plugins[name])
+	}
+}
+
+// The CC of the functions with ABC code size > 0
+func (a *Aggregator) addCC(fm *FileMetric) {
+	for i := 0; i < minInt(len(fm.abcMetrics), len(fm.cycloCMetric)); i++ {
+		if fm.abcMetrics[i].CodeSize() == 0 {
+			continue
+		}
+		cc := float64(fm.cycloCMetric[i].ccm)
+		a.cc.add(cc)
+		if cc > float64(a.settings.Levels.CCHigh) {
+			a.ccHigh++
+		}
+		a.addTop(cc)
+	}
+}
+
+// The ABC code size of the functions, the ones of 0 are left out
+func (a *Aggregator) addABC(fm *FileMetric) {
+	for _, abcm := range fm.abcMetrics {
+		if abcm.CodeSize() == 0 {
+			continue
+		}
+		a.abc.add(float64(abcm.CodeSize()))
+		if float64(abcm.CodeSize()) > a.settings.Levels.ABCHigh {
+			a.abcHigh++
+		}
+	}
+}
+
+// The Halstead measures of the file, the effort also per kLOC
+func (a *Aggregator) addHalstead(fm *FileMetric) {
+	var kLocMagnitude = float64(a.settings.Levels.KLOCMagnitude * 1000)
+	var codeLOC = fm.nrOfLines.Go.Code
+	eff := fm.fileHalstead.Effort()
+	a.halVolume.Add(a.halVolume, bigFloat(fm.fileHalstead.Volume()))
+	a.halEffort.Add(a.halEffort, bigFloat(eff))
+	if codeLOC > 0 {
+		a.halEffortPerK.add(eff / (float64(codeLOC) / kLocMagnitude))
+	}
+}
+
+// Keeps the CC of the top N functions for the concentration
+func (a *Aggregator) addTop(cc float64) {
+	var n = a.settings.Levels.CCTopN
+	if n <= 0 || len(a.ccTop) == n && cc <= a.ccTop[n-1] {
+		return
+	}
+	var i = sort.Search(len(a.ccTop), func(i int) bool { return a.ccTop[i] < cc })
+	a.ccTop = slices.Insert(a.ccTop, i, cc)
+	if len(a.ccTop) > n {
+		a.ccTop = a.ccTop[:n]
+	}
+}
+
+func (a *Aggregator) plugin(name string) *distribution {
+	d, ok := a.plugins[name]
+	if !ok {
+		d = newDistribution(a.quantiles)
+		a.plugins[name] = d
+	}
+	return d
+}
+
+// The summary of the files added so far
+func (a *Aggregator) Summary() SummaryMetrics {
+	var sm = SummaryMetrics{settings: a.settings}
+	var kLocMagnitude = float64(a.settings.Levels.KLOCMagnitude * 1000)
+	kLOC := float64(a.codeLOC) / kLocMagnitude
+
+	// Cyclomatic complexity metrics
+	if kLOC > 0 {
+		sm.cyclDestinyPerkLOC = div(a.cc.total(), kLOC)
+	}
+	sm.cyclCAverage = a.cc.average()
+	sm.cyclCMedian = a.cc.median()
+	sm.cyclCP95 = a.cc.percentile(95)
+	if a.cc.n > 0 {
+		sm.cyclCHighRate = float64(a.ccHigh) / float64(a.cc.n)
+	}
+	sm.cyclCConcentration = concentration(a.ccTop, a.cc.total())
+
+	// Halstead metrics
+	if kLOC > 0 {
+		sm.halVolumePerkLOC = div(new(big.Float).Set(a.halVolume), kLOC)
+		sm.halEffortPerkLOC = div(new(big.Float).Set(a.halEffort), kLOC)
+	}
+	// As requested: median CC over functions with ABC code size > 0
+	sm.halDifMedian = sm.cyclCMedian
+
+	// ABC metrics
+	sm.abcCodeSizePerFun = a.abc.median()
+	if a.abc.n > 0 {
+		sm.abcBranCondRatio = a.abc.average()
+		sm.abcHighRate = float64(a.abcHigh) / float64(a.abc.n)
+	}
+
+	// Simple metrics
+	sm.totalNrOfFiles = a.files
+	sm.totalCodeLOC = a.codeLOC
+	sm.totalCommentLOC = a.commentLOC
+	sm.nrOfDImports = len(a.imports)
+	sm.nrOfStructs = a.structs
+	sm.nrOfFunctions = a.functions
+	sm.nrOfComplexFuncs = a.complexFuncs
+	sm.funPerFMedian = a.funPerFile.median()
+	sm.strucPerFMedian = a.structsPerFile.median()
+	sm.locPerFMedian = a.locPerFunction.median()
+	if a.codeLOC+a.commentLOC > 0 {
+		sm.commentDensity = float64(a.commentLOC) / float64(a.codeLOC+a.commentLOC)
+	}
+
+	// Composite score (z-scores within this project)
+	w := a.settings.Weights
+	sm.compositeScore = w.CCMedian*a.cc.zScore(sm.cyclCMedian) +
+		w.CCP95*a.cc.zScore(sm.cyclCP95) +
+		w.ABCPerFunction*a.abc.zScore(sm.abcCodeSizePerFun) +
+		w.HalEffort*a.halEffortPerK.zScore(sm.halEffortPerkLOC) -
+		w.CommentDensity*a.commentDensities.zScore(sm.commentDensity)
+	return sm
+}
+
+// The summaries of the plugins over the files added so far, named <name>_<aggregation>. The
+// package collectors need every file, they are not run.
+func (a *Aggregator) PluginValues() map[string]float64 {
+	var values = map[string]float64{}
+	for _, m := range plugins() {
+		d, ok := a.plugins[m.Name()]
+		if !ok || d.n == 0 {
+			continue
+		}
+		for _, agg := range m.Aggregations() {
+			setFinite(values, m.Name()+"_"+string(agg), d.aggregate(agg))
+		}
+	}
+	return values
+}
+
+// The summary values of sm named as the package & project gate metrics, with the plugin ones
+func SummaryValues(sm *SummaryMetrics, plugins map[string]float64) map[string]float64 {
+	var values = make(map[string]float64, len(summaryValues)+len(plugins))
+	for name, value := range plugins {
+		values[name] = value
+	}
+	for name, value := range summaryValues {
+		setFinite(values, name, value(sm))
+	}
+	return values
+}
+
+// The sum of the top N values over the total
+func concentration(top []float64, total *big.Float) float64 {
+	if total.Sign() == 0 {
+		// Only trivial functions, there is nothing to concentrate
+		return 0
+	}
+	var topSum = sumFloatBig(top)
+	var bconc = topSum.Quo(topSum, total)
+	var fconc, acc = bconc.Float64()
+	if acc == big.Exact {
+		return fconc
+	} else {
+		slog.Debug("the CC concentration doesn't fit into a float64", "concentration", bconc)
+		return math.NaN()
+	}
+}
+
+// As in sumFloatBig, NaNs count as 0
+func bigFloat(v float64) *big.Float {
+	if math.IsNaN(v) {
+		v = 0
+	}
+	return new(big.Float).SetFloat64(v)
+}
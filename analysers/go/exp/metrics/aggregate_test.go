@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestTDigestQuantile(t *testing.T) {
+	var tests = []struct {
+		name   string
+		values []float64
+		q      float64
+		want   float64
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single", []float64{7}, 0.5, 7},
+		{"min", []float64{3, 1, 2}, 0, 1},
+		{"max", []float64{3, 1, 2}, 1, 3},
+		{"median odd", []float64{5, 1, 3}, 0.5, 3},
+		{"median even", []float64{4, 1, 3, 2}, 0.5, 2.5},
+		{"p95", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0.95, 9.55},
+		{"duplicates", []float64{2, 2, 2, 2}, 0.3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var td = newTDigest(TDIGEST_COMPRESSION)
+			for _, v := range tt.values {
+				td.add(v)
+			}
+			if got := td.quantile(tt.q); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("quantile(%v) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTDigestMerge(t *testing.T) {
+	var tests = []struct {
+		name string
+		n    int
+		tol  float64 // Of the range of the values
+	}{
+		{"one merge", 1000, 0.01},
+		{"many merges", 100000, 0.01},
+		{"large", 1000000, 0.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rnd = rand.New(rand.NewPCG(1, 2))
+			var td = newTDigest(TDIGEST_COMPRESSION)
+			var values = make([]float64, 0, tt.n)
+			for range tt.n {
+				v := rnd.Float64() * 1000
+				values = append(values, v)
+				td.add(v)
+			}
+			td.compress()
+			if len(td.centroids) > int(TDIGEST_COMPRESSION) {
+				t.Errorf("%d centroids, want at most %d", len(td.centroids), int(TDIGEST_COMPRESSION))
+			}
+			var weight float64
+			for i, c := range td.centroids {
+				weight += c.weight
+				if i > 0 && c.mean < td.centroids[i-1].mean {
+					t.Fatalf("centroid %d is out of order: %v < %v", i, c.mean, td.centroids[i-1].mean)
+				}
+			}
+			if weight != float64(tt.n) {
+				t.Errorf("total weight %v, want %d", weight, tt.n)
+			}
+			for _, q := range []float64{0.01, 0.25, 0.5, 0.75, 0.95, 0.99} {
+				want := percentileFloat64(values, q*100)
+				if got := td.quantile(q); math.Abs(got-want) > tt.tol*1000 {
+					t.Errorf("quantile(%v) = %v, want %v", q, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTDigestMergeDigests(t *testing.T) {
+	var rnd = rand.New(rand.NewPCG(3, 4))
+	var merged = newTDigest(TDIGEST_COMPRESSION)
+	var values []float64
+	for range 10 {
+		var td = newTDigest(TDIGEST_COMPRESSION)
+		for range 20000 {
+			v := rnd.NormFloat64()*100 + 500
+			values = append(values, v)
+			td.add(v)
+		}
+		merged.merge(td)
+	}
+	merged.compress()
+	if merged.count != float64(len(values)) {
+		t.Errorf("count %v, want %d", merged.count, len(values))
+	}
+	if len(merged.centroids) > int(TDIGEST_COMPRESSION) {
+		t.Errorf("%d centroids, want at most %d", len(merged.centroids), int(TDIGEST_COMPRESSION))
+	}
+	for _, q := range []float64{0.01, 0.5, 0.95, 0.99} {
+		want := percentileFloat64(values, q*100)
+		if got := merged.quantile(q); math.Abs(got-want) > 5 {
+			t.Errorf("quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+}
+
+// Files with n functions of growing complexity
+func testFileMetrics(t *testing.T, n int) []FileMetric {
+	var fms []FileMetric
+	for i := range n {
+		var src = fmt.Sprintf("package p\n\nimport \"fmt\"\n\ntype S%d struct{}\n", i)
+		for j := range i + 1 {
+			src += fmt.Sprintf("\nfunc F%d(a int) int {\n\tb := a\n", j)
+			for k := range j {
+				src += fmt.Sprintf("\tif a > %d && b < %d {\n\t\tfmt.Println(b)\n\t}\n", k, k)
+			}
+			src += "\treturn b\n}\n"
+		}
+		var fset = token.NewFileSet()
+		tree, err := parser.ParseFile(fset, fmt.Sprintf("f%d.go", i), src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fm := NewFileMetric(fmt.Sprintf("f%d.go", i))
+		fm.Disable(METRIC_LOC)
+		if err := fm.GenerateMetrics(fset, tree); err != nil {
+			t.Fatal(err)
+		}
+		fms = append(fms, fm)
+	}
+	return fms
+}
+
+func TestAggregatorMerge(t *testing.T) {
+	var fms = testFileMetrics(t, 12)
+	for _, quantiles := range Quantiles {
+		t.Run(quantiles, func(t *testing.T) {
+			var whole = NewAggregator(DefaultSettings(), quantiles)
+			var parts = []*Aggregator{NewAggregator(DefaultSettings(), quantiles), NewAggregator(DefaultSettings(), quantiles)}
+			for i := range fms {
+				whole.Add(&fms[i])
+				parts[i%2].Add(&fms[i])
+			}
+			var merged = NewAggregator(DefaultSettings(), quantiles)
+			for _, p := range parts {
+				merged.Merge(p)
+			}
+			var want, got = whole.Summary(), merged.Summary()
+			var wantValues, gotValues = SummaryValues(&want, nil), SummaryValues(&got, nil)
+			for name, w := range wantValues {
+				if math.Abs(gotValues[name]-w) > 1e-9*math.Max(1, math.Abs(w)) {
+					t.Errorf("%s = %v, want %v", name, gotValues[name], w)
+				}
+			}
+		})
+	}
+}
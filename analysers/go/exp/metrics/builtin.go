@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"go/ast"
+)
+
+// A built-in metric group, named as in MetricGroups. It's measured & aggregated through the
+// registry as the plugins are, but it keeps its detailed results (eg.: the ABC components, the
+// Halstead operators) in FileMetric, and its summaries are the ones of SummaryMetrics (eg.:
+// cc_p95).
+type builtinMetric struct {
+	name        string
+	unit        string
+	description string
+	function    func(fm *FileMetric, f *ast.FuncDecl) // Into the last function of the file
+	file        func(fm *FileMetric, tree *ast.File)  // Optional
+	aggregate   func(a *Aggregator, fm *FileMetric)
+}
+
+func (m *builtinMetric) Name() string {
+	return m.name
+}
+
+func (m *builtinMetric) Unit() string {
+	return m.unit
+}
+
+func (m *builtinMetric) Description() string {
+	return m.description
+}
+
+// The summaries are the ones of SummaryMetrics (eg.: cc_p95)
+func (m *builtinMetric) Aggregations() []Aggregation {
+	return nil
+}
+
+func isBuiltin(m Metric) bool {
+	_, ok := m.(*builtinMetric)
+	return ok
+}
+
+// The CC & ABC are always measured, they are needed to count the functions
+func init() {
+	Register(&builtinMetric{
+		name:        METRIC_CC,
+		unit:        "paths",
+		description: "Cyclomatic Complexity",
+		function:    func(fm *FileMetric, f *ast.FuncDecl) { fm.GenerateCyclomaticComplexity(f) },
+		aggregate:   (*Aggregator).addCC,
+	})
+	Register(&builtinMetric{
+		name:        METRIC_ABC,
+		description: "ABC code size",
+		function:    func(fm *FileMetric, f *ast.FuncDecl) { fm.GenerateABCMetrics(f) },
+		aggregate:   (*Aggregator).addABC,
+	})
+	Register(&builtinMetric{
+		name:        METRIC_HALSTEAD,
+		description: "Halstead volume, difficulty & effort",
+		function: func(fm *FileMetric, f *ast.FuncDecl) {
+			if !fm.disabled[METRIC_HALSTEAD] {
+				ast.Walk(&fm.functions[len(fm.functions)-1].halstead, f)
+			}
+		},
+		file: func(fm *FileMetric, tree *ast.File) {
+			if !fm.disabled[METRIC_HALSTEAD] {
+				ast.Inspect(tree, func(n ast.Node) bool {
+					fm.GenerateHalsteadMetrics(n)
+					return true
+				})
+			}
+		},
+		aggregate: (*Aggregator).addHalstead,
+	})
+}
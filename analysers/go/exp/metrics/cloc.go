@@ -2,7 +2,9 @@ package metrics
 
 import (
 	"encoding/json"
+	"log/slog"
 	"os/exec"
+	"sync"
 )
 
 type FileClocStat struct {
@@ -39,3 +41,12 @@ func fileCLOC(filename string) (fileCloc FileClocStat, err error) {
 	err = json.Unmarshal(output, &fileCloc)
 	return
 }
+
+// Whether 'cloc' is installed, warns once if it's not: the LOC metrics can't be measured then
+var ClocAvailable = sync.OnceValue(func() bool {
+	if _, err := exec.LookPath("cloc"); err != nil {
+		slog.Warn("'cloc' is not installed, the LOC metrics are not measured", "err", err)
+		return false
+	}
+	return true
+})
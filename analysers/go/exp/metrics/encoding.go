@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"encoding/json"
+)
+
+// The version of the measurements, has to be bumped whenever a change alters the metrics of
+// the same source (cached results of other versions are not used)
+const ANALYSER_VERSION string = "2"
+
+// The serialised form of a FileMetric, for the result cache
+type fileMetricJSON struct {
+	FileName         string             `json:"file_name"`
+	Language         string             `json:"language,omitempty"`
+	Generated        bool               `json:"generated,omitempty"`
+	FileABC          abcJSON            `json:"file_abc"`
+	ABC              []abcJSON          `json:"abc"`
+	FileHalstead     halsteadJSON       `json:"file_halstead"`
+	CC               []ccJSON           `json:"cc"`
+	Functions        []functionJSON     `json:"functions"`
+	Imports          map[string]int     `json:"imports"`
+	NrOfFunctionDecl int                `json:"function_declarations"`
+	Lines            FileClocStat       `json:"lines"`
+	NrOfStructs      int                `json:"structs"`
+	Values           map[string]float64 `json:"values,omitempty"` // Of the file plugins
+	Disabled         []string           `json:"disabled,omitempty"`
+}
+
+type abcJSON struct {
+	Signature    string `json:"signature"`
+	Assignments  int    `json:"assignments"`
+	Branches     int    `json:"branches"`
+	Conditionals int    `json:"conditionals"`
+	CodeSize     int    `json:"code_size"`
+}
+
+type ccJSON struct {
+	Signature string `json:"signature"`
+	CC        int    `json:"cc"`
+}
+
+type halsteadJSON struct {
+	Operators map[string]int `json:"operators"`
+	Operands  map[string]int `json:"operands"`
+}
+
+type functionJSON struct {
+	ID        string             `json:"id"`
+	StartLine int                `json:"start_line"`
+	EndLine   int                `json:"end_line"`
+	Halstead  halsteadJSON       `json:"halstead"`
+	Values    map[string]float64 `json:"values,omitempty"` // Of the function plugins
+}
+
+func (fm FileMetric) MarshalJSON() ([]byte, error) {
+	var v = fileMetricJSON{
+		FileName:         fm.fileName,
+		Language:         fm.language,
+		Generated:        fm.generated,
+		FileABC:          fm.fileABCMetric.toJSON(),
+		ABC:              make([]abcJSON, 0, len(fm.abcMetrics)),
+		FileHalstead:     fm.fileHalstead.toJSON(),
+		CC:               make([]ccJSON, 0, len(fm.cycloCMetric)),
+		Functions:        make([]functionJSON, 0, len(fm.functions)),
+		Imports:          fm.imports,
+		NrOfFunctionDecl: fm.nrOfFunctionDeclarations,
+		Lines:            fm.nrOfLines,
+		NrOfStructs:      fm.nrOfStructs,
+		Values:           fm.values,
+		Disabled:         sortedKeys(fm.disabled),
+	}
+	for _, abc := range fm.abcMetrics {
+		v.ABC = append(v.ABC, abc.toJSON())
+	}
+	for _, cc := range fm.cycloCMetric {
+		v.CC = append(v.CC, ccJSON{Signature: cc.signature, CC: cc.ccm})
+	}
+	for _, fnm := range fm.functions {
+		v.Functions = append(v.Functions, functionJSON{
+			ID:        fnm.id,
+			StartLine: fnm.startLine,
+			EndLine:   fnm.endLine,
+			Halstead:  fnm.halstead.toJSON(),
+			Values:    fnm.values,
+		})
+	}
+	return json.Marshal(v)
+}
+
+func (fm *FileMetric) UnmarshalJSON(data []byte) error {
+	var v fileMetricJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*fm = NewFileMetric(v.FileName)
+	fm.language = v.Language
+	fm.generated = v.Generated
+	fm.fileABCMetric = v.FileABC.metric()
+	for _, abc := range v.ABC {
+		fm.abcMetrics = append(fm.abcMetrics, abc.metric())
+	}
+	fm.fileHalstead = v.FileHalstead.metric()
+	for _, cc := range v.CC {
+		fm.cycloCMetric = append(fm.cycloCMetric, CyclomaticComplexityMetric{signature: cc.Signature, ccm: cc.CC})
+	}
+	for _, f := range v.Functions {
+		fm.functions = append(fm.functions, FunctionMetric{
+			id:        f.ID,
+			startLine: f.StartLine,
+			endLine:   f.EndLine,
+			halstead:  f.Halstead.metric(),
+			values:    f.Values,
+		})
+	}
+	if v.Imports != nil {
+		fm.imports = v.Imports
+	}
+	fm.nrOfImports = len(fm.imports)
+	fm.nrOfFunctionDeclarations = v.NrOfFunctionDecl
+	fm.nrOfLines = v.Lines
+	fm.nrOfStructs = v.NrOfStructs
+	fm.values = v.Values
+	if len(v.Disabled) > 0 {
+		fm.Disable(v.Disabled...)
+	}
+	return nil
+}
+
+func (abcm *ABCMetric) toJSON() abcJSON {
+	return abcJSON{
+		Signature:    abcm.signature,
+		Assignments:  abcm.assingments,
+		Branches:     abcm.branches,
+		Conditionals: abcm.conditionals,
+		CodeSize:     abcm.codeSize,
+	}
+}
+
+func (v abcJSON) metric() ABCMetric {
+	return ABCMetric{
+		signature:    v.Signature,
+		assingments:  v.Assignments,
+		branches:     v.Branches,
+		conditionals: v.Conditionals,
+		codeSize:     v.CodeSize,
+	}
+}
+
+func (hm *HalsteadMetric) toJSON() halsteadJSON {
+	return halsteadJSON{Operators: hm.operators, Operands: hm.operands}
+}
+
+func (v halsteadJSON) metric() HalsteadMetric {
+	var hm HalsteadMetric
+	hm.Init()
+	for k, n := range v.Operators {
+		hm.operators[k] = n
+	}
+	for k, n := range v.Operands {
+		hm.operands[k] = n
+	}
+	return hm
+}
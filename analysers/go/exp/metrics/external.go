@@ -0,0 +1,86 @@
+package metrics
+
+// The language of the files measured by GenerateMetrics
+const LANGUAGE_GO string = "go"
+
+// The measurements of a file by an external analyser, the "file" of its JSON-lines responses
+// (see the backends of the analyzer package). The metrics follow the definitions of the Go
+// visitors: the CC counts the decision points, the ABC components are counted per function.
+type ExternalFile struct {
+	Lines *struct {
+		Code    int `json:"code"`
+		Comment int `json:"comment"`
+		Blank   int `json:"blank"`
+	} `json:"lines"` // Missing if the LOC metrics are disabled
+	Imports   []string           `json:"imports"`
+	Structs   int                `json:"structs"` // Classes, records, ...
+	Operators map[string]int     `json:"operators"`
+	Operands  map[string]int     `json:"operands"`
+	Functions []ExternalFunction `json:"functions"`
+}
+
+type ExternalFunction struct {
+	ID           string         `json:"id"`        // 'Name' or 'Type.Name', as GetFuncID
+	Signature    string         `json:"signature"` // 'Name(param, ...)', as GetFuncSignature
+	StartLine    int            `json:"start_line"`
+	EndLine      int            `json:"end_line"`
+	CC           int            `json:"cc"`
+	Assignments  int            `json:"assignments"`
+	Branches     int            `json:"branches"`
+	Conditionals int            `json:"conditionals"`
+	Operators    map[string]int `json:"operators"`
+	Operands     map[string]int `json:"operands"`
+}
+
+// The file metric of a file measured by an external analyser. The plugins are not run, they
+// need the Go AST.
+func NewExternalFileMetric(fileName string, language string, ef ExternalFile, disabled []string) FileMetric {
+	var fm = NewFileMetric(fileName)
+	fm.language = language
+	fm.Disable(disabled...)
+	for _, imp := range ef.Imports {
+		// Quoted as the Go import paths are
+		fm.imports[`"`+imp+`"`]++
+	}
+	fm.nrOfImports = len(fm.imports)
+	fm.nrOfStructs = ef.Structs
+	fm.nrOfFunctionDeclarations = len(ef.Functions)
+	if ef.Lines != nil && !fm.disabled[METRIC_LOC] {
+		// The LOC metrics read the Go section of the 'cloc' results, whatever the language is
+		fm.nrOfLines.Header.NFiles = 1
+		fm.nrOfLines.Go.NFiles = 1
+		fm.nrOfLines.Go.Code, fm.nrOfLines.Sum.Code = ef.Lines.Code, ef.Lines.Code
+		fm.nrOfLines.Go.Comment, fm.nrOfLines.Sum.Comment = ef.Lines.Comment, ef.Lines.Comment
+		fm.nrOfLines.Go.Blank, fm.nrOfLines.Sum.Blank = ef.Lines.Blank, ef.Lines.Blank
+		fm.nrOfLines.Sum.NFiles = 1
+	}
+	if !fm.disabled[METRIC_HALSTEAD] {
+		fm.fileHalstead.addCounts(ef.Operators, ef.Operands)
+	}
+	for _, f := range ef.Functions {
+		fm.abcMetrics = append(fm.abcMetrics, ABCMetric{
+			signature:    f.Signature,
+			assingments:  f.Assignments,
+			branches:     f.Branches,
+			conditionals: f.Conditionals,
+		})
+		fm.cycloCMetric = append(fm.cycloCMetric, CyclomaticComplexityMetric{signature: f.Signature, ccm: f.CC})
+		var fnm = FunctionMetric{id: f.ID, startLine: f.StartLine, endLine: f.EndLine}
+		fnm.halstead.Init()
+		if !fm.disabled[METRIC_HALSTEAD] {
+			fnm.halstead.addCounts(f.Operators, f.Operands)
+		}
+		fm.functions = append(fm.functions, fnm)
+	}
+	fm.calcABCSum()
+	return fm
+}
+
+func (hm *HalsteadMetric) addCounts(operators map[string]int, operands map[string]int) {
+	for k, v := range operators {
+		hm.operators[k] += v
+	}
+	for k, v := range operands {
+		hm.operands[k] += v
+	}
+}
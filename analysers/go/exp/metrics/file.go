@@ -3,21 +3,44 @@ package metrics
 import (
 	"fmt"
 	"go/ast"
+	"go/token"
+	"sort"
+	"strconv"
 )
 
+// Metric groups, all but the required ones can be switched off
+const (
+	METRIC_LOC      string = "loc"      // Lines of code, counted by 'cloc'
+	METRIC_CC       string = "cc"       // Cyclomatic Complexity
+	METRIC_ABC      string = "abc"      // ABC code size
+	METRIC_HALSTEAD string = "halstead" // Halstead measures
+)
+
+var MetricGroups = []string{METRIC_LOC, METRIC_CC, METRIC_ABC, METRIC_HALSTEAD}
+
+// The CC and ABC metrics count the functions, they are always measured
+var RequiredMetricGroups = []string{METRIC_CC, METRIC_ABC}
+
 // Simple file based metrics
 type FileMetric struct {
 	fileName      string
+	language      string // Empty for Go
+	generated     bool   // See Generated
 	fileABCMetric ABCMetric
 	abcMetrics    []ABCMetric
 	fileHalstead  HalsteadMetric
 	cycloCMetric  []CyclomaticComplexityMetric
+	functions     []FunctionMetric
 	// Basic file metrics
 	nrOfImports              int
 	imports                  map[string]int
 	nrOfFunctionDeclarations int
 	nrOfLines                FileClocStat
 	nrOfStructs              int
+	// The values of the file plugins, by name
+	values map[string]float64
+	// Metric groups & plugins that are not generated
+	disabled map[string]bool
 }
 
 func NewFileMetric(fileName string) FileMetric {
@@ -27,11 +50,23 @@ func NewFileMetric(fileName string) FileMetric {
 	fm.fileABCMetric = ABCMetric{signature: fileName}
 	fm.fileHalstead.Init()
 	fm.cycloCMetric = make([]CyclomaticComplexityMetric, 0)
+	fm.functions = make([]FunctionMetric, 0)
 	fm.imports = map[string]int{}
 	return fm
 }
 
-func (fm *FileMetric) GenerateMetrics(tree *ast.File) (err error) {
+// Switches off metric groups & plugins (by name). Only the LOC and Halstead metrics are skipped
+// when generating, the CC and ABC metrics are always needed to count the functions.
+func (fm *FileMetric) Disable(groups ...string) {
+	if fm.disabled == nil {
+		fm.disabled = map[string]bool{}
+	}
+	for _, g := range groups {
+		fm.disabled[g] = true
+	}
+}
+
+func (fm *FileMetric) GenerateMetrics(fset *token.FileSet, tree *ast.File) (err error) {
 	// Basic code metrics: imports, functions, structures
 	ast.Inspect(tree, func(n ast.Node) bool {
 		switch t := n.(type) {
@@ -39,24 +74,23 @@ func (fm *FileMetric) GenerateMetrics(tree *ast.File) (err error) {
 			fm.imports[t.Path.Value]++
 		case *ast.FuncDecl:
 			fm.nrOfFunctionDeclarations++
-			// We generate the ABC metric on the function level
-			fm.GenerateABCMetrics(n)
-			// Calculate the Cyclomatic Complexity on the function level
-			fm.GenerateCyclomaticComplexity(n)
+			// Position & size, then the built-in groups (CC, ABC, Halstead) & the plugins
+			fm.GenerateFunctionMetrics(fset, t)
+			fm.collectFunction(fset, t)
 		case *ast.StructType:
 			fm.nrOfStructs++
 		}
 		return true
 	})
 	fm.nrOfImports = len(fm.imports)
-	// Calculate the Halstead metric on the file
-	ast.Inspect(tree, func(n ast.Node) bool {
-		fm.GenerateHalsteadMetrics(n)
-		return true
-	})
+	// The Halstead metric on the file & the file plugins
+	fm.collectFile(fset, tree)
 	// Calculate ABC metrics for the file
 	fm.calcABCSum()
 	fm.CodeSize()
+	if fm.disabled[METRIC_LOC] {
+		return nil
+	}
 	// Use 'cloc' to calculate the lines of code metrics
 	fm.nrOfLines, err = fileCLOC(fm.fileName)
 	if err != nil {
@@ -81,6 +115,57 @@ func (fm *FileMetric) GenerateCyclomaticComplexity(node ast.Node) {
 	fm.cycloCMetric = append(fm.cycloCMetric, ccm)
 }
 
+func (fm *FileMetric) GenerateFunctionMetrics(fset *token.FileSet, f *ast.FuncDecl) {
+	fm.functions = append(fm.functions, NewFunctionMetric(fset, f))
+}
+
+func (fm *FileMetric) FileName() string {
+	return fm.fileName
+}
+
+// Moves the metrics to another path, eg.: the same file in another copy of the tree
+func (fm *FileMetric) Rename(fileName string) {
+	if fm.fileABCMetric.signature == fm.fileName {
+		fm.fileABCMetric.signature = fileName
+	}
+	fm.fileName = fileName
+}
+
+// The language of the file, LANGUAGE_GO unless it was measured by an external analyser
+func (fm *FileMetric) Language() string {
+	if fm.language == "" {
+		return LANGUAGE_GO
+	}
+	return fm.language
+}
+
+// Whether the file is generated code: it has the standard header (see ast.IsGenerated) or
+// matches the generated patterns of the configuration
+func (fm *FileMetric) Generated() bool {
+	return fm.generated
+}
+
+func (fm *FileMetric) SetGenerated(generated bool) {
+	fm.generated = generated
+}
+
+// The nr of code lines, 0 if the LOC metrics are disabled
+func (fm *FileMetric) CodeLOC() int {
+	return fm.nrOfLines.Go.Code
+}
+
+// The import paths of the file, without quotes
+func (fm *FileMetric) Imports() []string {
+	var imports = make([]string, 0, len(fm.imports))
+	for imp := range fm.imports {
+		if path, err := strconv.Unquote(imp); err == nil {
+			imports = append(imports, path)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
 func (fm *FileMetric) CodeSize() (codeSize int) {
 	return fm.fileABCMetric.CodeSize()
 }
@@ -98,3 +183,22 @@ func (fm *FileMetric) String() string {
 	return fmt.Sprintf("File,\"%s\",%d,%d,%d,%d",
 		fm.fileName, fm.nrOfImports, fm.nrOfFunctionDeclarations, fm.nrOfLines.Go.Code, fm.nrOfStructs)
 }
+
+func (fm *FileMetric) CommentDensity() float64 {
+	var lines = fm.nrOfLines.Go.Code + fm.nrOfLines.Go.Comment
+	if lines == 0 {
+		return 0
+	}
+	return float64(fm.nrOfLines.Go.Comment) / float64(lines)
+}
+
+// The highest Cyclomatic Complexity of the functions in the file
+func (fm *FileMetric) MaxCC() float64 {
+	var max int
+	for _, ccm := range fm.cycloCMetric {
+		if ccm.ccm > max {
+			max = ccm.ccm
+		}
+	}
+	return float64(max)
+}
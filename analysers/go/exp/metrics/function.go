@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// Function level metrics, complementing the ABC & CC metrics of the same index in FileMetric
+type FunctionMetric struct {
+	id        string // See GetFuncID
+	startLine int
+	endLine   int
+	halstead  HalsteadMetric
+	values    map[string]float64 // The values of the function plugins, by name
+}
+
+func NewFunctionMetric(fset *token.FileSet, f *ast.FuncDecl) FunctionMetric {
+	var fnm = FunctionMetric{id: GetFuncID(f)}
+	fnm.startLine = fset.Position(f.Pos()).Line
+	fnm.endLine = fset.Position(f.End()).Line
+	fnm.halstead.Init()
+	return fnm
+}
+
+func (fnm *FunctionMetric) ID() string {
+	return fnm.id
+}
+
+func (fnm *FunctionMetric) StartLine() int {
+	return fnm.startLine
+}
+
+func (fnm *FunctionMetric) EndLine() int {
+	return fnm.endLine
+}
+
+// The number of lines the declaration spans, including comments & blank lines in the body
+func (fnm *FunctionMetric) LOC() int {
+	return fnm.endLine - fnm.startLine + 1
+}
+
+func (fnm *FunctionMetric) Halstead() *HalsteadMetric {
+	return &fnm.halstead
+}
+
+// The lines a function declaration spans
+type FunctionRange struct {
+	ID        string // See GetFuncID, made unique within the file as in the snapshots
+	StartLine int
+	EndLine   int
+}
+
+// Lists the function declarations of a file, without measuring them
+func FunctionRanges(fset *token.FileSet, tree *ast.File) []FunctionRange {
+	var ranges []FunctionRange
+	var seen = map[string]int{}
+	for _, decl := range tree.Decls {
+		if f, ok := decl.(*ast.FuncDecl); ok {
+			ranges = append(ranges, FunctionRange{
+				ID:        uniqueID(seen, GetFuncID(f)),
+				StartLine: fset.Position(f.Pos()).Line,
+				EndLine:   fset.Position(f.End()).Line,
+			})
+		}
+	}
+	return ranges
+}
+
+// Functions like 'init' can be declared more than once in a file, the repeated ones get a
+// '#N' suffix
+func uniqueID(seen map[string]int, id string) string {
+	if seen[id]++; seen[id] > 1 {
+		return id + "#" + strconv.Itoa(seen[id])
+	}
+	return id
+}
@@ -0,0 +1,326 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The levels a threshold can be evaluated on
+type Scope string
+
+const (
+	SCOPE_FUNCTION Scope = "function" // Every function declaration
+	SCOPE_FILE     Scope = "file"     // Every source file
+	SCOPE_PACKAGE  Scope = "package"  // Every directory (ie.: Go package) with at least one source file
+	SCOPE_PROJECT  Scope = "project"  // The whole analysed tree
+)
+
+// A single limit on a named metric. At least one of Max or Min has to be set.
+type Threshold struct {
+	Scope  Scope    `json:"scope" yaml:"scope"`
+	Metric string   `json:"metric" yaml:"metric"`
+	Max    *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	Min    *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+}
+
+// The set of thresholds the gate evaluates
+type GateConfig struct {
+	Thresholds []Threshold `json:"thresholds" yaml:"thresholds"`
+}
+
+// A threshold that has been exceeded
+type Violation struct {
+	Scope   Scope
+	Subject string // Function signature, file name, package directory or project name
+	Metric  string
+	Value   float64
+	Limit   float64
+	Op      string // The comparison that failed: "<=" for Max, ">=" for Min
+}
+
+// Per function metric names
+var functionValues = map[string]func(abcm *ABCMetric, ccm *CyclomaticComplexityMetric) float64{
+	"cc":               func(_ *ABCMetric, ccm *CyclomaticComplexityMetric) float64 { return float64(ccm.ccm) },
+	"abc":              func(abcm *ABCMetric, _ *CyclomaticComplexityMetric) float64 { return float64(abcm.CodeSize()) },
+	"abc_assignments":  func(abcm *ABCMetric, _ *CyclomaticComplexityMetric) float64 { return float64(abcm.assingments) },
+	"abc_branches":     func(abcm *ABCMetric, _ *CyclomaticComplexityMetric) float64 { return float64(abcm.branches) },
+	"abc_conditionals": func(abcm *ABCMetric, _ *CyclomaticComplexityMetric) float64 { return float64(abcm.conditionals) },
+}
+
+// Per file metric names
+var fileValues = map[string]func(fm *FileMetric) float64{
+	"code_loc":            func(fm *FileMetric) float64 { return float64(fm.nrOfLines.Go.Code) },
+	"comment_loc":         func(fm *FileMetric) float64 { return float64(fm.nrOfLines.Go.Comment) },
+	"comment_density":     func(fm *FileMetric) float64 { return fm.CommentDensity() },
+	"imports":             func(fm *FileMetric) float64 { return float64(fm.nrOfImports) },
+	"structs":             func(fm *FileMetric) float64 { return float64(fm.nrOfStructs) },
+	"functions":           func(fm *FileMetric) float64 { return float64(fm.nrOfFunctionDeclarations) },
+	"abc":                 func(fm *FileMetric) float64 { return float64(fm.CodeSize()) },
+	"cc_max":              func(fm *FileMetric) float64 { return fm.MaxCC() },
+	"halstead_volume":     func(fm *FileMetric) float64 { return fm.fileHalstead.Volume() },
+	"halstead_difficulty": func(fm *FileMetric) float64 { return fm.fileHalstead.Difficulty() },
+	"halstead_effort":     func(fm *FileMetric) float64 { return fm.fileHalstead.Effort() },
+}
+
+// Per package & project metric names (both are aggregated with SummaryMetrics)
+var summaryValues = map[string]func(sm *SummaryMetrics) float64{
+	"composite_score":            (*SummaryMetrics).CompositeScore,
+	"files":                      func(sm *SummaryMetrics) float64 { return float64(sm.TotalNrOfFiles()) },
+	"code_loc":                   func(sm *SummaryMetrics) float64 { return float64(sm.TotalCodeLOC()) },
+	"comment_loc":                func(sm *SummaryMetrics) float64 { return float64(sm.TotalCommentLOC()) },
+	"imports":                    func(sm *SummaryMetrics) float64 { return float64(sm.NrOfDImports()) },
+	"structs":                    func(sm *SummaryMetrics) float64 { return float64(sm.NrOfStructs()) },
+	"functions":                  func(sm *SummaryMetrics) float64 { return float64(sm.NrOfFunctions()) },
+	"complex_functions":          func(sm *SummaryMetrics) float64 { return float64(sm.NrOfComplexFuncs()) },
+	"functions_per_file_median":  (*SummaryMetrics).FunPerFMedian,
+	"structs_per_file_median":    (*SummaryMetrics).StrucPerFMedian,
+	"loc_per_function_median":    (*SummaryMetrics).LocPerFMedian,
+	"comment_density":            (*SummaryMetrics).CommentDensity,
+	"cc_density_per_kloc":        (*SummaryMetrics).CyclDestinyPerkLOC,
+	"cc_average":                 (*SummaryMetrics).CyclCAverage,
+	"cc_median":                  (*SummaryMetrics).CyclCMedian,
+	"cc_p95":                     (*SummaryMetrics).CyclCP95,
+	"cc_high_rate":               (*SummaryMetrics).CyclCHighRate,
+	"cc_concentration":           (*SummaryMetrics).CyclCConcentration,
+	"halstead_volume_per_kloc":   (*SummaryMetrics).HalVolumePerkLOC,
+	"halstead_effort_per_kloc":   (*SummaryMetrics).HalEffortPerkLOC,
+	"halstead_difficulty_median": (*SummaryMetrics).HalDifMedian,
+	"abc_per_function_median":    (*SummaryMetrics).ABCCodeSizePerFun,
+	"abc_average":                (*SummaryMetrics).ABCBranCondRatio,
+	"abc_high_rate":              (*SummaryMetrics).ABCHighRate,
+}
+
+// The default gate built from the informational levels
+func DefaultGateConfig(levels Levels) GateConfig {
+	return GateConfig{
+		Thresholds: []Threshold{
+			{Scope: SCOPE_FUNCTION, Metric: "cc", Max: limit(float64(levels.CCModerate))},
+			{Scope: SCOPE_FUNCTION, Metric: "abc", Max: limit(levels.ABCHigh * 2)},
+			{Scope: SCOPE_PROJECT, Metric: "cc_p95", Max: limit(float64(levels.CCLow))},
+		},
+	}
+}
+
+// Loads the gate configuration from a YAML file, the shape of 'gate' in the configuration
+// file. JSON is YAML too.
+func LoadGateConfig(fileName string) (gc GateConfig, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	var decoder = yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&gc); err != nil && !errors.Is(err, io.EOF) {
+		return gc, fmt.Errorf("parse gate config %q: %w", fileName, err)
+	}
+	err = gc.Validate()
+	return
+}
+
+// Checks that every threshold refers to a known scope & metric and sets a limit
+func (gc *GateConfig) Validate() error {
+	for i, t := range gc.Thresholds {
+		if t.Max == nil && t.Min == nil {
+			return fmt.Errorf("threshold #%d (%s/%s): neither max nor min is set", i, t.Scope, t.Metric)
+		}
+		var known bool
+		switch t.Scope {
+		case SCOPE_FUNCTION:
+			_, known = functionValues[t.Metric]
+		case SCOPE_FILE:
+			_, known = fileValues[t.Metric]
+		case SCOPE_PACKAGE, SCOPE_PROJECT:
+			_, known = summaryValues[t.Metric]
+		default:
+			return fmt.Errorf("threshold #%d: unknown scope %q", i, t.Scope)
+		}
+		known = known || pluginMetric(t.Scope, t.Metric)
+		if !known {
+			return fmt.Errorf("threshold #%d: unknown %s metric %q", i, t.Scope, t.Metric)
+		}
+	}
+	return nil
+}
+
+// Evaluates all the thresholds and returns the violations in the order of the thresholds.
+// The project-level values are taken from sm, which has to be calculated over fileMetrics.
+func (gc *GateConfig) Evaluate(project string, fileMetrics []FileMetric, sm *SummaryMetrics) (violations []Violation) {
+	var packages map[string]*SummaryMetrics
+	var byDir map[string][]FileMetric
+	for _, t := range gc.Thresholds {
+		switch t.Scope {
+		case SCOPE_FUNCTION, SCOPE_FILE:
+			for i := range fileMetrics {
+				violations = t.checkFile(violations, &fileMetrics[i])
+			}
+		case SCOPE_PACKAGE:
+			if packages == nil {
+				packages = packageSummaries(fileMetrics, sm.Settings())
+				byDir = groupByDir(fileMetrics)
+			}
+			for _, dir := range sortedKeys(packages) {
+				if value, ok := summaryValue(t.Metric, packages[dir], byDir[dir], true); ok {
+					violations = t.check(violations, dir, value)
+				}
+			}
+		case SCOPE_PROJECT:
+			if value, ok := summaryValue(t.Metric, sm, fileMetrics, false); ok {
+				violations = t.check(violations, project, value)
+			}
+		}
+	}
+	return violations
+}
+
+// Evaluates the function & file thresholds on a single file, in the order of the thresholds.
+// With EvaluateSummaries it's Evaluate for files that are not kept (see Aggregator).
+func (gc *GateConfig) EvaluateFile(fm *FileMetric) (violations []Violation) {
+	for _, t := range gc.Thresholds {
+		violations = t.checkFile(violations, fm)
+	}
+	return violations
+}
+
+// Evaluates the package & project thresholds on the values of SummaryValues, the packages by
+// directory
+func (gc *GateConfig) EvaluateSummaries(project string, summary map[string]float64, packages map[string]map[string]float64) (violations []Violation) {
+	for _, t := range gc.Thresholds {
+		switch t.Scope {
+		case SCOPE_PACKAGE:
+			for _, dir := range sortedKeys(packages) {
+				if value, ok := packages[dir][t.Metric]; ok {
+					violations = t.check(violations, dir, value)
+				}
+			}
+		case SCOPE_PROJECT:
+			if value, ok := summary[t.Metric]; ok {
+				violations = t.check(violations, project, value)
+			}
+		}
+	}
+	return violations
+}
+
+// Checks the function or file threshold on fm, other scopes are ignored
+func (t Threshold) checkFile(violations []Violation, fm *FileMetric) []Violation {
+	switch t.Scope {
+	case SCOPE_FUNCTION:
+		for j := 0; j < minInt(len(fm.abcMetrics), len(fm.cycloCMetric)); j++ {
+			if value, ok := fm.functionValue(t.Metric, j); ok {
+				violations = t.check(violations, fm.fileName+":"+fm.abcMetrics[j].signature, value)
+			}
+		}
+	case SCOPE_FILE:
+		if value, ok := fm.fileValue(t.Metric); ok {
+			violations = t.check(violations, fm.fileName, value)
+		}
+	}
+	return violations
+}
+
+// The value of a built-in or plugin function metric of the j-th function, plugins that were
+// disabled have no value
+func (fm *FileMetric) functionValue(name string, j int) (float64, bool) {
+	if value, ok := functionValues[name]; ok {
+		return value(&fm.abcMetrics[j], &fm.cycloCMetric[j]), true
+	}
+	if j >= len(fm.functions) {
+		return 0, false
+	}
+	value, ok := fm.functions[j].values[name]
+	return value, ok
+}
+
+func (fm *FileMetric) fileValue(name string) (float64, bool) {
+	if value, ok := fileValues[name]; ok {
+		return value(fm), true
+	}
+	value, ok := fm.values[name]
+	return value, ok
+}
+
+// The built-in summary of sm, or the plugin summary over files
+func summaryValue(name string, sm *SummaryMetrics, files []FileMetric, pkg bool) (float64, bool) {
+	if value, ok := summaryValues[name]; ok {
+		return value(sm), true
+	}
+	value, ok := pluginValues(files, pkg)[name]
+	return value, ok
+}
+
+// A function threshold exceeded in a single file
+type FunctionViolation struct {
+	Violation
+	Function int // The index of the function, as in the snapshot of the file
+}
+
+// Evaluates the function thresholds only, on the functions of one file
+func (gc *GateConfig) EvaluateFunctions(fm *FileMetric) (violations []FunctionViolation) {
+	for _, t := range gc.Thresholds {
+		if t.Scope != SCOPE_FUNCTION {
+			continue
+		}
+		for j := 0; j < minInt(len(fm.abcMetrics), len(fm.cycloCMetric)); j++ {
+			value, ok := fm.functionValue(t.Metric, j)
+			if !ok {
+				continue
+			}
+			subject := fm.fileName + ":" + fm.abcMetrics[j].signature
+			for _, v := range t.check(nil, subject, value) {
+				violations = append(violations, FunctionViolation{v, j})
+			}
+		}
+	}
+	return violations
+}
+
+func (t Threshold) check(violations []Violation, subject string, value float64) []Violation {
+	if t.Max != nil && value > *t.Max {
+		violations = append(violations, Violation{t.Scope, subject, t.Metric, value, *t.Max, "<="})
+	}
+	if t.Min != nil && value < *t.Min {
+		violations = append(violations, Violation{t.Scope, subject, t.Metric, value, *t.Min, ">="})
+	}
+	return violations
+}
+
+// Groups the files by directory and calculates the summary for each group
+func packageSummaries(fileMetrics []FileMetric, settings Settings) map[string]*SummaryMetrics {
+	var byDir = groupByDir(fileMetrics)
+	var summaries = make(map[string]*SummaryMetrics, len(byDir))
+	for dir, fms := range byDir {
+		var psm = NewSummaryMetrics(settings)
+		psm.CalculateMetrics(fms)
+		summaries[dir] = &psm
+	}
+	return summaries
+}
+
+func groupByDir(fileMetrics []FileMetric) map[string][]FileMetric {
+	var byDir = map[string][]FileMetric{}
+	for _, fm := range fileMetrics {
+		dir := filepath.Dir(fm.fileName)
+		byDir[dir] = append(byDir[dir], fm)
+	}
+	return byDir
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func limit(v float64) *float64 {
+	return &v
+}
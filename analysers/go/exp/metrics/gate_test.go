@@ -0,0 +1,304 @@
+package metrics
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Two packages: a (Simple: cc 0, Branchy: cc 3 | Two: cc 0) and b (B1: cc 1, B2: cc 0)
+var gateSources = []struct{ path, src string }{
+	{"a/one.go", `package a
+
+func Simple() int { return 1 }
+
+func Branchy(x int) int {
+	if x > 0 {
+		x++
+	}
+	if x > 1 {
+		x++
+	}
+	if x > 2 {
+		x++
+	}
+	return x
+}
+`},
+	{"a/two.go", "package a\n\nfunc Two() {}\n"},
+	{"b/three.go", `package b
+
+func B1(x int) int {
+	if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+func B2() {}
+`},
+}
+
+func gateFileMetrics(t *testing.T) ([]FileMetric, *SummaryMetrics) {
+	var fms []FileMetric
+	for _, s := range gateSources {
+		var fset = token.NewFileSet()
+		tree, err := parser.ParseFile(fset, s.path, s.src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fm := NewFileMetric(s.path)
+		fm.Disable(METRIC_LOC)
+		if err := fm.GenerateMetrics(fset, tree); err != nil {
+			t.Fatal(err)
+		}
+		fms = append(fms, fm)
+	}
+	var sm = NewSummaryMetrics(DefaultSettings())
+	sm.CalculateMetrics(fms)
+	return fms, &sm
+}
+
+func TestThresholdCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		max, min *float64
+		value    float64
+		expected []Violation
+	}{
+		{"under max", limit(10), nil, 9, nil},
+		{"at max", limit(10), nil, 10, nil},
+		{"over max", limit(10), nil, 10.5, []Violation{{SCOPE_FILE, "f.go", "m", 10.5, 10, "<="}}},
+		{"over min", nil, limit(0.2), 0.3, nil},
+		{"at min", nil, limit(0.2), 0.2, nil},
+		{"under min", nil, limit(0.2), 0.1, []Violation{{SCOPE_FILE, "f.go", "m", 0.1, 0.2, ">="}}},
+		{"within both", limit(10), limit(5), 7, nil},
+		{"over both", limit(10), limit(5), 11, []Violation{{SCOPE_FILE, "f.go", "m", 11, 10, "<="}}},
+		{"under both", limit(10), limit(5), 4, []Violation{{SCOPE_FILE, "f.go", "m", 4, 5, ">="}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var th = Threshold{Scope: SCOPE_FILE, Metric: "m", Max: tc.max, Min: tc.min}
+			if got := th.check(nil, "f.go", tc.value); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got %+v, expected %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGateEvaluate(t *testing.T) {
+	fms, sm := gateFileMetrics(t)
+	for _, tc := range []struct {
+		name       string
+		thresholds []Threshold
+		expected   []Violation
+	}{
+		{
+			name:       "function max",
+			thresholds: []Threshold{{Scope: SCOPE_FUNCTION, Metric: "cc", Max: limit(2)}},
+			expected:   []Violation{{SCOPE_FUNCTION, "a/one.go:Branchy(x)", "cc", 3, 2, "<="}},
+		},
+		{
+			name:       "function min",
+			thresholds: []Threshold{{Scope: SCOPE_FUNCTION, Metric: "cc", Min: limit(1)}},
+			expected: []Violation{
+				{SCOPE_FUNCTION, "a/one.go:Simple()", "cc", 0, 1, ">="},
+				{SCOPE_FUNCTION, "a/two.go:Two()", "cc", 0, 1, ">="},
+				{SCOPE_FUNCTION, "b/three.go:B2()", "cc", 0, 1, ">="},
+			},
+		},
+		{
+			name:       "file",
+			thresholds: []Threshold{{Scope: SCOPE_FILE, Metric: "functions", Max: limit(1)}},
+			expected: []Violation{
+				{SCOPE_FILE, "a/one.go", "functions", 2, 1, "<="},
+				{SCOPE_FILE, "b/three.go", "functions", 2, 1, "<="},
+			},
+		},
+		{
+			name:       "package",
+			thresholds: []Threshold{{Scope: SCOPE_PACKAGE, Metric: "functions", Max: limit(2)}},
+			expected:   []Violation{{SCOPE_PACKAGE, "a", "functions", 3, 2, "<="}},
+		},
+		{
+			name:       "project",
+			thresholds: []Threshold{{Scope: SCOPE_PROJECT, Metric: "functions", Max: limit(4)}},
+			expected:   []Violation{{SCOPE_PROJECT, "p", "functions", 5, 4, "<="}},
+		},
+		{
+			name:       "project met",
+			thresholds: []Threshold{{Scope: SCOPE_PROJECT, Metric: "functions", Max: limit(5)}},
+		},
+		{
+			name: "in the order of the thresholds",
+			thresholds: []Threshold{
+				{Scope: SCOPE_PROJECT, Metric: "functions", Max: limit(4)},
+				{Scope: SCOPE_FUNCTION, Metric: "cc", Max: limit(2)},
+			},
+			expected: []Violation{
+				{SCOPE_PROJECT, "p", "functions", 5, 4, "<="},
+				{SCOPE_FUNCTION, "a/one.go:Branchy(x)", "cc", 3, 2, "<="},
+			},
+		},
+		{
+			// Rejected by Validate, skipped if evaluated anyway
+			name: "unknown metrics",
+			thresholds: []Threshold{
+				{Scope: SCOPE_FUNCTION, Metric: "nope", Max: limit(0)},
+				{Scope: SCOPE_FILE, Metric: "nope", Max: limit(0)},
+				{Scope: SCOPE_PACKAGE, Metric: "nope", Max: limit(0)},
+				{Scope: SCOPE_PROJECT, Metric: "nope", Max: limit(0)},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gc = GateConfig{Thresholds: tc.thresholds}
+			if got := gc.Evaluate("p", fms, sm); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got %+v, expected %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+// Streaming evaluates the files one by one, then the summaries: the same violations
+func TestGateEvaluateFileAndSummaries(t *testing.T) {
+	fms, sm := gateFileMetrics(t)
+	var gc = GateConfig{Thresholds: []Threshold{
+		{Scope: SCOPE_FUNCTION, Metric: "cc", Max: limit(0)},
+		{Scope: SCOPE_FILE, Metric: "functions", Max: limit(1)},
+		{Scope: SCOPE_PACKAGE, Metric: "functions", Max: limit(2)},
+		{Scope: SCOPE_PROJECT, Metric: "functions", Max: limit(4)},
+	}}
+	var got []Violation
+	for i := range fms {
+		got = append(got, gc.EvaluateFile(&fms[i])...)
+	}
+	var packages = map[string]map[string]float64{}
+	for dir, psm := range packageSummaries(fms, DefaultSettings()) {
+		packages[dir] = SummaryValues(psm, nil)
+	}
+	got = append(got, gc.EvaluateSummaries("p", SummaryValues(sm, nil), packages)...)
+
+	var expected = []Violation{
+		{SCOPE_FUNCTION, "a/one.go:Branchy(x)", "cc", 3, 0, "<="},
+		{SCOPE_FILE, "a/one.go", "functions", 2, 1, "<="},
+		{SCOPE_FUNCTION, "b/three.go:B1(x)", "cc", 1, 0, "<="},
+		{SCOPE_FILE, "b/three.go", "functions", 2, 1, "<="},
+		{SCOPE_PACKAGE, "a", "functions", 3, 2, "<="},
+		{SCOPE_PROJECT, "p", "functions", 5, 4, "<="},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
+
+func TestEvaluateFunctions(t *testing.T) {
+	fms, _ := gateFileMetrics(t)
+	var gc = GateConfig{Thresholds: []Threshold{
+		{Scope: SCOPE_FUNCTION, Metric: "cc", Max: limit(0)},
+		// Not a function threshold
+		{Scope: SCOPE_FILE, Metric: "functions", Max: limit(0)},
+	}}
+	for _, tc := range []struct {
+		file     int
+		expected []FunctionViolation
+	}{
+		{0, []FunctionViolation{{Violation{SCOPE_FUNCTION, "a/one.go:Branchy(x)", "cc", 3, 0, "<="}, 1}}},
+		{1, nil},
+		{2, []FunctionViolation{{Violation{SCOPE_FUNCTION, "b/three.go:B1(x)", "cc", 1, 0, "<="}, 0}}},
+	} {
+		if got := gc.EvaluateFunctions(&fms[tc.file]); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: got %+v, expected %+v", fms[tc.file].FileName(), got, tc.expected)
+		}
+	}
+}
+
+func TestGateValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		threshold Threshold
+		err       string // Part of the error, none if empty
+	}{
+		{"function", Threshold{Scope: SCOPE_FUNCTION, Metric: "abc_branches", Max: limit(5)}, ""},
+		{"file", Threshold{Scope: SCOPE_FILE, Metric: "comment_density", Min: limit(0.1)}, ""},
+		{"package", Threshold{Scope: SCOPE_PACKAGE, Metric: "cc_p95", Max: limit(10)}, ""},
+		{"project", Threshold{Scope: SCOPE_PROJECT, Metric: "composite_score", Max: limit(50)}, ""},
+		{"no limit", Threshold{Scope: SCOPE_FUNCTION, Metric: "cc"}, "neither max nor min"},
+		{"unknown scope", Threshold{Scope: "module", Metric: "cc", Max: limit(5)}, `unknown scope "module"`},
+		{"unknown metric", Threshold{Scope: SCOPE_FUNCTION, Metric: "nope", Max: limit(5)}, `unknown function metric "nope"`},
+		{"metric of another scope", Threshold{Scope: SCOPE_FILE, Metric: "cc_p95", Max: limit(5)}, `unknown file metric "cc_p95"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gc = GateConfig{Thresholds: []Threshold{tc.threshold}}
+			var err = gc.Validate()
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("got error %v, expected one with %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLoadGateConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		content  string
+		expected []Threshold
+		err      string // Part of the error, none if empty
+	}{
+		{
+			name:     "yaml",
+			content:  "thresholds:\n  - scope: function\n    metric: cc\n    max: 10\n",
+			expected: []Threshold{{Scope: SCOPE_FUNCTION, Metric: "cc", Max: limit(10)}},
+		},
+		{
+			name:     "json",
+			content:  `{"thresholds": [{"scope": "file", "metric": "comment_density", "min": 0.1}]}`,
+			expected: []Threshold{{Scope: SCOPE_FILE, Metric: "comment_density", Min: limit(0.1)}},
+		},
+		{
+			name:    "empty",
+			content: "",
+		},
+		{
+			name:    "unknown key",
+			content: "thresholds:\n  - scope: function\n    metric: cc\n    maximum: 10\n",
+			err:     "field maximum not found",
+		},
+		{
+			name:    "unknown top level key",
+			content: "threshold:\n  - scope: function\n",
+			err:     "field threshold not found",
+		},
+		{
+			name:    "unknown metric",
+			content: "thresholds:\n  - scope: project\n    metric: nope\n    max: 1\n",
+			err:     `unknown project metric "nope"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var fileName = filepath.Join(t.TempDir(), "gate.yaml")
+			if err := os.WriteFile(fileName, []byte(tc.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			gc, err := LoadGateConfig(fileName)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, expected one with %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gc.Thresholds, tc.expected) {
+				t.Errorf("got %+v, expected %+v", gc.Thresholds, tc.expected)
+			}
+		})
+	}
+}
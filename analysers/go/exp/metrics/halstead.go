@@ -35,16 +35,26 @@ func (hm *HalsteadMetric) Length() float64 {
 
 func (hm *HalsteadMetric) EstimatedLength() float64 {
 	hm.calculate()
+	if hm.fn1 == 0 || hm.fn2 == 0 {
+		// Nothing was measured (eg.: disabled), log2(0) would turn it into NaN
+		return 0
+	}
 	return hm.fn1*math.Log2(hm.fn1) + hm.fn2*math.Log2(hm.fn2)
 }
 
 func (hm *HalsteadMetric) Volume() float64 {
 	hm.calculate()
+	if hm.Vocabulary() == 0 {
+		return 0
+	}
 	return hm.Length() * math.Log2(hm.Vocabulary())
 }
 
 func (hm *HalsteadMetric) Difficulty() float64 {
 	hm.calculate()
+	if hm.fn2 == 0 {
+		return 0
+	}
 	return hm.fn1 / 2 * hm.fN2 / hm.fn2
 }
 
@@ -56,6 +66,8 @@ func (hm *HalsteadMetric) Effort() float64 {
 func (hm *HalsteadMetric) calculate() {
 	hm.fn1 = float64(len(hm.operators))
 	hm.fn2 = float64(len(hm.operands))
+	// Every getter recalculates, so the totals have to start from scratch
+	hm.fN1, hm.fN2 = 0, 0
 
 	for _, v := range hm.operators {
 		hm.fN1 += float64(v)
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"testing"
+)
+
+func TestHalsteadMeasures(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		operators map[string]int
+		operands  map[string]int
+		// Vocabulary, length, estimated length, volume, difficulty, effort
+		expected [6]float64
+	}{
+		{
+			// n1 = 3, n2 = 2, N1 = 4, N2 = 4
+			name:      "counts",
+			operators: map[string]int{"func": 1, "f": 1, "+": 2},
+			operands:  map[string]int{"a": 3, "b": 1},
+			expected:  [6]float64{5, 8, 3*math.Log2(3) + 2, 8 * math.Log2(5), 3, 3 * 8 * math.Log2(5)},
+		},
+		{
+			name:      "no operands",
+			operators: map[string]int{"func": 1, "f": 1},
+			operands:  map[string]int{},
+			expected:  [6]float64{2, 2, 0, 2, 0, 0},
+		},
+		{
+			name:      "nothing measured",
+			operators: map[string]int{},
+			operands:  map[string]int{},
+			expected:  [6]float64{0, 0, 0, 0, 0, 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var hm = HalsteadMetric{operators: tc.operators, operands: tc.operands}
+			// Every getter recalculates, asking twice must not change the results
+			for range 2 {
+				var got = [6]float64{hm.Vocabulary(), hm.Length(), hm.EstimatedLength(), hm.Volume(), hm.Difficulty(), hm.Effort()}
+				for i := range got {
+					if math.Abs(got[i]-tc.expected[i]) > 1e-9 {
+						t.Fatalf("got %v, expected %v", got, tc.expected)
+					}
+				}
+			}
+		})
+	}
+}
+
+// The measures of a parsed function, pinned
+func TestHalsteadFunction(t *testing.T) {
+	const src = `package p
+
+func add(a, b int) int {
+	return a + b
+}
+`
+	var fset = token.NewFileSet()
+	tree, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hm HalsteadMetric
+	hm.Init()
+	ast.Walk(&hm, tree.Decls[0])
+	var difficulty, volume = hm.Difficulty(), hm.Volume()
+	// Asked again, eg.: by Effort
+	if hm.Difficulty() != difficulty || hm.Volume() != volume {
+		t.Fatalf("the measures changed on the second call: %v, %v then %v, %v", difficulty, volume, hm.Difficulty(), hm.Volume())
+	}
+	// Operators: func, add, (), {}, return, +; operands: a, b (the parameters & the types are
+	// not walked)
+	var expected = struct{ n1, n2, N1, N2 float64 }{6, 2, 6, 2}
+	if hm.fn1 != expected.n1 || hm.fn2 != expected.n2 || hm.fN1 != expected.N1 || hm.fN2 != expected.N2 {
+		t.Errorf("got n1 %v, n2 %v, N1 %v, N2 %v, expected %+v", hm.fn1, hm.fn2, hm.fN1, hm.fN2, expected)
+	}
+	if want := expected.n1 / 2 * expected.N2 / expected.n2; difficulty != want {
+		t.Errorf("difficulty %v, expected %v", difficulty, want)
+	}
+	if want := (expected.N1 + expected.N2) * math.Log2(expected.n1+expected.n2); math.Abs(volume-want) > 1e-9 {
+		t.Errorf("volume %v, expected %v", volume, want)
+	}
+	if effort := hm.Effort(); math.Abs(effort-difficulty*volume) > 1e-9 {
+		t.Errorf("effort %v, expected %v", effort, difficulty*volume)
+	}
+}
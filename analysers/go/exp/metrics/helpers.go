@@ -23,3 +23,26 @@ func GetFuncSignature(f *ast.FuncDecl) string {
 	sb.WriteString(")")
 	return sb.String()
 }
+
+// Gets the identity of a function that is stable across runs: 'Name' for functions
+// and 'Receiver.Name' for methods (pointer receivers and type parameters are dropped)
+func GetFuncID(f *ast.FuncDecl) string {
+	if f.Recv == nil || len(f.Recv.List) == 0 {
+		return f.Name.Name
+	}
+	return receiverName(f.Recv.List[0].Type) + "." + f.Name.Name
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return "?"
+}
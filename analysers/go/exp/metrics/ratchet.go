@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// The project aggregates the ratchet checks by default
+var DEFAULT_RATCHET_METRICS = []string{
+	"cc_average", "cc_p95", "abc_average", "halstead_effort_per_kloc", "comment_density",
+}
+
+// What the ratchet compares to the baseline
+type RatchetConfig struct {
+	Metrics   []string `json:"metrics" yaml:"metrics"`     // Project aggregates that must not regress
+	Tolerance float64  `json:"tolerance" yaml:"tolerance"` // Relative change of an aggregate that is still accepted
+}
+
+func DefaultRatchetConfig() RatchetConfig {
+	return RatchetConfig{Metrics: slices.Clone(DEFAULT_RATCHET_METRICS)}
+}
+
+func (rc *RatchetConfig) Validate() error {
+	if rc.Tolerance < 0 {
+		return fmt.Errorf("tolerance has to be >= 0, got %f", rc.Tolerance)
+	}
+	for _, m := range rc.Metrics {
+		if _, known := summaryValues[m]; !known {
+			return fmt.Errorf("unknown project metric %q", m)
+		}
+		if neutralMetrics[m] {
+			return fmt.Errorf("%q is a size metric, it can't regress", m)
+		}
+	}
+	return nil
+}
+
+// Kinds of regression
+const (
+	REGRESSION_FUNCTION     string = "function"     // An existing function got worse
+	REGRESSION_NEW_FUNCTION string = "new function" // A new function exceeds a function threshold
+	REGRESSION_PROJECT      string = "project"      // A project aggregate got worse
+)
+
+type Regression struct {
+	Kind    string
+	Subject string
+	Metric  string
+	Before  float64 // The baseline value, or the limit for a new function
+	After   float64
+}
+
+// The outcome of comparing the current snapshot to the baseline
+type RatchetResult struct {
+	Regressions []Regression
+	Improved    bool // Something got better (or was removed) and nothing regressed
+}
+
+// Per function metric names of the snapshot, a subset of the function gate metrics
+var functionSnapshotValues = map[string]func(f *FunctionSnapshot) float64{
+	"cc":               func(f *FunctionSnapshot) float64 { return float64(f.CC) },
+	"abc":              func(f *FunctionSnapshot) float64 { return float64(f.ABC) },
+	"abc_assignments":  func(f *FunctionSnapshot) float64 { return float64(f.Assignments) },
+	"abc_branches":     func(f *FunctionSnapshot) float64 { return float64(f.Branches) },
+	"abc_conditionals": func(f *FunctionSnapshot) float64 { return float64(f.Conditionals) },
+}
+
+// The built-in value of the function, or the one of a function plugin
+func (f *FunctionSnapshot) value(metric string) (float64, bool) {
+	if value, ok := functionSnapshotValues[metric]; ok {
+		return value(f), true
+	}
+	value, ok := f.Metrics[metric]
+	return value, ok
+}
+
+// Compares the current snapshot to the baseline: existing functions must not get more
+// complex (CC, ABC), new functions must pass the function thresholds of the gate, and the
+// configured project aggregates must not regress.
+func (rc *RatchetConfig) Check(baseline, current *Snapshot, gc GateConfig) (result RatchetResult) {
+	var diff = Compare(baseline, current)
+	for _, fd := range diff.Functions {
+		subject := fd.Path + ":" + fd.ID
+		switch fd.Status {
+		case STATUS_ADDED:
+			for _, t := range gc.Thresholds {
+				value, ok := fd.After.value(t.Metric)
+				if t.Scope != SCOPE_FUNCTION || !ok {
+					continue
+				}
+				for _, v := range t.check(nil, subject, value) {
+					result.Regressions = append(result.Regressions,
+						Regression{REGRESSION_NEW_FUNCTION, subject, v.Metric, v.Limit, v.Value})
+				}
+			}
+		case STATUS_REMOVED:
+			result.Improved = true
+		case STATUS_CHANGED:
+			if fd.CC > 0 {
+				result.Regressions = append(result.Regressions,
+					Regression{REGRESSION_FUNCTION, subject, "cc", float64(fd.Before.CC), float64(fd.After.CC)})
+			}
+			if fd.ABC > 0 {
+				result.Regressions = append(result.Regressions,
+					Regression{REGRESSION_FUNCTION, subject, "abc", float64(fd.Before.ABC), float64(fd.After.ABC)})
+			}
+			if fd.CC < 0 || fd.ABC < 0 {
+				result.Improved = true
+			}
+		}
+	}
+	for _, md := range diff.Summary {
+		if !slices.Contains(rc.Metrics, md.Metric) || !rc.significant(md) {
+			continue
+		}
+		if md.Worse {
+			result.Regressions = append(result.Regressions,
+				Regression{REGRESSION_PROJECT, current.Project, md.Metric, md.Before, md.After})
+		} else {
+			result.Improved = true
+		}
+	}
+	if len(result.Regressions) > 0 {
+		result.Improved = false
+	}
+	return result
+}
+
+// Floating point noise (eg.: files summed up in a different order) and changes within the
+// tolerance are ignored
+func (rc *RatchetConfig) significant(md MetricDelta) bool {
+	var scale = math.Abs(md.Before)
+	if scale == 0 {
+		scale = 1
+	}
+	return math.Abs(md.Delta) > scale*math.Max(rc.Tolerance, 1e-9)
+}
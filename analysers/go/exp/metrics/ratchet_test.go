@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRatchetFunctions(t *testing.T) {
+	var gc = GateConfig{Thresholds: []Threshold{
+		{Scope: SCOPE_FUNCTION, Metric: "cc", Max: limit(5)},
+		{Scope: SCOPE_FUNCTION, Metric: "nesting", Max: limit(3)},
+		// Not a function threshold, ignored for the new functions
+		{Scope: SCOPE_FILE, Metric: "cc_max", Max: limit(0)},
+	}}
+	var f = func(id string, cc int, abc int, plugins map[string]float64) FunctionSnapshot {
+		return FunctionSnapshot{ID: id, CC: cc, ABC: abc, Metrics: plugins}
+	}
+	for _, tc := range []struct {
+		name          string
+		before, after []FunctionSnapshot
+		expected      []Regression
+		improved      bool
+	}{
+		{
+			name:   "unchanged",
+			before: []FunctionSnapshot{f("F", 3, 4, nil)},
+			after:  []FunctionSnapshot{f("F", 3, 4, nil)},
+		},
+		{
+			name:   "changed, worse",
+			before: []FunctionSnapshot{f("F", 3, 4, nil)},
+			after:  []FunctionSnapshot{f("F", 4, 6, nil)},
+			expected: []Regression{
+				{REGRESSION_FUNCTION, "a.go:F", "cc", 3, 4},
+				{REGRESSION_FUNCTION, "a.go:F", "abc", 4, 6},
+			},
+		},
+		{
+			name:     "changed, better",
+			before:   []FunctionSnapshot{f("F", 3, 4, nil)},
+			after:    []FunctionSnapshot{f("F", 2, 4, nil)},
+			improved: true,
+		},
+		{
+			// Within the gate, but still worse than before
+			name:     "changed, worse under the threshold",
+			before:   []FunctionSnapshot{f("F", 1, 1, nil)},
+			after:    []FunctionSnapshot{f("F", 2, 1, nil)},
+			expected: []Regression{{REGRESSION_FUNCTION, "a.go:F", "cc", 1, 2}},
+		},
+		{
+			name:     "changed, better & worse",
+			before:   []FunctionSnapshot{f("F", 3, 4, nil)},
+			after:    []FunctionSnapshot{f("F", 2, 5, nil)},
+			expected: []Regression{{REGRESSION_FUNCTION, "a.go:F", "abc", 4, 5}},
+		},
+		{
+			name:  "new, under the thresholds",
+			after: []FunctionSnapshot{f("G", 5, 50, map[string]float64{"nesting": 3})},
+		},
+		{
+			name:  "new, over the thresholds",
+			after: []FunctionSnapshot{f("G", 6, 1, map[string]float64{"nesting": 4})},
+			expected: []Regression{
+				{REGRESSION_NEW_FUNCTION, "a.go:G", "cc", 5, 6},
+				{REGRESSION_NEW_FUNCTION, "a.go:G", "nesting", 3, 4},
+			},
+		},
+		{
+			// The plugin didn't measure the function
+			name:  "new, without the plugin metric",
+			after: []FunctionSnapshot{f("G", 1, 1, nil)},
+		},
+		{
+			name:     "removed",
+			before:   []FunctionSnapshot{f("F", 9, 9, nil)},
+			improved: true,
+		},
+		{
+			name:     "removed & a new one over the threshold",
+			before:   []FunctionSnapshot{f("F", 9, 9, nil)},
+			after:    []FunctionSnapshot{f("G", 9, 9, nil)},
+			expected: []Regression{{REGRESSION_NEW_FUNCTION, "a.go:G", "cc", 5, 9}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var baseline = Snapshot{Files: []FileSnapshot{{Path: "a.go", Functions: tc.before}}}
+			var current = Snapshot{Files: []FileSnapshot{{Path: "a.go", Functions: tc.after}}}
+			var rc = RatchetConfig{}
+			var result = rc.Check(&baseline, &current, gc)
+			if !reflect.DeepEqual(result.Regressions, tc.expected) {
+				t.Errorf("got %+v, expected %+v", result.Regressions, tc.expected)
+			}
+			if result.Improved != tc.improved {
+				t.Errorf("improved: %v, expected %v", result.Improved, tc.improved)
+			}
+		})
+	}
+}
+
+func TestRatchetProject(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		metric    string
+		before    float64
+		after     float64
+		tolerance float64
+		regressed bool
+		improved  bool
+	}{
+		{"same", "cc_average", 2, 2, 0, false, false},
+		{"floating point noise", "cc_average", 2, 2 + 1e-12, 0, false, false},
+		{"worse", "cc_average", 2, 2.1, 0, true, false},
+		{"better", "cc_average", 2, 1.9, 0, false, true},
+		// The tolerance is relative to the baseline: 4 * 0.25 = 1
+		{"worse within the tolerance", "cc_average", 4, 4.5, 0.25, false, false},
+		{"worse at the tolerance", "cc_average", 4, 5, 0.25, false, false},
+		{"worse over the tolerance", "cc_average", 4, 5.25, 0.25, true, false},
+		{"better at the tolerance", "cc_average", 4, 3, 0.25, false, false},
+		{"better over the tolerance", "cc_average", 4, 2.75, 0.25, false, true},
+		{"from 0", "cc_average", 0, 0.5, 0.1, true, false},
+		{"lower is worse", "comment_density", 0.3, 0.2, 0, true, false},
+		{"higher is better", "comment_density", 0.2, 0.3, 0, false, true},
+		{"not ratcheted", "abc_p95", 2, 4, 0, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var baseline = Snapshot{Project: "p", Summary: map[string]float64{tc.metric: tc.before}}
+			var current = Snapshot{Project: "p", Summary: map[string]float64{tc.metric: tc.after}}
+			var rc = DefaultRatchetConfig()
+			rc.Tolerance = tc.tolerance
+			var result = rc.Check(&baseline, &current, GateConfig{})
+			var expected []Regression
+			if tc.regressed {
+				expected = []Regression{{REGRESSION_PROJECT, "p", tc.metric, tc.before, tc.after}}
+			}
+			if !reflect.DeepEqual(result.Regressions, expected) {
+				t.Errorf("got %+v, expected %+v", result.Regressions, expected)
+			}
+			if result.Improved != tc.improved {
+				t.Errorf("improved: %v, expected %v", result.Improved, tc.improved)
+			}
+		})
+	}
+}
+
+func TestRatchetValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rc    RatchetConfig
+		valid bool
+	}{
+		{"defaults", DefaultRatchetConfig(), true},
+		{"negative tolerance", RatchetConfig{Tolerance: -0.1}, false},
+		{"unknown metric", RatchetConfig{Metrics: []string{"nope"}}, false},
+		{"size metric", RatchetConfig{Metrics: []string{"code_loc"}}, false},
+	} {
+		if err := tc.rc.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+}
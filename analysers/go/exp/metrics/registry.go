@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"slices"
+	"strings"
+)
+
+// How the values of a metric are summarised on the package & project levels
+type Aggregation string
+
+const (
+	AGGREGATION_SUM    Aggregation = "sum"
+	AGGREGATION_MAX    Aggregation = "max"
+	AGGREGATION_MEAN   Aggregation = "mean"
+	AGGREGATION_MEDIAN Aggregation = "median"
+	AGGREGATION_P95    Aggregation = "p95"
+)
+
+var Aggregations = []Aggregation{AGGREGATION_SUM, AGGREGATION_MAX, AGGREGATION_MEAN, AGGREGATION_MEDIAN, AGGREGATION_P95}
+
+// A pluggable metric, measured by implementing at least one of FunctionCollector,
+// FileCollector or PackageCollector. The name is used in the gate thresholds, the snapshots &
+// the reports; the package & project summaries of the function & file values are named
+// <name>_<aggregation> (eg.: params_p95).
+type Metric interface {
+	Name() string
+	Unit() string // Empty for counts
+	Description() string
+	Aggregations() []Aggregation
+}
+
+// Measures every function declaration, the values are function metrics
+type FunctionCollector interface {
+	Metric
+	CollectFunction(fset *token.FileSet, f *ast.FuncDecl) float64
+}
+
+// Measures every file, the values are file metrics
+type FileCollector interface {
+	Metric
+	CollectFile(fset *token.FileSet, tree *ast.File) float64
+}
+
+// Measures every package (directory) from the measured files, the values are package metrics
+// named as the metric
+type PackageCollector interface {
+	Metric
+	CollectPackage(files []FileMetric) float64
+}
+
+var (
+	registry = map[string]Metric{}
+	// The registered names in order, for deterministic collection
+	registered []string
+)
+
+// Adds a metric to every analysis, to be called from the init function of the package
+// implementing it. Panics if the name is taken or invalid, as it's a programming error.
+func Register(m Metric) {
+	var name = m.Name()
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	_, isFunction := functionValues[name]
+	_, isFile := fileValues[name]
+	_, isSummary := summaryValues[name]
+	var isGroup = slices.Contains(MetricGroups, name)
+	if _, ok := registry[name]; ok || (!isBuiltin(m) && (isFunction || isFile || isSummary || isGroup)) {
+		panic(fmt.Sprintf("metrics: metric %q is already registered", name))
+	}
+	_, isFunctionCollector := m.(FunctionCollector)
+	_, isFileCollector := m.(FileCollector)
+	_, isPackageCollector := m.(PackageCollector)
+	if !isBuiltin(m) && !isFunctionCollector && !isFileCollector && !isPackageCollector {
+		panic(fmt.Sprintf("metrics: metric %q doesn't collect anything", name))
+	}
+	for _, a := range m.Aggregations() {
+		if !slices.Contains(Aggregations, a) {
+			panic(fmt.Sprintf("metrics: metric %q: unknown aggregation %q", name, a))
+		}
+	}
+	registry[name] = m
+	registered = append(registered, name)
+	slices.Sort(registered)
+}
+
+// The registered metrics, built-in ones included, sorted by name
+func Registered() []Metric {
+	var ms = make([]Metric, 0, len(registered))
+	for _, name := range registered {
+		ms = append(ms, registry[name])
+	}
+	return ms
+}
+
+func Lookup(name string) (Metric, bool) {
+	m, ok := registry[name]
+	return m, ok
+}
+
+// The registered metrics that are not built in, their values are kept by name
+func plugins() []Metric {
+	var ms []Metric
+	for _, name := range registered {
+		if m := registry[name]; !isBuiltin(m) {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// The built-in metric groups, in the order of registration
+func builtins() []*builtinMetric {
+	var ms []*builtinMetric
+	for _, name := range registered {
+		if m, ok := registry[name].(*builtinMetric); ok {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// The names of the plugins, part of the cache keys as they change the measurements
+func PluginNames() []string {
+	var names []string
+	for _, m := range plugins() {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
+// Measures the function with the built-in groups & the function plugins, into the last
+// function of the file
+func (fm *FileMetric) collectFunction(fset *token.FileSet, f *ast.FuncDecl) {
+	for _, m := range builtins() {
+		m.function(fm, f)
+	}
+	var fnm = &fm.functions[len(fm.functions)-1]
+	for _, m := range plugins() {
+		if c, ok := m.(FunctionCollector); ok && !fm.disabled[m.Name()] {
+			if fnm.values == nil {
+				fnm.values = map[string]float64{}
+			}
+			fnm.values[m.Name()] = c.CollectFunction(fset, f)
+		}
+	}
+}
+
+func (fm *FileMetric) collectFile(fset *token.FileSet, tree *ast.File) {
+	for _, m := range builtins() {
+		if m.file != nil {
+			m.file(fm, tree)
+		}
+	}
+	for _, m := range plugins() {
+		if c, ok := m.(FileCollector); ok && !fm.disabled[m.Name()] {
+			if fm.values == nil {
+				fm.values = map[string]float64{}
+			}
+			fm.values[m.Name()] = c.CollectFile(fset, tree)
+		}
+	}
+}
+
+// The summaries of the plugins over the files, the package collectors are only run if pkg is
+// set. Metrics without values (eg.: no functions) are left out.
+func pluginValues(files []FileMetric, pkg bool) map[string]float64 {
+	var values = map[string]float64{}
+	for _, m := range plugins() {
+		var name = m.Name()
+		var collected []float64
+		for i := range files {
+			if fv, ok := files[i].values[name]; ok {
+				collected = append(collected, fv)
+			}
+			for j := range files[i].functions {
+				if fv, ok := files[i].functions[j].values[name]; ok {
+					collected = append(collected, fv)
+				}
+			}
+		}
+		if len(collected) > 0 {
+			for _, a := range m.Aggregations() {
+				setFinite(values, name+"_"+string(a), aggregate(a, collected))
+			}
+		}
+		if c, ok := m.(PackageCollector); ok && pkg {
+			setFinite(values, name, c.CollectPackage(files))
+		}
+	}
+	return values
+}
+
+// The project summaries of the plugins, named <name>_<aggregation>
+func PluginValues(fileMetrics []FileMetric) map[string]float64 {
+	return pluginValues(fileMetrics, false)
+}
+
+func aggregate(a Aggregation, values []float64) float64 {
+	switch a {
+	case AGGREGATION_SUM:
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	case AGGREGATION_MAX:
+		return maxFloat(values)
+	case AGGREGATION_MEAN:
+		return meanFloat64(values)
+	case AGGREGATION_MEDIAN:
+		return medianFloat64(values)
+	case AGGREGATION_P95:
+		return percentileFloat64(values, 95)
+	}
+	return 0
+}
+
+// Whether a threshold of the scope can refer to the plugin metric name
+func pluginMetric(scope Scope, name string) bool {
+	if m, ok := registry[name]; ok && !isBuiltin(m) {
+		switch scope {
+		case SCOPE_FUNCTION:
+			_, ok = m.(FunctionCollector)
+		case SCOPE_FILE:
+			_, ok = m.(FileCollector)
+		case SCOPE_PACKAGE:
+			_, ok = m.(PackageCollector)
+		default:
+			ok = false
+		}
+		if ok {
+			return true
+		}
+	}
+	if scope != SCOPE_PACKAGE && scope != SCOPE_PROJECT {
+		return false
+	}
+	for _, m := range plugins() {
+		for _, a := range m.Aggregations() {
+			if name == m.Name()+"_"+string(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
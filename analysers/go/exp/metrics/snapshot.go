@@ -0,0 +1,338 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The version of the snapshot file format
+const SNAPSHOT_VERSION int = 1
+
+// The full results of a run, serialisable so that later runs can be compared to it
+type Snapshot struct {
+	Version   int                `json:"version"`
+	Project   string             `json:"project"`
+	CreatedAt time.Time          `json:"created_at"`
+	Summary   map[string]float64 `json:"summary"` // Named as the package & project gate metrics
+	Files     []FileSnapshot     `json:"files"`
+}
+
+type FileSnapshot struct {
+	Path      string             `json:"path"`                // Relative to the analysed directory, slash separated
+	Language  string             `json:"language,omitempty"`  // Empty for Go
+	Generated bool               `json:"generated,omitempty"` // Only listed if the generated files are included
+	Metrics   map[string]float64 `json:"metrics"`             // Named as the file gate metrics
+	Functions []FunctionSnapshot `json:"functions"`
+}
+
+type FunctionSnapshot struct {
+	ID                 string  `json:"id"` // Unique within the file, see GetFuncID
+	Signature          string  `json:"signature"`
+	StartLine          int     `json:"start_line"`
+	EndLine            int     `json:"end_line"`
+	LOC                int     `json:"loc"`
+	CC                 int     `json:"cc"`
+	ABC                int     `json:"abc"`
+	Assignments        int     `json:"abc_assignments"`
+	Branches           int     `json:"abc_branches"`
+	Conditionals       int     `json:"abc_conditionals"`
+	HalsteadDifficulty float64 `json:"halstead_difficulty"`
+	HalsteadEffort     float64 `json:"halstead_effort"`
+	// The values of the function plugins, by name
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// Builds the snapshot of a run, the file paths are made relative to root
+func NewSnapshot(project string, root string, fileMetrics []FileMetric, sm *SummaryMetrics) Snapshot {
+	var snapshot = Snapshot{
+		Version:   SNAPSHOT_VERSION,
+		Project:   project,
+		CreatedAt: time.Now().UTC(),
+		Summary:   map[string]float64{},
+		Files:     make([]FileSnapshot, 0, len(fileMetrics)),
+	}
+	maps.Copy(snapshot.Summary, SummaryValues(sm, PluginValues(fileMetrics)))
+	for i := range fileMetrics {
+		snapshot.Files = append(snapshot.Files, fileMetrics[i].Snapshot(root))
+	}
+	sort.Slice(snapshot.Files, func(i, j int) bool { return snapshot.Files[i].Path < snapshot.Files[j].Path })
+	return snapshot
+}
+
+// The summary values (named as the package gate metrics) of every directory, relative to root
+// and slash separated
+func PackageValues(root string, fileMetrics []FileMetric, settings Settings) map[string]map[string]float64 {
+	var packages = map[string]map[string]float64{}
+	var byDir = groupByDir(fileMetrics)
+	for dir, psm := range packageSummaries(fileMetrics, settings) {
+		var values = SummaryValues(psm, pluginValues(byDir[dir], true))
+		if rel, err := filepath.Rel(root, dir); err == nil {
+			dir = rel
+		}
+		packages[filepath.ToSlash(dir)] = values
+	}
+	return packages
+}
+
+// The metrics of the file & its functions, the path is made relative to root
+func (fm *FileMetric) Snapshot(root string) FileSnapshot {
+	var path = fm.fileName
+	if rel, err := filepath.Rel(root, fm.fileName); err == nil {
+		path = rel
+	}
+	var fs = FileSnapshot{
+		Path:      filepath.ToSlash(path),
+		Language:  fm.language,
+		Generated: fm.generated,
+		Metrics:   map[string]float64{},
+		Functions: make([]FunctionSnapshot, 0, len(fm.functions)),
+	}
+	for name, value := range fileValues {
+		setFinite(fs.Metrics, name, value(fm))
+	}
+	for name, value := range fm.values {
+		setFinite(fs.Metrics, name, value)
+	}
+	var seen = map[string]int{}
+	for i := 0; i < minInt(len(fm.functions), minInt(len(fm.abcMetrics), len(fm.cycloCMetric))); i++ {
+		fnm := &fm.functions[i]
+		fs.Functions = append(fs.Functions, FunctionSnapshot{
+			ID:                 uniqueID(seen, fnm.id),
+			Signature:          fm.abcMetrics[i].signature,
+			StartLine:          fnm.startLine,
+			EndLine:            fnm.endLine,
+			LOC:                fnm.LOC(),
+			CC:                 fm.cycloCMetric[i].ccm,
+			ABC:                fm.abcMetrics[i].CodeSize(),
+			Assignments:        fm.abcMetrics[i].assingments,
+			Branches:           fm.abcMetrics[i].branches,
+			Conditionals:       fm.abcMetrics[i].conditionals,
+			HalsteadDifficulty: finite(fnm.halstead.Difficulty()),
+			HalsteadEffort:     finite(fnm.halstead.Effort()),
+			Metrics:            finiteValues(fnm.values),
+		})
+	}
+	return fs
+}
+
+func (s *Snapshot) Save(fileName string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0o644)
+}
+
+func LoadSnapshot(fileName string) (s Snapshot, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("parse snapshot %q: %w", fileName, err)
+	}
+	if s.Version != SNAPSHOT_VERSION {
+		return s, fmt.Errorf("snapshot %q: unsupported version %d", fileName, s.Version)
+	}
+	return s, nil
+}
+
+// Change status of a file or function between two snapshots
+type Status string
+
+const (
+	STATUS_ADDED     Status = "added"
+	STATUS_REMOVED   Status = "removed"
+	STATUS_CHANGED   Status = "changed"
+	STATUS_UNCHANGED Status = "unchanged"
+)
+
+type FunctionDelta struct {
+	Path           string
+	ID             string
+	Status         Status
+	Before         *FunctionSnapshot // nil if added
+	After          *FunctionSnapshot // nil if removed
+	CC             int
+	ABC            int
+	HalsteadEffort float64
+	LOC            int
+}
+
+// A file that got worse: every metric that moved in the wrong direction
+type FileDelta struct {
+	Path     string
+	Status   Status
+	Worsened []MetricDelta
+}
+
+type MetricDelta struct {
+	Metric string
+	Before float64
+	After  float64
+	Delta  float64
+	Worse  bool
+}
+
+// The difference between a baseline and the current snapshot
+type Diff struct {
+	Baseline  time.Time
+	Functions []FunctionDelta // Added, removed & changed functions only
+	Files     []FileDelta     // Added & removed files, and the ones that worsened
+	Summary   []MetricDelta
+}
+
+// The metrics where a lower value is worse; for all other file & summary metrics a higher value is
+var worseWhenLower = map[string]bool{
+	"comment_density": true,
+	"comment_loc":     true,
+}
+
+// Size metrics are neither better nor worse when they change
+var neutralMetrics = map[string]bool{
+	"files": true, "code_loc": true, "imports": true, "structs": true, "functions": true, "complex_functions": true,
+}
+
+// The file metrics that are considered when deciding if a file worsened (size alone is not)
+var fileQualityMetrics = []string{
+	"cc_max", "abc", "halstead_volume", "halstead_difficulty", "halstead_effort", "comment_density",
+}
+
+// Compares the current snapshot to the baseline
+func Compare(baseline, current *Snapshot) Diff {
+	var diff = Diff{Baseline: baseline.CreatedAt}
+	var before = map[string]*FileSnapshot{}
+	for i := range baseline.Files {
+		before[baseline.Files[i].Path] = &baseline.Files[i]
+	}
+	var after = map[string]*FileSnapshot{}
+	for i := range current.Files {
+		after[current.Files[i].Path] = &current.Files[i]
+	}
+
+	for _, path := range sortedKeys(mergeKeys(before, after)) {
+		b, a := before[path], after[path]
+		diff.Functions = append(diff.Functions, compareFunctions(path, b, a)...)
+		switch {
+		case b == nil:
+			diff.Files = append(diff.Files, FileDelta{Path: path, Status: STATUS_ADDED})
+		case a == nil:
+			diff.Files = append(diff.Files, FileDelta{Path: path, Status: STATUS_REMOVED})
+		default:
+			var worsened []MetricDelta
+			for _, m := range fileQualityMetrics {
+				if md := metricDelta(m, b.Metrics, a.Metrics); md.Worse {
+					worsened = append(worsened, md)
+				}
+			}
+			if len(worsened) > 0 {
+				diff.Files = append(diff.Files, FileDelta{Path: path, Status: STATUS_CHANGED, Worsened: worsened})
+			}
+		}
+	}
+
+	for _, m := range sortedKeys(mergeKeys(baseline.Summary, current.Summary)) {
+		diff.Summary = append(diff.Summary, metricDelta(m, baseline.Summary, current.Summary))
+	}
+	return diff
+}
+
+func compareFunctions(path string, before, after *FileSnapshot) (deltas []FunctionDelta) {
+	var b = map[string]*FunctionSnapshot{}
+	if before != nil {
+		for i := range before.Functions {
+			b[before.Functions[i].ID] = &before.Functions[i]
+		}
+	}
+	var a = map[string]*FunctionSnapshot{}
+	if after != nil {
+		for i := range after.Functions {
+			a[after.Functions[i].ID] = &after.Functions[i]
+		}
+	}
+	for _, id := range sortedKeys(mergeKeys(b, a)) {
+		if d := NewFunctionDelta(path, id, b[id], a[id]); d.Status != STATUS_UNCHANGED {
+			deltas = append(deltas, d)
+		}
+	}
+	return deltas
+}
+
+// Compares the two versions of a function, either of them can be nil
+func NewFunctionDelta(path string, id string, before, after *FunctionSnapshot) FunctionDelta {
+	var d = FunctionDelta{Path: path, ID: id, Before: before, After: after}
+	var zero FunctionSnapshot
+	switch {
+	case before == nil:
+		d.Status, before = STATUS_ADDED, &zero
+	case after == nil:
+		d.Status, after = STATUS_REMOVED, &zero
+	}
+	d.CC = after.CC - before.CC
+	d.ABC = after.ABC - before.ABC
+	d.HalsteadEffort = after.HalsteadEffort - before.HalsteadEffort
+	d.LOC = after.LOC - before.LOC
+	if d.Status == "" {
+		d.Status = STATUS_CHANGED
+		if d.CC == 0 && d.ABC == 0 && d.HalsteadEffort == 0 && d.LOC == 0 {
+			d.Status = STATUS_UNCHANGED
+		}
+	}
+	return d
+}
+
+func metricDelta(metric string, before, after map[string]float64) MetricDelta {
+	var md = MetricDelta{Metric: metric, Before: before[metric], After: after[metric]}
+	md.Delta = md.After - md.Before
+	switch {
+	case neutralMetrics[metric]:
+		md.Worse = false
+	case worseWhenLower[metric]:
+		md.Worse = md.Delta < 0
+	default:
+		md.Worse = md.Delta > 0
+	}
+	return md
+}
+
+func mergeKeys[V any](maps ...map[string]V) map[string]bool {
+	var keys = map[string]bool{}
+	for _, m := range maps {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+	return keys
+}
+
+// JSON can't represent NaN & Inf, those values are left out
+func setFinite(values map[string]float64, name string, value float64) {
+	if !math.IsNaN(value) && !math.IsInf(value, 0) {
+		values[name] = value
+	}
+}
+
+// The finite values, nil if there are no values at all
+func finiteValues(values map[string]float64) map[string]float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	var result = make(map[string]float64, len(values))
+	for name, value := range values {
+		setFinite(result, name, value)
+	}
+	return result
+}
+
+func finite(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestUniqueID(t *testing.T) {
+	var seen = map[string]int{}
+	var got []string
+	for _, id := range []string{"init", "init", "F", "init", "T.F"} {
+		got = append(got, uniqueID(seen, id))
+	}
+	var expected = []string{"init", "init#2", "F", "init#3", "T.F"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func testFileSnapshot(t *testing.T, path string, src string) FileSnapshot {
+	var fset = token.NewFileSet()
+	tree, err := parser.ParseFile(fset, path, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm := NewFileMetric(path)
+	fm.Disable(METRIC_LOC)
+	if err := fm.GenerateMetrics(fset, tree); err != nil {
+		t.Fatal(err)
+	}
+	return fm.Snapshot("")
+}
+
+// The functions are matched by ID, the repeated ones (eg.: init) by their order in the file
+func TestCompareDuplicateIDs(t *testing.T) {
+	var before = testFileSnapshot(t, "p.go", `package p
+
+func init() {}
+
+func init() {}
+`)
+	var after = testFileSnapshot(t, "p.go", `package p
+
+func init() {}
+
+func init() {
+	if x > 0 {
+		x++
+	}
+}
+`)
+	if ids := []string{after.Functions[0].ID, after.Functions[1].ID}; !reflect.DeepEqual(ids, []string{"init", "init#2"}) {
+		t.Fatalf("got the IDs %v", ids)
+	}
+	var diff = Compare(&Snapshot{Files: []FileSnapshot{before}}, &Snapshot{Files: []FileSnapshot{after}})
+	if len(diff.Functions) != 1 || diff.Functions[0].ID != "init#2" || diff.Functions[0].Status != STATUS_CHANGED || diff.Functions[0].CC != 1 {
+		t.Errorf("got %+v, expected init#2 changed by 1 CC", diff.Functions)
+	}
+}
+
+func TestCompareFunctions(t *testing.T) {
+	var f = func(id string, cc int, abc int, loc int) FunctionSnapshot {
+		return FunctionSnapshot{ID: id, Signature: id + "()", CC: cc, ABC: abc, LOC: loc}
+	}
+	type delta struct {
+		path, id string
+		status   Status
+		cc, abc  int
+		loc      int
+	}
+	for _, tc := range []struct {
+		name          string
+		before, after []FileSnapshot
+		expected      []delta
+	}{
+		{
+			name:   "unchanged",
+			before: []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("F", 2, 3, 10)}}},
+			after:  []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("F", 2, 3, 10)}}},
+		},
+		{
+			name:     "changed",
+			before:   []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("F", 2, 3, 10), f("G", 1, 1, 3)}}},
+			after:    []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("F", 4, 2, 12), f("G", 1, 1, 3)}}},
+			expected: []delta{{"a.go", "F", STATUS_CHANGED, 2, -1, 2}},
+		},
+		{
+			name:     "moved within the file",
+			before:   []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{{ID: "F", CC: 2, StartLine: 3}}}},
+			after:    []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{{ID: "F", CC: 2, StartLine: 30}}}},
+			expected: nil,
+		},
+		{
+			name:     "added & removed",
+			before:   []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("Old", 3, 4, 8)}}},
+			after:    []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("New", 1, 2, 4)}}},
+			expected: []delta{{"a.go", "New", STATUS_ADDED, 1, 2, 4}, {"a.go", "Old", STATUS_REMOVED, -3, -4, -8}},
+		},
+		{
+			// There is no rename detection: the old name is removed, the new one added
+			name:     "renamed",
+			before:   []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("Parse", 5, 6, 20)}}},
+			after:    []FileSnapshot{{Path: "a.go", Functions: []FunctionSnapshot{f("parse", 5, 6, 20)}}},
+			expected: []delta{{"a.go", "Parse", STATUS_REMOVED, -5, -6, -20}, {"a.go", "parse", STATUS_ADDED, 5, 6, 20}},
+		},
+		{
+			name:     "file added",
+			after:    []FileSnapshot{{Path: "b.go", Functions: []FunctionSnapshot{f("F", 1, 1, 2), f("G", 0, 1, 1)}}},
+			expected: []delta{{"b.go", "F", STATUS_ADDED, 1, 1, 2}, {"b.go", "G", STATUS_ADDED, 0, 1, 1}},
+		},
+		{
+			name:     "file removed",
+			before:   []FileSnapshot{{Path: "b.go", Functions: []FunctionSnapshot{f("F", 1, 1, 2)}}},
+			expected: []delta{{"b.go", "F", STATUS_REMOVED, -1, -1, -2}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var diff = Compare(&Snapshot{Files: tc.before}, &Snapshot{Files: tc.after})
+			var got []delta
+			for _, d := range diff.Functions {
+				got = append(got, delta{d.Path, d.ID, d.Status, d.CC, d.ABC, d.LOC})
+				if (d.Before == nil) != (d.Status == STATUS_ADDED) || (d.After == nil) != (d.Status == STATUS_REMOVED) {
+					t.Errorf("%s %s: before %v, after %v", d.ID, d.Status, d.Before, d.After)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got %+v, expected %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMetricDelta(t *testing.T) {
+	for _, tc := range []struct {
+		metric        string
+		before, after float64
+		worse         bool
+	}{
+		{"cc_p95", 4, 5, true},
+		{"cc_p95", 5, 4, false},
+		{"cc_p95", 4, 4, false},
+		// Lower is worse
+		{"comment_density", 0.3, 0.2, true},
+		{"comment_density", 0.2, 0.3, false},
+		{"comment_loc", 100, 90, true},
+		// Size is neither
+		{"code_loc", 100, 500, false},
+		{"functions", 10, 5, false},
+		{"complex_functions", 1, 3, false},
+	} {
+		var md = metricDelta(tc.metric, map[string]float64{tc.metric: tc.before}, map[string]float64{tc.metric: tc.after})
+		if md.Delta != tc.after-tc.before || md.Worse != tc.worse {
+			t.Errorf("%s %v -> %v: got %+v, expected worse: %v", tc.metric, tc.before, tc.after, md, tc.worse)
+		}
+	}
+	// A metric missing on one side counts as 0
+	if md := metricDelta("cc_max", map[string]float64{}, map[string]float64{"cc_max": 3}); md.Before != 0 || md.Delta != 3 || !md.Worse {
+		t.Errorf("got %+v for a new metric", md)
+	}
+}
+
+func TestCompareFiles(t *testing.T) {
+	var baseline = Snapshot{
+		Files: []FileSnapshot{
+			{Path: "better.go", Metrics: map[string]float64{"cc_max": 5, "comment_density": 0.1}},
+			{Path: "bigger.go", Metrics: map[string]float64{"code_loc": 100, "functions": 3}},
+			{Path: "gone.go", Metrics: map[string]float64{}},
+			{Path: "worse.go", Metrics: map[string]float64{"cc_max": 5, "abc": 10, "comment_density": 0.3}},
+		},
+		Summary: map[string]float64{"cc_p95": 4, "files": 4},
+	}
+	var current = Snapshot{
+		Files: []FileSnapshot{
+			{Path: "better.go", Metrics: map[string]float64{"cc_max": 3, "comment_density": 0.2}},
+			{Path: "bigger.go", Metrics: map[string]float64{"code_loc": 400, "functions": 9}},
+			{Path: "new.go", Metrics: map[string]float64{}},
+			{Path: "worse.go", Metrics: map[string]float64{"cc_max": 7, "abc": 10, "comment_density": 0.2}},
+		},
+		Summary: map[string]float64{"cc_p95": 4.5, "files": 4},
+	}
+	var diff = Compare(&baseline, &current)
+
+	var before, after = 0.3, 0.2
+	var expected = []FileDelta{
+		{Path: "gone.go", Status: STATUS_REMOVED},
+		{Path: "new.go", Status: STATUS_ADDED},
+		{Path: "worse.go", Status: STATUS_CHANGED, Worsened: []MetricDelta{
+			{Metric: "cc_max", Before: 5, After: 7, Delta: 2, Worse: true},
+			{Metric: "comment_density", Before: before, After: after, Delta: after - before, Worse: true},
+		}},
+	}
+	if !reflect.DeepEqual(diff.Files, expected) {
+		t.Errorf("got %+v, expected %+v", diff.Files, expected)
+	}
+	var summary = []MetricDelta{
+		{Metric: "cc_p95", Before: 4, After: 4.5, Delta: 0.5, Worse: true},
+		{Metric: "files", Before: 4, After: 4, Delta: 0},
+	}
+	if !reflect.DeepEqual(diff.Summary, summary) {
+		t.Errorf("got the summary %+v, expected %+v", diff.Summary, summary)
+	}
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	"log/slog"
 	"math"
 	"math/big"
 	"sort"
@@ -26,7 +27,68 @@ const (
 	W_COM_DEN   int = 1 // Weight for comment density
 )
 
+// Informational levels used by the calculated metrics, defaults are the constants above
+type Levels struct {
+	CCLow         int     `json:"cc_low" yaml:"cc_low"`
+	CCModerate    int     `json:"cc_moderate" yaml:"cc_moderate"`
+	CCHigh        int     `json:"cc_high" yaml:"cc_high"`
+	CCTopN        int     `json:"cc_top_n" yaml:"cc_top_n"`
+	ABCHigh       float64 `json:"abc_high" yaml:"abc_high"`
+	KLOCMagnitude int     `json:"kloc_magnitude" yaml:"kloc_magnitude"`
+}
+
+// Weights of the composite score, defaults are the constants above
+type Weights struct {
+	CCMedian       float64 `json:"cc_median" yaml:"cc_median"`
+	CCP95          float64 `json:"cc_p95" yaml:"cc_p95"`
+	ABCPerFunction float64 `json:"abc_per_function" yaml:"abc_per_function"`
+	HalEffort      float64 `json:"halstead_effort" yaml:"halstead_effort"`
+	CommentDensity float64 `json:"comment_density" yaml:"comment_density"`
+}
+
+// The tunables of SummaryMetrics
+type Settings struct {
+	Levels  Levels  `json:"levels" yaml:"levels"`
+	Weights Weights `json:"weights" yaml:"weights"`
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		Levels: Levels{
+			CCLow:         CC_LOW,
+			CCModerate:    CC_MODERATE,
+			CCHigh:        CC_HIGH,
+			CCTopN:        CC_TOP_N,
+			ABCHigh:       ABC_T_HIGH,
+			KLOCMagnitude: KLOC_MAGN,
+		},
+		Weights: Weights{
+			CCMedian:       float64(W_CC_MEDIAN),
+			CCP95:          float64(W_CC_P95),
+			ABCPerFunction: float64(W_ABC_FUN),
+			HalEffort:      float64(W_HAL_EFF),
+			CommentDensity: float64(W_COM_DEN),
+		},
+	}
+}
+
+// Checks that the levels are usable
+func (s *Settings) Validate() error {
+	l := s.Levels
+	if l.CCLow <= 0 || l.CCModerate <= 0 || l.CCHigh <= 0 {
+		return fmt.Errorf("levels: the cc levels have to be positive")
+	}
+	if l.CCLow > l.CCModerate || l.CCModerate > l.CCHigh {
+		return fmt.Errorf("levels: cc_low <= cc_moderate <= cc_high does not hold")
+	}
+	if l.CCTopN <= 0 || l.ABCHigh <= 0 || l.KLOCMagnitude <= 0 {
+		return fmt.Errorf("levels: cc_top_n, abc_high and kloc_magnitude have to be positive")
+	}
+	return nil
+}
+
 type SummaryMetrics struct {
+	settings Settings // DefaultSettings() unless created with NewSummaryMetrics
 	// Calculated metrics
 	cyclDestinyPerkLOC float64 // (sum of CC over functions with ABC code size > 0) / (total code LOC / 1000)
 	cyclCAverage       float64 // average CC over functions with ABC code size > 0
@@ -57,182 +119,18 @@ type SummaryMetrics struct {
 	compositeScore float64 // W_CC_MEDIAN*z(median CC) + W_CC_P95*z(P95 CC) + W_ABC_FUN*z(ABC per function) + W_HAL_EFF*z(Halstead effort per kLOC) – W_COM_DEN*z(comment density)
 }
 
-func (sm *SummaryMetrics) CalculateMetrics(fileMetrics []FileMetric) {
-	// Reset
-	*sm = SummaryMetrics{}
-	var kLocMagnitude float64 = float64(KLOC_MAGN * 1000)
-
-	var (
-		totalCodeLOC    int
-		totalCommentLOC int
-		distinctImports map[string]int
-		totalStructs    int
-		totalFunctions  int
-		nrOfComplFuncs  int
-
-		ccValues         []float64
-		abcValues        []float64
-		halVolumeValues  []float64
-		halEffortValues  []float64
-		halEffortPerK    []float64
-		commentDensities []float64
-		funPerFile       []float64
-		structsPerFile   []float64
-		locPerFunction   []float64
-	)
-	distinctImports = map[string]int{}
-
-	for _, fm := range fileMetrics {
-		for imp := range fm.imports {
-			distinctImports[imp]++
-		}
-		totalStructs += fm.nrOfStructs
-
-		funsInFile := len(fm.abcMetrics)
-		totalFunctions += funsInFile
-		funPerFile = append(funPerFile, float64(funsInFile))
-		structsPerFile = append(structsPerFile, float64(fm.nrOfStructs))
-
-		codeLOC := fm.nrOfLines.Go.Code
-		commentLOC := fm.nrOfLines.Go.Comment
-		totalCodeLOC += codeLOC
-		totalCommentLOC += commentLOC
-
-		funsWithMetrics := 0
-		for i := 0; i < minInt(len(fm.abcMetrics), len(fm.cycloCMetric)); i++ {
-			abcm := fm.abcMetrics[i]
-			if abcm.CodeSize() == 0 {
-				continue
-			}
-			cc := fm.cycloCMetric[i].ccm
-			ccValues = append(ccValues, float64(cc))
-			abcValues = append(abcValues, float64(abcm.CodeSize()))
-			funsWithMetrics++
-		}
-		if funsWithMetrics > 0 {
-			locPerFunction = append(locPerFunction, float64(codeLOC)/float64(funsWithMetrics))
-		}
-		nrOfComplFuncs += funsWithMetrics
-
-		vol := fm.fileHalstead.Volume()
-		halVolumeValues = append(halVolumeValues, vol)
-		eff := fm.fileHalstead.Effort()
-		halEffortValues = append(halEffortValues, eff)
-		if codeLOC > 0 {
-			halEffortPerK = append(halEffortPerK, eff/(float64(codeLOC)/kLocMagnitude))
-		}
-
-		if codeLOC+commentLOC > 0 {
-			cd := float64(commentLOC) / float64(codeLOC+commentLOC)
-			commentDensities = append(commentDensities, cd)
-		}
-	}
-
-	kLOC := float64(totalCodeLOC) / kLocMagnitude
-	totalCC := sumFloatBig(ccValues)
-
-	// Cyclomatic complexity metrics
-	if kLOC > 0 {
-		sm.cyclDestinyPerkLOC = div(totalCC, kLOC)
-	}
-	sm.cyclCAverage = meanFloat64(ccValues)
-	sm.cyclCMedian = medianFloat64(ccValues)
-	sm.cyclCP95 = percentileFloat64(ccValues, 95)
-	if len(ccValues) > 0 {
-		sm.cyclCHighRate = float64(countAbove(ccValues, float64(CC_HIGH))) / float64(len(ccValues))
-	}
-	sm.cyclCConcentration = ccConcentration(ccValues, CC_TOP_N)
-
-	// Halstead metrics
-	if kLOC > 0 {
-		sm.halVolumePerkLOC = div(sumFloatBig(halVolumeValues), kLOC)
-		fmt.Printf("--- sm.halVolumePerkLOC %f\n", sm.halVolumePerkLOC)
-		sm.halEffortPerkLOC = div(sumFloatBig(halEffortValues), kLOC)
-		fmt.Printf("--- sm.halEffortPerkLOC %f\n", sm.halEffortPerkLOC)
-	} else {
-		fmt.Printf("!!! halVolumePerkLOC & halEffortPerkLOC is not calculated !!!")
-	}
-	// As requested: median CC over functions with ABC code size > 0
-	sm.halDifMedian = medianFloat64(ccValues)
-
-	// ABC metrics
-	sm.abcCodeSizePerFun = medianFloat64(abcValues)
-	if len(abcValues) > 0 {
-		sm.abcBranCondRatio = div(sumFloatBig(abcValues), float64(len(abcValues)))
-		sm.abcHighRate = float64(countAbove(abcValues, ABC_T_HIGH)) / float64(len(abcValues))
-	}
-
-	// Simple metrics
-	sm.totalNrOfFiles = len(fileMetrics)
-	sm.totalCodeLOC = totalCodeLOC
-	sm.totalCommentLOC = totalCommentLOC
-	sm.nrOfDImports = len(distinctImports)
-	sm.nrOfStructs = totalStructs
-	sm.nrOfFunctions = totalFunctions
-	sm.nrOfComplexFuncs = nrOfComplFuncs
-	sm.funPerFMedian = medianFloat64(funPerFile)
-	sm.strucPerFMedian = medianFloat64(structsPerFile)
-	sm.locPerFMedian = medianFloat64(locPerFunction)
-	if totalCodeLOC+totalCommentLOC > 0 {
-		sm.commentDensity = float64(totalCommentLOC) / float64(totalCodeLOC+totalCommentLOC)
-	}
-
-	// Composite score (z-scores within this project)
-	zMedianCC := zScore(sm.cyclCMedian, ccValues)
-	zP95CC := zScore(sm.cyclCP95, ccValues)
-	zABC := zScore(sm.abcCodeSizePerFun, abcValues)
-	zHalEff := zScore(sm.halEffortPerkLOC, halEffortPerK)
-	zComment := zScore(sm.commentDensity, commentDensities)
-
-	// TODO: can return NaN, should use "math/big"
-	sm.compositeScore = float64(W_CC_MEDIAN)*zMedianCC +
-		float64(W_CC_P95)*zP95CC +
-		float64(W_ABC_FUN)*zABC +
-		float64(W_HAL_EFF)*zHalEff -
-		float64(W_COM_DEN)*zComment
-}
-
-func zScore(value float64, values []float64) float64 {
-	if len(values) == 0 {
-		return 0
-	}
-	mean := meanFloat64(values)
-	std := stddevFloat64(values, mean)
-	if std == 0 {
-		return 0
-	}
-	return (value - mean) / std
+func NewSummaryMetrics(settings Settings) SummaryMetrics {
+	return SummaryMetrics{settings: settings}
 }
 
-func ccConcentration(values []float64, topN int) float64 {
-	if len(values) == 0 {
-		return 0
-	}
-	sorted := append([]float64(nil), values...)
-	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
-
-	limit := minInt(topN, len(sorted))
-	topSum := sumFloatBig(sorted[:limit])
-	total := sumFloatBig(sorted)
-
-	var bconc = topSum.Quo(topSum, total)
-	var fconc, acc = bconc.Float64()
-	if acc == big.Exact {
-		return fconc
-	} else {
-		fmt.Printf("--- CommentConcentration can't be calcuated (yet).")
-		return math.NaN()
-	}
-}
-
-func countAbove(values []float64, threshold float64) int {
-	count := 0
-	for _, v := range values {
-		if v > threshold {
-			count++
-		}
+// The summary of the files, see Aggregator for the streaming version
+func (sm *SummaryMetrics) CalculateMetrics(fileMetrics []FileMetric) {
+	// Reset, but keep the settings
+	var a = NewAggregator(sm.Settings(), QUANTILES_EXACT)
+	for i := range fileMetrics {
+		a.Add(&fileMetrics[i])
 	}
-	return count
+	*sm = a.Summary()
 }
 
 func sumFloatBig(values []float64) (sum *big.Float) {
@@ -256,7 +154,7 @@ func div(x *big.Float, y float64) float64 {
 		// The result can be represented as a float64
 		return mean
 	} else {
-		fmt.Printf("--- Value can't be calcuated (yet).")
+		slog.Debug("the quotient doesn't fit into a float64", "quotient", x)
 		return math.NaN()
 	}
 }
@@ -343,6 +241,14 @@ func minInt(a, b int) int {
 	return b
 }
 
+// The settings the metrics are calculated with
+func (sm *SummaryMetrics) Settings() Settings {
+	if sm.settings == (Settings{}) {
+		return DefaultSettings()
+	}
+	return sm.settings
+}
+
 func (sm *SummaryMetrics) CyclDestinyPerkLOC() float64 {
 	return sm.cyclDestinyPerkLOC
 }
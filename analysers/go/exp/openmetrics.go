@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// The prefix of every metric name
+const OPENMETRICS_PREFIX string = "code_stats_"
+
+// The upper bounds of the histogram buckets of the function level metrics
+var (
+	CC_BUCKETS  = []float64{1, 2, 3, 5, 7, 10, 15, 20, 30, 50}
+	ABC_BUCKETS = []float64{5, 10, 15, 20, 30, 40, 60, 80, 100, 150}
+)
+
+// Writes the summary & the per package summaries as gauges, the distribution of the function CC
+// & ABC as histograms (unless the run was streamed) and the reports beyond the summary as
+// gauges, in the OpenMetrics text format. The Prometheus text format parsers (eg.: the node
+// exporter textfile collector) accept it too, '# EOF' is a comment for them.
+func writeOpenMetrics(w io.Writer, data summaryData) error {
+	var bw = bufio.NewWriter(w)
+	_, module := findModule(data.root)
+	var labels = []string{"project", data.Project, "module", module}
+
+	var summary, packages = data.summary, data.packages
+	if summary == nil {
+		summary = metrics.NewSnapshot(data.Project, data.root, data.fileMetrics, data.sm).Summary
+		packages = metrics.PackageValues(data.root, data.fileMetrics, data.sm.Settings())
+	}
+	for _, name := range slices.Sorted(maps.Keys(summary)) {
+		family := OPENMETRICS_PREFIX + name
+		fmt.Fprintf(bw, "# HELP %s The %s of the project.\n", family, strings.ReplaceAll(name, "_", " "))
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", family)
+		writeSample(bw, family, labels, summary[name])
+	}
+
+	var names = map[string]bool{}
+	for _, values := range packages {
+		for name := range values {
+			names[name] = true
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		family := OPENMETRICS_PREFIX + "package_" + name
+		fmt.Fprintf(bw, "# HELP %s The %s of the package.\n", family, strings.ReplaceAll(name, "_", " "))
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", family)
+		for _, pkg := range slices.Sorted(maps.Keys(packages)) {
+			if v, ok := packages[pkg][name]; ok {
+				writeSample(bw, family, append(slices.Clone(labels), "package", pkg), v)
+			}
+		}
+	}
+
+	for _, gauge := range []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"skipped_files", "The nr of files that were not measured.", float64(data.SkippedFiles)},
+		{"partial_files", "The nr of files that were measured partially.", float64(data.PartialFiles)},
+		{"generated_files", "The nr of generated files.", float64(len(data.GeneratedFiles))},
+		{"generated_code_loc", "The code LOC of the generated files.", float64(data.GeneratedCodeLOC)},
+		{"generated_share", "The share of the generated files in the code LOC.", data.GeneratedShare},
+	} {
+		writeFamily(bw, gauge.name, gauge.help)
+		writeSample(bw, OPENMETRICS_PREFIX+gauge.name, labels, gauge.value)
+	}
+
+	if !data.streamed {
+		var cc, abc []float64
+		for i := range data.fileMetrics {
+			for _, f := range data.fileMetrics[i].Snapshot(data.root).Functions {
+				cc = append(cc, float64(f.CC))
+				abc = append(abc, float64(f.ABC))
+			}
+		}
+		writeHistogram(bw, OPENMETRICS_PREFIX+"function_cc", "The Cyclomatic Complexity of the functions.", labels, CC_BUCKETS, cc)
+		writeHistogram(bw, OPENMETRICS_PREFIX+"function_abc", "The ABC code size of the functions.", labels, ABC_BUCKETS, abc)
+	}
+	writeSectionMetrics(bw, labels, &data)
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
+
+// Writes the reports beyond the summary as gauges
+func writeSectionMetrics(w io.Writer, labels []string, data *summaryData) {
+	if data.Changed != nil {
+		var byStatus = map[metrics.Status]int{}
+		for _, f := range data.Changed.Functions {
+			byStatus[f.Status]++
+		}
+		writeFamily(w, "changed_functions", "The nr of functions touched by the changes since the base.")
+		for _, status := range slices.Sorted(maps.Keys(byStatus)) {
+			writeSample(w, OPENMETRICS_PREFIX+"changed_functions", append(slices.Clone(labels), "status", string(status)), float64(byStatus[status]))
+		}
+	}
+	if data.Diff != nil {
+		writeFamily(w, "baseline_delta", "The change of the summary metrics since the baseline.")
+		for _, m := range data.Diff.Summary {
+			writeSample(w, OPENMETRICS_PREFIX+"baseline_delta", append(slices.Clone(labels), "metric", m.Metric), m.Delta)
+		}
+	}
+	if data.Ratchet != nil {
+		writeFamily(w, "ratchet_regressions", "The nr of regressions against the ratchet snapshot.")
+		writeSample(w, OPENMETRICS_PREFIX+"ratchet_regressions", labels, float64(len(data.Ratchet.Regressions)))
+	}
+	if data.Hotspots != nil {
+		writeHotspotMetrics(w, labels, data.Hotspots)
+	}
+	if data.Ownership != nil {
+		writeOwnershipMetrics(w, labels, data.Ownership)
+	}
+	if data.Coupling != nil {
+		writeFamily(w, "coupling_degree", "The degree of the temporal coupling of the files, in percent.")
+		for _, p := range data.Coupling.Pairs {
+			writeSample(w, OPENMETRICS_PREFIX+"coupling_degree", append(slices.Clone(labels), "file_a", p.FileA, "file_b", p.FileB), p.Degree)
+		}
+		writeFamily(w, "hidden_couplings", "The nr of coupled files in packages that don't import each other.")
+		writeSample(w, OPENMETRICS_PREFIX+"hidden_couplings", labels, float64(len(data.Coupling.Hidden)))
+	}
+	if data.Gate != nil {
+		writeFamily(w, "gate_violations", "The nr of violated quality gate thresholds.")
+		writeSample(w, OPENMETRICS_PREFIX+"gate_violations", labels, float64(len(data.Gate.Violations)))
+	}
+}
+
+func writeHotspotMetrics(w io.Writer, labels []string, report *hotspotReport) {
+	writeFamily(w, "hotspot_score", "The nr of commits x the Cyclomatic Complexity of the top files.")
+	for _, h := range report.Files {
+		writeSample(w, OPENMETRICS_PREFIX+"hotspot_score", append(slices.Clone(labels), "path", h.Path), h.Score)
+	}
+	writeFamily(w, "function_hotspot_score", "The nr of commits x the Cyclomatic Complexity of the top functions.")
+	for _, h := range report.Functions {
+		writeSample(w, OPENMETRICS_PREFIX+"function_hotspot_score", append(slices.Clone(labels), "path", h.Path, "function", h.Function), h.Score)
+	}
+}
+
+func writeOwnershipMetrics(w io.Writer, labels []string, report *ownershipReport) {
+	writeFamily(w, "package_bus_factor", "The nr of authors that have to leave to orphan more than half of the lines of the package.")
+	for _, o := range report.Packages {
+		writeSample(w, OPENMETRICS_PREFIX+"package_bus_factor", append(slices.Clone(labels), "package", o.Path), float64(o.BusFactor))
+	}
+	writeFamily(w, "package_knowledge_loss", "The share of the lines of the package written by departed authors.")
+	for _, o := range report.Packages {
+		writeSample(w, OPENMETRICS_PREFIX+"package_knowledge_loss", append(slices.Clone(labels), "package", o.Path), o.KnowledgeLoss)
+	}
+	writeFamily(w, "single_owner_files", "The nr of complex files with a single owner.")
+	writeSample(w, OPENMETRICS_PREFIX+"single_owner_files", labels, float64(len(report.SingleOwners)))
+}
+
+// Writes the metadata of a gauge family
+func writeFamily(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", OPENMETRICS_PREFIX, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s gauge\n", OPENMETRICS_PREFIX, name)
+}
+
+func writeHistogram(w io.Writer, family string, help string, labels []string, buckets []float64, values []float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", family, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", family)
+	var sum float64
+	var counts = make([]int, len(buckets))
+	for _, v := range values {
+		sum += v
+		for i, upper := range buckets {
+			if v <= upper {
+				counts[i]++
+			}
+		}
+	}
+	for i, upper := range buckets {
+		writeSample(w, family+"_bucket", append(slices.Clone(labels), "le", formatValue(upper)), float64(counts[i]))
+	}
+	writeSample(w, family+"_bucket", append(slices.Clone(labels), "le", "+Inf"), float64(len(values)))
+	writeSample(w, family+"_count", labels, float64(len(values)))
+	writeSample(w, family+"_sum", labels, sum)
+}
+
+// Writes one sample, labels are name & value pairs
+func writeSample(w io.Writer, name string, labels []string, value float64) {
+	var pairs = make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatValue(value))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/git"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// The ownership of a file or a package (directory)
+type ownership struct {
+	Path          string // Relative to the analysed directory
+	Files         int
+	Lines         int
+	Contributors  int
+	MainAuthor    string
+	MainShare     float64 // Share of the lines of the main author
+	KnowledgeLoss float64 // Share of the lines of the departed authors
+	BusFactor     int     // Nr of authors that have to leave to orphan more than half of the lines
+	CC            int     // Summed up over the functions
+	authors       map[string]*git.Authorship
+}
+
+type ownershipReport struct {
+	Departed     []string
+	Untracked    []string // Files not in the repository (yet), left out
+	Packages     []ownership
+	SingleOwners []ownership // Files with a single owner, most complex first
+}
+
+// Blames every analysed file and aggregates the authorship per file & package
+func computeOwnership(dir string, snapshot *metrics.Snapshot, workers int, cfg config.Ownership) (ownershipReport, error) {
+	var report = ownershipReport{Departed: cfg.Departed}
+	repo, err := git.TopLevel(dir)
+	if err != nil {
+		return report, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return report, err
+	}
+	subDir, err := filepath.Rel(repo, absDir)
+	if err != nil {
+		return report, err
+	}
+	tracked, err := git.TrackedFiles(repo)
+	if err != nil {
+		return report, err
+	}
+
+	var files = make([]ownership, len(snapshot.Files))
+	var errs = make([]error, len(snapshot.Files))
+	var jobs = make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				fs := snapshot.Files[j]
+				files[j] = ownership{Path: fs.Path, Files: 1}
+				for _, f := range fs.Functions {
+					files[j].CC += f.CC
+				}
+				files[j].authors, errs[j] = git.Blame(repo, filepath.Join(absDir, filepath.FromSlash(fs.Path)))
+			}
+		}()
+	}
+	for i, fs := range snapshot.Files {
+		if !tracked[path.Join(filepath.ToSlash(subDir), fs.Path)] {
+			report.Untracked = append(report.Untracked, fs.Path)
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var packages = map[string]*ownership{}
+	for i := range files {
+		if errs[i] != nil {
+			return report, fmt.Errorf("blame %s: %w", files[i].Path, errs[i])
+		}
+		if files[i].authors == nil {
+			// Untracked
+			continue
+		}
+		files[i].summarise(cfg.Departed)
+		pkg := path.Dir(files[i].Path)
+		p, ok := packages[pkg]
+		if !ok {
+			p = &ownership{Path: pkg, authors: map[string]*git.Authorship{}}
+			packages[pkg] = p
+		}
+		p.Files++
+		p.CC += files[i].CC
+		for email, a := range files[i].authors {
+			if pa, ok := p.authors[email]; ok {
+				pa.Lines += a.Lines
+			} else {
+				p.authors[email] = &git.Authorship{Name: a.Name, Email: a.Email, Lines: a.Lines}
+			}
+		}
+		if files[i].MainShare >= cfg.SingleOwnerShare {
+			report.SingleOwners = append(report.SingleOwners, files[i])
+		}
+	}
+
+	for _, pkg := range slices.Sorted(maps.Keys(packages)) {
+		packages[pkg].summarise(cfg.Departed)
+		report.Packages = append(report.Packages, *packages[pkg])
+	}
+	sort.SliceStable(report.SingleOwners, func(i, j int) bool {
+		return report.SingleOwners[i].CC > report.SingleOwners[j].CC
+	})
+	if len(report.SingleOwners) > cfg.Top {
+		report.SingleOwners = report.SingleOwners[:cfg.Top]
+	}
+	return report, nil
+}
+
+// Calculates the ownership figures from the authors
+func (o *ownership) summarise(departed []string) {
+	var authors = make([]*git.Authorship, 0, len(o.authors))
+	o.Lines = 0
+	for _, a := range o.authors {
+		authors = append(authors, a)
+		o.Lines += a.Lines
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].Lines != authors[j].Lines {
+			return authors[i].Lines > authors[j].Lines
+		}
+		return authors[i].Email < authors[j].Email
+	})
+	o.Contributors = len(authors)
+	if o.Lines == 0 {
+		return
+	}
+	o.MainAuthor = authors[0].Name
+	o.MainShare = float64(authors[0].Lines) / float64(o.Lines)
+
+	var lost int
+	for _, a := range authors {
+		if isDeparted(a, departed) {
+			lost += a.Lines
+		}
+	}
+	o.KnowledgeLoss = float64(lost) / float64(o.Lines)
+
+	// Remove the biggest owners until more than half of the lines are orphaned
+	var orphaned int
+	o.BusFactor = 0
+	for _, a := range authors {
+		orphaned += a.Lines
+		o.BusFactor++
+		if 2*orphaned > o.Lines {
+			break
+		}
+	}
+}
+
+func isDeparted(a *git.Authorship, departed []string) bool {
+	for _, d := range departed {
+		if strings.EqualFold(d, a.Email) || strings.EqualFold(d, a.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,64 +1,41 @@
 package main
 
 import (
-	"fmt"
-	"go/parser"
-	"go/token"
-	"sync"
+	"context"
+	"log/slog"
 
-	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/exp/cache"
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/internal/engine"
 )
 
-type parseResult struct {
-	fm  metrics.FileMetric
-	err error
+// Collects the selected files of dir, then measures them and calculates the summary
+func analyse(ctx context.Context, dir string, cfg *config.Config) (*engine.Report, error) {
+	return analyseOnly(ctx, dir, cfg, nil, nil)
 }
 
-func parseConcurrently(paths []string, workers int) ([]metrics.FileMetric, error) {
-	jobs := make(chan string)
-	results := make(chan parseResult)
-	var wg sync.WaitGroup
-
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for p := range jobs {
-				fm, err := parse(p)
-				results <- parseResult{fm: fm, err: err}
-			}
-		}()
-	}
-
-	go func() {
-		for _, p := range paths {
-			jobs <- p
-		}
-		close(jobs)
-		wg.Wait()
-		close(results)
-	}()
+// Like analyse, but restricted to the files (relative to dir) only accepts, if not nil, and
+// reporting the progress to progress, if not nil
+func analyseOnly(ctx context.Context, dir string, cfg *config.Config, only func(rel string) bool,
+	progress func(engine.Progress)) (*engine.Report, error) {
+	slog.Info("analysing", "dir", dir, "workers", cfg.Workers)
+	return engine.Run(ctx, dir, engine.WithConfig(*cfg), engine.WithFilter(only),
+		engine.WithCache(openCache(cfg)), engine.WithProgress(progress))
+}
 
-	fileMetrics := make([]metrics.FileMetric, 0, len(paths))
-	for res := range results {
-		if res.err != nil {
-			return nil, res.err
-		}
-		fileMetrics = append(fileMetrics, res.fm)
+// Warns about the files that were skipped or measured partially, they are listed in the report
+func printIncomplete(data *summaryData) {
+	if data.SkippedFiles > 0 || data.PartialFiles > 0 {
+		slog.Warn("the results are incomplete", "skipped", data.SkippedFiles, "partial", data.PartialFiles)
 	}
-	return fileMetrics, nil
 }
 
-func parse(filename string) (fm metrics.FileMetric, err error) {
-	fset := token.NewFileSet()
-	fmt.Printf("Parsing file: '%s'\n", filename)
-	tree, err := parser.ParseFile(fset, filename, nil, parser.AllErrors)
+// The file metrics cache of the configuration, nil if it's disabled or can't be opened
+func openCache(cfg *config.Config) *cache.Cache {
+	c, err := engine.OpenCache(cfg)
 	if err != nil {
-		return
+		slog.Warn("cache disabled", "err", err)
+		return nil
 	}
-	//ast.Print(fset, tree)
-
-	fm = metrics.NewFileMetric(filename)
-	fm.GenerateMetrics(tree)
-	return
+	return c
 }
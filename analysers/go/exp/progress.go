@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// Log formats
+const (
+	LOG_TEXT string = "text"
+	LOG_JSON string = "json"
+)
+
+var LogFormats = []string{LOG_TEXT, LOG_JSON}
+
+// Progress reporting modes
+const (
+	PROGRESS_AUTO string = "auto" // A bar if stderr is a terminal, nothing otherwise
+	PROGRESS_BAR  string = "bar"  // Files done/total, throughput & ETA on stderr
+	PROGRESS_JSON string = "json" // A JSON object per file on stderr, for tools
+	PROGRESS_NONE string = "none"
+)
+
+var ProgressModes = []string{PROGRESS_AUTO, PROGRESS_BAR, PROGRESS_JSON, PROGRESS_NONE}
+
+const (
+	PROGRESS_INTERVAL time.Duration = 100 * time.Millisecond // Between the redraws of the bar
+	PROGRESS_WIDTH    int           = 30                     // Of the bar, in characters
+)
+
+// Logs to stderr from level (debug, info, warn or error) on, the report goes to stdout
+func setupLogging(level string, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level: %w", err)
+	}
+	var opts = &slog.HandlerOptions{Level: l}
+	switch format {
+	case LOG_TEXT:
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case LOG_JSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("unknown log format %q, expected one of %v", format, LogFormats)
+	}
+	return nil
+}
+
+// The progress reporter of the mode, nil if there is nothing to report, and the function that
+// clears what's left of it on stderr
+func newProgress(mode string) (func(engine.Progress), func(), error) {
+	switch mode {
+	case PROGRESS_AUTO:
+		if !isTerminal(os.Stderr) {
+			return nil, func() {}, nil
+		}
+		fallthrough
+	case PROGRESS_BAR:
+		var pb = progressBar{w: os.Stderr}
+		return pb.update, pb.finish, nil
+	case PROGRESS_JSON:
+		var encoder = json.NewEncoder(os.Stderr)
+		return func(p engine.Progress) { encoder.Encode(newProgressEvent(p)) }, func() {}, nil
+	case PROGRESS_NONE:
+		return nil, func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown progress mode %q, expected one of %v", mode, ProgressModes)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Redraws a single line, at most every PROGRESS_INTERVAL
+type progressBar struct {
+	w     io.Writer
+	drawn time.Time
+	shown bool
+}
+
+func (pb *progressBar) update(p engine.Progress) {
+	if p.Done < p.Total && time.Since(pb.drawn) < PROGRESS_INTERVAL {
+		return
+	}
+	pb.drawn, pb.shown = time.Now(), true
+	var filled = PROGRESS_WIDTH * p.Done / max(1, p.Total)
+	fmt.Fprintf(pb.w, "\r\033[K[%s%s] %d/%d files  %.0f files/s  ETA %v",
+		strings.Repeat("=", filled), strings.Repeat(" ", PROGRESS_WIDTH-filled),
+		p.Done, p.Total, p.Rate(), p.ETA().Round(time.Second))
+}
+
+func (pb *progressBar) finish() {
+	if pb.shown {
+		fmt.Fprint(pb.w, "\r\033[K")
+	}
+}
+
+// A line of the JSON progress stream
+type progressEvent struct {
+	Done      int     `json:"done"`
+	Total     int     `json:"total"`
+	Path      string  `json:"path"`
+	ElapsedMs int64   `json:"elapsed_ms"`
+	Rate      float64 `json:"files_per_second"`
+	ETAMs     int64   `json:"eta_ms"`
+}
+
+func newProgressEvent(p engine.Progress) progressEvent {
+	return progressEvent{
+		Done:      p.Done,
+		Total:     p.Total,
+		Path:      p.Path,
+		ElapsedMs: p.Elapsed.Milliseconds(),
+		Rate:      p.Rate(),
+		ETAMs:     p.ETA().Milliseconds(),
+	}
+}
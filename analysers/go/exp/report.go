@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// TODO: do better, SummaryMetrics should handle all of this
+type summaryData struct {
+	Project            string
+	Enabled            map[string]bool `json:"-"`
+	CCHigh             int             `json:"-"`
+	CCTopN             int             `json:"-"`
+	CompositeScore     float64
+	TotalNrOfFiles     int
+	Languages          map[string]int // Nr of files by language
+	TotalCodeLOC       int
+	TotalCommentLOC    int
+	NrOfDImports       int
+	NrOfStructs        int
+	NrOfFunctions      int
+	NrOfComplexFuncs   int
+	FunPerFMedian      float64
+	StrucPerFMedian    float64
+	LocPerFMedian      float64
+	CommentDensity     float64
+	CyclDestinyPerkLOC float64
+	CyclCAverage       float64
+	CyclCMedian        float64
+	CyclCP95           float64
+	CyclCHighRate      float64
+	CyclCConcentration float64
+	HalVolumePerkLOC   float64
+	HalEffortPerkLOC   float64
+	HalDifMedian       float64
+	ABCCodeSizePerFun  float64
+	ABCBranCondRatio   float64
+	ABCHighRate        float64
+	Plugins            map[string]float64 // The summaries of the plugin metrics, see metrics.Register
+	PluginUnits        map[string]string  `json:"-"`
+	SkippedFiles       int                // Not measured at all, see Diagnostics
+	PartialFiles       int                // Measured, but some of the metrics are missing or incomplete
+	Diagnostics        []fileDiagnostic
+	Excluded           []excludedPath // Not measured, see engine.Exclusion
+	ExclusionReasons   map[string]int `json:"-"` // Nr of excluded files & directories by reason
+	GeneratedFiles     []string       // Relative to the analysed directory, see engine.Generated
+	GeneratedCodeLOC   int
+	GeneratedShare     float64 // Of the code LOC of every measured file
+	GeneratedIncluded  bool    // Whether the generated files are in the metrics
+	// The reports beyond the summary, in the order they are rendered; nil if not requested
+	Changed   *changedReport   `json:",omitempty"`
+	Diff      *metrics.Diff    `json:",omitempty"`
+	Ratchet   *ratchetReport   `json:",omitempty"`
+	Hotspots  *hotspotReport   `json:",omitempty"`
+	Ownership *ownershipReport `json:",omitempty"`
+	Coupling  *couplingReport  `json:",omitempty"`
+	Gate      *gateReport      `json:",omitempty"`
+	// For the formats that go beyond the summary
+	root        string
+	fileMetrics []metrics.FileMetric
+	sm          *metrics.SummaryMetrics
+	// Taken from the report, calculated from the files if nil
+	summary  map[string]float64
+	packages map[string]map[string]float64
+	streamed bool // There are no files, see config.Streaming
+}
+
+// A file or directory that is not measured
+type excludedPath struct {
+	Path   string // Relative to the analysed directory
+	Reason string
+	Dir    bool
+}
+
+// The outcome of the ratchet, see metrics.RatchetConfig.Check
+type ratchetReport struct {
+	metrics.RatchetResult
+	Updated string `json:",omitempty"` // The snapshot file rewritten with the improved metrics
+}
+
+// The outcome of the quality gate
+type gateReport struct {
+	Violations     []metrics.Violation
+	NrOfThresholds int
+}
+
+// A problem with a file, see engine.Diagnostic
+type fileDiagnostic struct {
+	Path    string // Relative to the analysed directory
+	Kind    string
+	Message string
+	Skipped bool
+}
+
+func newSummaryData(project string, root string, fileMetrics []metrics.FileMetric, sm *metrics.SummaryMetrics, diags []engine.Diagnostic, cfg *config.Config) summaryData {
+	var enabled = map[string]bool{}
+	for _, m := range metrics.MetricGroups {
+		enabled[m] = cfg.Enabled(m)
+	}
+	var languages = map[string]int{}
+	for i := range fileMetrics {
+		languages[fileMetrics[i].Language()]++
+	}
+	var diagnostics = make([]fileDiagnostic, 0, len(diags))
+	var skipped = map[string]bool{}
+	var partial = map[string]bool{}
+	for _, d := range diags {
+		path, err := filepath.Rel(root, d.Path)
+		if err != nil {
+			path = d.Path
+		}
+		if d.Skipped {
+			skipped[path] = true
+		} else {
+			partial[path] = true
+		}
+		diagnostics = append(diagnostics, fileDiagnostic{Path: filepath.ToSlash(path), Kind: d.Kind, Message: d.Error(), Skipped: d.Skipped})
+	}
+	var plugins = metrics.PluginValues(fileMetrics)
+	var units = map[string]string{}
+	for _, m := range metrics.Registered() {
+		for _, a := range m.Aggregations() {
+			units[m.Name()+"_"+string(a)] = m.Unit()
+		}
+	}
+	return summaryData{
+		Project:            project,
+		Enabled:            enabled,
+		CCHigh:             sm.Settings().Levels.CCHigh,
+		CCTopN:             sm.Settings().Levels.CCTopN,
+		CompositeScore:     sm.CompositeScore(),
+		TotalNrOfFiles:     sm.TotalNrOfFiles(),
+		Languages:          languages,
+		TotalCodeLOC:       sm.TotalCodeLOC(),
+		TotalCommentLOC:    sm.TotalCommentLOC(),
+		NrOfDImports:       sm.NrOfDImports(),
+		NrOfStructs:        sm.NrOfStructs(),
+		NrOfFunctions:      sm.NrOfFunctions(),
+		NrOfComplexFuncs:   sm.NrOfComplexFuncs(),
+		FunPerFMedian:      sm.FunPerFMedian(),
+		StrucPerFMedian:    sm.StrucPerFMedian(),
+		LocPerFMedian:      sm.LocPerFMedian(),
+		CommentDensity:     sm.CommentDensity(),
+		CyclDestinyPerkLOC: sm.CyclDestinyPerkLOC(),
+		CyclCAverage:       sm.CyclCAverage(),
+		CyclCMedian:        sm.CyclCMedian(),
+		CyclCP95:           sm.CyclCP95(),
+		CyclCHighRate:      sm.CyclCHighRate(),
+		CyclCConcentration: sm.CyclCConcentration(),
+		HalVolumePerkLOC:   sm.HalVolumePerkLOC(),
+		HalEffortPerkLOC:   sm.HalEffortPerkLOC(),
+		HalDifMedian:       sm.HalDifMedian(),
+		ABCCodeSizePerFun:  sm.ABCCodeSizePerFun(),
+		ABCBranCondRatio:   sm.ABCBranCondRatio(),
+		ABCHighRate:        sm.ABCHighRate(),
+		Plugins:            plugins,
+		PluginUnits:        units,
+		SkippedFiles:       len(skipped),
+		PartialFiles:       len(partial),
+		Diagnostics:        diagnostics,
+		root:               root,
+		fileMetrics:        fileMetrics,
+		sm:                 sm,
+	}
+}
+
+// Takes the languages, the summaries, the exclusions & the generated code from the report, a
+// streamed one has no files to calculate them from
+func (data *summaryData) setReport(report *engine.Report) {
+	data.Languages = report.Languages()
+	data.Plugins = report.PluginValues()
+	data.summary, data.packages = report.Summary, report.Packages
+	data.streamed = report.Streamed()
+	data.Excluded = make([]excludedPath, 0, len(report.Excluded))
+	data.ExclusionReasons = map[string]int{}
+	for _, e := range report.Excluded {
+		path, err := filepath.Rel(data.root, e.Path)
+		if err != nil {
+			path = e.Path
+		}
+		data.Excluded = append(data.Excluded, excludedPath{Path: filepath.ToSlash(path), Reason: e.Reason, Dir: e.Dir})
+		data.ExclusionReasons[e.Reason]++
+	}
+	data.GeneratedFiles = report.Generated.Files
+	data.GeneratedCodeLOC = report.Generated.CodeLOC
+	data.GeneratedShare = report.Generated.Share
+	data.GeneratedIncluded = report.Generated.Included
+}
+
+// Renders the summary in one format
+type reportWriter func(w io.Writer, data summaryData) error
+
+var reportWriters = map[string]reportWriter{
+	config.FORMAT_MARKDOWN:    writeMarkdown,
+	config.FORMAT_JSON:        writeJSON,
+	config.FORMAT_OPENMETRICS: writeOpenMetrics,
+}
+
+var reportExtensions = map[string]string{
+	config.FORMAT_MARKDOWN:    ".md",
+	config.FORMAT_JSON:        ".json",
+	config.FORMAT_OPENMETRICS: ".prom",
+}
+
+// Renders the summary, then the reports beyond it
+func writeMarkdown(w io.Writer, data summaryData) error {
+	if err := writeTemplate(w, "summary", data); err != nil {
+		return err
+	}
+	for _, section := range data.sections() {
+		if err := writeTemplate(w, section.name, section.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A report beyond the summary, named as its template
+type section struct {
+	name  string
+	value any
+}
+
+// The requested reports beyond the summary, in the order they are rendered
+func (data *summaryData) sections() []section {
+	var sections []section
+	if data.Changed != nil {
+		sections = append(sections, section{"changed", data.Changed})
+	}
+	if data.Diff != nil {
+		sections = append(sections, section{"diff", data.Diff})
+	}
+	if data.Ratchet != nil {
+		sections = append(sections, section{"ratchet", data.Ratchet})
+	}
+	if data.Hotspots != nil {
+		sections = append(sections, section{"hotspots", data.Hotspots})
+	}
+	if data.Ownership != nil {
+		sections = append(sections, section{"ownership", data.Ownership})
+	}
+	if data.Coupling != nil {
+		sections = append(sections, section{"coupling", data.Coupling})
+	}
+	if data.Gate != nil {
+		sections = append(sections, section{"violations", data.Gate})
+	}
+	return sections
+}
+
+// The functions the markdown templates can use
+var templateFuncs = template.FuncMap{
+	"label":  chartLabel,
+	"mul100": func(v float64) float64 { return 100 * v },
+}
+
+// Renders exp/templates/<name>.md.tmpl
+func writeTemplate(w io.Writer, name string, data any) error {
+	tmpl, err := template.New(name + ".md.tmpl").Funcs(templateFuncs).ParseFiles("exp/templates/" + name + ".md.tmpl")
+	if err != nil {
+		return fmt.Errorf("parse template: %w", err)
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("execute template: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, data summaryData) error {
+	var encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(jsonValues(data))
+}
+
+// Converts the exported fields of a struct into a map, replacing the NaN & Inf values
+// (which JSON can't represent) with null. The empty 'omitempty' fields are left out.
+func jsonValues(data any) map[string]any {
+	var values = map[string]any{}
+	var v = reflect.ValueOf(data)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" || (strings.HasSuffix(tag, ",omitempty") && v.Field(i).IsZero()) {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if f, ok := value.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			value = nil
+		}
+		values[field.Name] = value
+	}
+	return values
+}
+
+// Writes the summary & the reports beyond it in every configured format, either to stdout or
+// into the output file(s)
+func writeReports(data summaryData, output config.Output) error {
+	for _, format := range output.Formats {
+		if output.File == "" {
+			if err := reportWriters[format](os.Stdout, data); err != nil {
+				return fmt.Errorf("write %s report: %w", format, err)
+			}
+			continue
+		}
+		fileName := output.File
+		if len(output.Formats) > 1 {
+			fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + reportExtensions[format]
+		}
+		if err := writeReportFile(fileName, format, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReportFile(fileName string, format string, data summaryData) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := reportWriters[format](f, data); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s report: %w", format, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// Job states
+const (
+	JOB_QUEUED    string = "queued"
+	JOB_RUNNING   string = "running"
+	JOB_DONE      string = "done"
+	JOB_FAILED    string = "failed"
+	JOB_CANCELLED string = "cancelled"
+)
+
+// An analysis requested through the API
+type job struct {
+	ID       string    `json:"id"`
+	Source   string    `json:"source"` // The directory, or 'upload'
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+
+	dir      string
+	upload   bool // dir is a temporary directory with the extracted tarball
+	cancel   context.CancelFunc
+	snapshot *metrics.Snapshot
+	packages map[string]map[string]float64
+}
+
+// The analysis service: jobs run in the background, at most a fixed nr of them at once
+type server struct {
+	mu         sync.Mutex
+	jobs       map[string]*job
+	nextID     int
+	slots      chan struct{}
+	configFile string
+	workers    int
+	maxUpload  int64
+	maxExtract int64         // Max total size of the extracted files of a tarball
+	retain     int           // Nr of finished jobs kept
+	retainFor  time.Duration // How long a finished job is kept
+	// Counters for /metrics
+	filesAnalysed int
+	secondsSpent  float64
+}
+
+// Handles the 'serve' subcommand: runs the analyser as a local HTTP service
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "Address to listen on")
+	configFile := fs.String("config", "", "Configuration file (default: discovered upward from the analysed directory)")
+	nrOfWorkers := fs.Int("w", 0, "Nr of workers per job (default: from the configuration)")
+	maxJobs := fs.Int("jobs", 1, "Nr of jobs running at the same time, the others are queued")
+	maxUpload := fs.Int64("max-upload", 256<<20, "Max size of an uploaded tarball in bytes")
+	maxExtract := fs.Int64("max-extract", 1<<30, "Max total size of the files extracted from a tarball in bytes")
+	retain := fs.Int("retain", 100, "Nr of finished jobs kept with their results, the oldest ones are dropped")
+	retainFor := fs.Duration("retain-for", 24*time.Hour, "How long the finished jobs are kept with their results")
+	fs.Parse(args)
+
+	if *maxJobs < 1 || *retain < 0 {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+	var s = server{
+		jobs:       map[string]*job{},
+		slots:      make(chan struct{}, *maxJobs),
+		configFile: *configFile,
+		workers:    *nrOfWorkers,
+		maxUpload:  *maxUpload,
+		maxExtract: *maxExtract,
+		retain:     *retain,
+		retainFor:  *retainFor,
+	}
+	fmt.Fprintf(os.Stderr, "Serving on http://%s\n", *addr)
+	if err := http.ListenAndServe(*addr, s.routes()); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+func (s *server) routes() http.Handler {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.createJob)
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	mux.HandleFunc("DELETE /jobs/{id}", s.deleteJob)
+	mux.HandleFunc("GET /jobs/{id}/project", s.getProject)
+	mux.HandleFunc("GET /jobs/{id}/packages", s.getPackages)
+	mux.HandleFunc("GET /jobs/{id}/files", s.getFiles)
+	mux.HandleFunc("GET /jobs/{id}/functions", s.getFunctions)
+	mux.HandleFunc("GET /metrics", s.getMetrics)
+	return mux
+}
+
+// Starts a job for a local directory ({"dir": "..."} as JSON) or for an uploaded tarball
+// (.tar or .tar.gz as the body)
+func (s *server) createJob(w http.ResponseWriter, r *http.Request) {
+	var j = job{Status: JOB_QUEUED, Created: time.Now().UTC()}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req struct {
+			Dir string `json:"dir"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Dir == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("expected {\"dir\": \"...\"}"))
+			return
+		}
+		if info, err := os.Stat(req.Dir); err != nil || !info.IsDir() {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("%q is not a directory", req.Dir))
+			return
+		}
+		j.Source, j.dir = req.Dir, req.Dir
+	} else {
+		dir, err := os.MkdirTemp("", "code-stats-upload-")
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := extractTarball(http.MaxBytesReader(w, r.Body, s.maxUpload), dir, s.maxExtract); err != nil {
+			os.RemoveAll(dir)
+			respondError(w, http.StatusBadRequest, fmt.Errorf("extract tarball: %w", err))
+			return
+		}
+		j.Source, j.dir, j.upload = "upload", dir, true
+	}
+
+	var ctx context.Context
+	ctx, j.cancel = context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.evict()
+	s.nextID++
+	j.ID = strconv.Itoa(s.nextID)
+	s.jobs[j.ID] = &j
+	var status = j
+	s.mu.Unlock()
+
+	go s.run(ctx, &j)
+	respond(w, http.StatusAccepted, status)
+}
+
+// Waits for a free slot, then analyses the directory of the job
+func (s *server) run(ctx context.Context, j *job) {
+	if j.upload {
+		defer os.RemoveAll(j.dir)
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		s.finish(j, nil, nil, ctx.Err())
+		return
+	}
+	s.mu.Lock()
+	j.Status, j.Started = JOB_RUNNING, time.Now().UTC()
+	s.mu.Unlock()
+
+	cfg, err := loadConfig(s.configFile, j.dir)
+	if err != nil {
+		s.finish(j, nil, nil, fmt.Errorf("load configuration: %w", err))
+		return
+	}
+	if s.workers > 0 {
+		cfg.Workers = s.workers
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = engine.DefaultWorkers()
+	}
+	if j.upload {
+		// Every upload is extracted to a new path, the entries would never be hit
+		cfg.Cache.Enabled = false
+	}
+	report, err := engine.Run(ctx, j.dir, engine.WithConfig(cfg), engine.WithCache(openCache(&cfg)))
+	if err != nil {
+		s.finish(j, nil, nil, err)
+		return
+	}
+	// Uploads are extracted to a temporary directory
+	report.Project = filepath.Base(j.Source)
+	snapshot := report.Snapshot()
+	s.finish(j, &snapshot, report.Packages, nil)
+}
+
+func (s *server) finish(j *job, snapshot *metrics.Snapshot, packages map[string]map[string]float64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.Finished = time.Now().UTC()
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.Status = JOB_CANCELLED
+	case err != nil:
+		j.Status, j.Error = JOB_FAILED, err.Error()
+	default:
+		j.Status, j.snapshot, j.packages = JOB_DONE, snapshot, packages
+		s.filesAnalysed += len(snapshot.Files)
+		s.secondsSpent += j.Finished.Sub(j.Started).Seconds()
+	}
+	s.evict()
+}
+
+// Drops the finished jobs older than the retention period, then the oldest ones beyond the
+// retained nr. Must be called with the lock held.
+func (s *server) evict() {
+	var finished []*job
+	for id, j := range s.jobs {
+		switch {
+		case j.Finished.IsZero():
+		case time.Since(j.Finished) > s.retainFor:
+			delete(s.jobs, id)
+		default:
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= s.retain {
+		return
+	}
+	slices.SortFunc(finished, func(a, b *job) int { return a.Finished.Compare(b.Finished) })
+	for _, j := range finished[:len(finished)-s.retain] {
+		delete(s.jobs, j.ID)
+	}
+}
+
+// Extracts the regular files & directories of a (gzipped) tarball into dir, at most limit bytes
+// in total: the compressed size says little about the extracted one
+func extractTarball(r io.Reader, dir string, limit int64) error {
+	var br = bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	var tr = tar.NewReader(r)
+	var remaining = limit
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%q points outside of the archive", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(f, io.LimitReader(tr, remaining+1))
+			f.Close()
+			if err != nil {
+				return err
+			}
+			if remaining -= n; remaining < 0 {
+				return fmt.Errorf("the extracted files exceed %d bytes", limit)
+			}
+		}
+		// Links & special files are skipped
+	}
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var jobs = make([]job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(jobs, func(a, b job) int { return a.Created.Compare(b.Created) })
+	respond(w, http.StatusOK, jobs)
+}
+
+func (s *server) getJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	var status job
+	if ok {
+		status = *j
+	}
+	s.mu.Unlock()
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+		return
+	}
+	respond(w, http.StatusOK, status)
+}
+
+// Cancels a queued or running job, removes a finished one with its results
+func (s *server) deleteJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	if ok && !j.Finished.IsZero() {
+		delete(s.jobs, j.ID)
+	}
+	s.mu.Unlock()
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+		return
+	}
+	j.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The results of a finished job, writes the error response if there are none
+func (s *server) results(w http.ResponseWriter, r *http.Request) (*metrics.Snapshot, map[string]map[string]float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[r.PathValue("id")]
+	switch {
+	case !ok:
+		respondError(w, http.StatusNotFound, fmt.Errorf("no job %q", r.PathValue("id")))
+	case j.Status != JOB_DONE:
+		respondError(w, http.StatusConflict, fmt.Errorf("job %s is %s", j.ID, j.Status))
+	default:
+		return j.snapshot, j.packages, true
+	}
+	return nil, nil, false
+}
+
+func (s *server) getProject(w http.ResponseWriter, r *http.Request) {
+	if snapshot, _, ok := s.results(w, r); ok {
+		respond(w, http.StatusOK, struct {
+			Project string             `json:"project"`
+			Summary map[string]float64 `json:"summary"`
+		}{snapshot.Project, snapshot.Summary})
+	}
+}
+
+// All packages, or the one of the 'path' query parameter
+func (s *server) getPackages(w http.ResponseWriter, r *http.Request) {
+	_, packages, ok := s.results(w, r)
+	if !ok {
+		return
+	}
+	if p := r.URL.Query().Get("path"); p != "" {
+		values, found := packages[p]
+		if !found {
+			respondError(w, http.StatusNotFound, fmt.Errorf("no package %q", p))
+			return
+		}
+		packages = map[string]map[string]float64{p: values}
+	}
+	respond(w, http.StatusOK, packages)
+}
+
+// All files (without their functions), or the one of the 'path' query parameter
+func (s *server) getFiles(w http.ResponseWriter, r *http.Request) {
+	snapshot, _, ok := s.results(w, r)
+	if !ok {
+		return
+	}
+	var p = r.URL.Query().Get("path")
+	var files = []metrics.FileSnapshot{}
+	for _, fs := range snapshot.Files {
+		if p == "" {
+			fs.Functions = nil
+			files = append(files, fs)
+		} else if fs.Path == p {
+			files = append(files, fs)
+		}
+	}
+	if p != "" && len(files) == 0 {
+		respondError(w, http.StatusNotFound, fmt.Errorf("no file %q", p))
+		return
+	}
+	respond(w, http.StatusOK, files)
+}
+
+// The functions, filtered by the 'file' & 'id' query parameters
+func (s *server) getFunctions(w http.ResponseWriter, r *http.Request) {
+	snapshot, _, ok := s.results(w, r)
+	if !ok {
+		return
+	}
+	type function struct {
+		Path string `json:"path"`
+		metrics.FunctionSnapshot
+	}
+	var file, id = r.URL.Query().Get("file"), r.URL.Query().Get("id")
+	var functions = []function{}
+	for _, fs := range snapshot.Files {
+		if file != "" && fs.Path != file {
+			continue
+		}
+		for _, f := range fs.Functions {
+			if id == "" || f.ID == id {
+				functions = append(functions, function{fs.Path, f})
+			}
+		}
+	}
+	respond(w, http.StatusOK, functions)
+}
+
+// The state of the service in the Prometheus text format
+func (s *server) getMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var byStatus = map[string]int{}
+	for _, j := range s.jobs {
+		byStatus[j.Status]++
+	}
+	var files, seconds = s.filesAnalysed, s.secondsSpent
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP code_stats_jobs Nr of analysis jobs by status.")
+	fmt.Fprintln(w, "# TYPE code_stats_jobs gauge")
+	for _, status := range []string{JOB_QUEUED, JOB_RUNNING, JOB_DONE, JOB_FAILED, JOB_CANCELLED} {
+		fmt.Fprintf(w, "code_stats_jobs{status=%q} %d\n", status, byStatus[status])
+	}
+	fmt.Fprintln(w, "# HELP code_stats_files_analysed_total Nr of files measured by the finished jobs.")
+	fmt.Fprintln(w, "# TYPE code_stats_files_analysed_total counter")
+	fmt.Fprintf(w, "code_stats_files_analysed_total %d\n", files)
+	fmt.Fprintln(w, "# HELP code_stats_analysis_seconds_total Time spent running the finished jobs.")
+	fmt.Fprintln(w, "# TYPE code_stats_analysis_seconds_total counter")
+	fmt.Fprintf(w, "code_stats_analysis_seconds_total %g\n", seconds)
+}
+
+func respond(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	var encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		// The status is sent already, eg.: the client went away
+		fmt.Fprintf(os.Stderr, "respond: %v\n", err)
+	}
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	respond(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	link     string
+}
+
+func tarball(t *testing.T, compressed bool, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	var tw *tar.Writer
+	if compressed {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	for _, e := range entries {
+		var hdr = tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.link, Mode: 0o644, Size: int64(len(e.content))}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarball(t *testing.T) {
+	var file = func(name string, content string) tarEntry {
+		return tarEntry{name: name, typeflag: tar.TypeReg, content: content}
+	}
+	for _, tc := range []struct {
+		name       string
+		entries    []tarEntry
+		limit      int64
+		expected   map[string]string // The extracted files
+		err        string            // Part of the error, none if empty
+		compressed bool
+	}{
+		{
+			name:     "files & directories",
+			entries:  []tarEntry{{name: "p/", typeflag: tar.TypeDir}, file("p/main.go", "package main\n"), file("./q/a.go", "package q\n")},
+			limit:    100,
+			expected: map[string]string{"p/main.go": "package main\n", "q/a.go": "package q\n"},
+		},
+		{
+			name:       "compressed",
+			entries:    []tarEntry{file("main.go", "package main\n")},
+			limit:      100,
+			expected:   map[string]string{"main.go": "package main\n"},
+			compressed: true,
+		},
+		{
+			name:    "parent directory",
+			entries: []tarEntry{file("../evil.go", "package evil\n")},
+			limit:   100,
+			err:     "points outside of the archive",
+		},
+		{
+			name:    "parent directory in the middle",
+			entries: []tarEntry{file("p/../../evil.go", "package evil\n")},
+			limit:   100,
+			err:     "points outside of the archive",
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{file("/tmp/evil.go", "package evil\n")},
+			limit:   100,
+			err:     "points outside of the archive",
+		},
+		{
+			// The links are skipped, a file written through one would end up outside
+			name: "symbolic & hard links",
+			entries: []tarEntry{
+				{name: "out", typeflag: tar.TypeSymlink, link: "/tmp"},
+				{name: "hard.go", typeflag: tar.TypeLink, link: "/etc/passwd"},
+				file("main.go", "package main\n"),
+				file("out/evil.go", "package evil\n"),
+			},
+			limit:    100,
+			expected: map[string]string{"main.go": "package main\n", "out/evil.go": "package evil\n"},
+		},
+		{
+			name:     "at the limit",
+			entries:  []tarEntry{file("a.go", "12345"), file("b.go", "67890")},
+			limit:    10,
+			expected: map[string]string{"a.go": "12345", "b.go": "67890"},
+		},
+		{
+			name:    "over the limit",
+			entries: []tarEntry{file("a.go", "12345"), file("b.go", "678901")},
+			limit:   10,
+			err:     "exceed 10 bytes",
+		},
+		{
+			// Decompressed, the archive is way over the limit
+			name:       "compressed over the limit",
+			entries:    []tarEntry{file("bomb.go", strings.Repeat("0", 1<<20))},
+			limit:      1 << 10,
+			err:        "exceed 1024 bytes",
+			compressed: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var dir = filepath.Join(t.TempDir(), "job")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			var err = extractTarball(bytes.NewReader(tarball(t, tc.compressed, tc.entries...)), dir, tc.limit)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, expected one with %q", err, tc.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			// Nothing is written next to the directory
+			if entries, _ := os.ReadDir(filepath.Dir(dir)); len(entries) != 1 {
+				t.Errorf("found %d entries next to the directory", len(entries))
+			}
+			var extracted = map[string]string{}
+			filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d.Type()&os.ModeSymlink != 0 {
+					t.Errorf("%s is a symbolic link", path)
+				}
+				if d.Type().IsRegular() {
+					content, _ := os.ReadFile(path)
+					rel, _ := filepath.Rel(dir, path)
+					extracted[filepath.ToSlash(rel)] = string(content)
+				}
+				return nil
+			})
+			if tc.err == "" && len(extracted) != len(tc.expected) {
+				t.Errorf("extracted %v, expected %v", extracted, tc.expected)
+			}
+			for name, content := range tc.expected {
+				if extracted[name] != content {
+					t.Errorf("%s: got %q, expected %q", name, extracted[name], content)
+				}
+			}
+		})
+	}
+}
+
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestRespond(t *testing.T) {
+	var rec = httptest.NewRecorder()
+	respond(rec, 201, map[string]string{"id": "1"})
+	if rec.Code != 201 || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != "{\n  \"id\": \"1\"\n}\n" {
+		t.Errorf("got %d %q: %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	// The error of the client is reported, the status is sent already
+	var w = failingWriter{httptest.NewRecorder()}
+	respond(w, 200, []string{"x"})
+	if w.Code != 200 {
+		t.Errorf("got %d", w.Code)
+	}
+}
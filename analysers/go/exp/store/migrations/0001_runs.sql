@@ -0,0 +1,81 @@
+-- One row per run of the analyser
+CREATE TABLE runs (
+    id               INTEGER PRIMARY KEY,
+    created_at       TEXT    NOT NULL, -- RFC 3339, UTC
+    project          TEXT    NOT NULL, -- The name of the analysed directory
+    repository       TEXT    NOT NULL, -- The URL of 'origin', or the top level directory of the repository; empty outside of git
+    directory        TEXT    NOT NULL, -- The analysed directory relative to the repository (or absolute outside of git)
+    git_commit       TEXT    NOT NULL, -- Empty outside of git
+    git_branch       TEXT    NOT NULL, -- Empty for a detached head
+    git_dirty        INTEGER NOT NULL, -- 1 if there were uncommitted changes
+    analyser_version TEXT    NOT NULL,
+    config_hash      TEXT    NOT NULL  -- SHA-256 of the effective configuration
+);
+
+-- The summary of the run, named as the project gate metrics (eg.: cc_p95)
+CREATE TABLE run_metrics (
+    run_id INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+    metric TEXT    NOT NULL,
+    value  REAL    NOT NULL,
+    PRIMARY KEY (run_id, metric)
+);
+
+-- The directories of the analysed files
+CREATE TABLE packages (
+    id     INTEGER PRIMARY KEY,
+    run_id INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+    path   TEXT    NOT NULL, -- Relative to the analysed directory, slash separated
+    UNIQUE (run_id, path)
+);
+
+-- The summary of the package, named as the package gate metrics
+CREATE TABLE package_metrics (
+    package_id INTEGER NOT NULL REFERENCES packages (id) ON DELETE CASCADE,
+    metric     TEXT    NOT NULL,
+    value      REAL    NOT NULL,
+    PRIMARY KEY (package_id, metric)
+);
+
+CREATE TABLE files (
+    id         INTEGER PRIMARY KEY,
+    run_id     INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+    package_id INTEGER NOT NULL REFERENCES packages (id) ON DELETE CASCADE,
+    path       TEXT    NOT NULL, -- Relative to the analysed directory, slash separated
+    UNIQUE (run_id, path)
+);
+
+-- Named as the file gate metrics (eg.: cc_max)
+CREATE TABLE file_metrics (
+    file_id INTEGER NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    metric  TEXT    NOT NULL,
+    value   REAL    NOT NULL,
+    PRIMARY KEY (file_id, metric)
+);
+
+CREATE TABLE functions (
+    id               INTEGER PRIMARY KEY,
+    file_id          INTEGER NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    function_id      TEXT    NOT NULL, -- 'Receiver.Name' or 'Name', unique within the file
+    signature        TEXT    NOT NULL,
+    start_line       INTEGER NOT NULL,
+    end_line         INTEGER NOT NULL,
+    loc              INTEGER NOT NULL,
+    cc               INTEGER NOT NULL,
+    abc              INTEGER NOT NULL,
+    abc_assignments  INTEGER NOT NULL,
+    abc_branches     INTEGER NOT NULL,
+    abc_conditionals INTEGER NOT NULL,
+    halstead_effort  REAL    NOT NULL
+);
+
+CREATE INDEX runs_repository ON runs (repository, directory, created_at);
+CREATE INDEX files_package ON files (package_id);
+CREATE INDEX functions_file ON functions (file_id);
+
+-- The functions with their file, package & run, for the trend queries
+CREATE VIEW function_history AS
+SELECT r.id AS run_id, r.created_at, r.repository, r.directory, r.git_commit,
+       f.path AS file, fn.function_id, fn.signature, fn.loc, fn.cc, fn.abc, fn.halstead_effort
+FROM functions fn
+JOIN files f ON f.id = fn.file_id
+JOIN runs r ON r.id = f.run_id;
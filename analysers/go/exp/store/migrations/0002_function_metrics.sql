@@ -0,0 +1,8 @@
+-- The function metrics beyond the columns of functions: halstead_difficulty and the values of
+-- the function plugins, by name
+CREATE TABLE function_metrics (
+    function_id INTEGER NOT NULL REFERENCES functions (id) ON DELETE CASCADE,
+    metric      TEXT    NOT NULL,
+    value       REAL    NOT NULL,
+    PRIMARY KEY (function_id, metric)
+);
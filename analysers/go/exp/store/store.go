@@ -0,0 +1,249 @@
+// Package store appends the results of the runs to a SQLite database, see migrations/ for
+// the schema.
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// The schema is built by the migrations in order, the version of the database (its
+// user_version) is the nr of migrations applied
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type Store struct {
+	db *sql.DB
+}
+
+// The metadata of a run
+type Run struct {
+	CreatedAt       time.Time
+	Project         string
+	Repository      string
+	Directory       string
+	Commit          string
+	Branch          string
+	Dirty           bool
+	AnalyserVersion string
+	ConfigHash      string
+}
+
+// Opens (creates) the database and migrates it to the latest schema
+func Open(fileName string) (*Store, error) {
+	db, err := sql.Open("sqlite", fileName)
+	if err != nil {
+		return nil, err
+	}
+	// The pragmas are per connection
+	db.SetMaxOpenConns(1)
+	// Concurrent runs wait for each other instead of failing
+	for _, pragma := range []string{"PRAGMA foreign_keys = ON", "PRAGMA busy_timeout = 10000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	var s = Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate %s: %w", fileName, err)
+	}
+	return &s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// The version of the schema
+func (s *Store) Version() (version int, err error) {
+	err = s.db.QueryRow("PRAGMA user_version").Scan(&version)
+	return
+}
+
+// Applies the migrations that are newer than the database, each in its own transaction
+func (s *Store) migrate() error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+	version, err := s.Version()
+	if err != nil {
+		return err
+	}
+	if version > len(names) {
+		return fmt.Errorf("the database has schema version %d, newer than this analyser (%d)", version, len(names))
+	}
+	for i := version; i < len(names); i++ {
+		script, err := migrations.ReadFile(names[i])
+		if err != nil {
+			return err
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", path.Base(names[i]), err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Appends the run with every package, file & function in one transaction, returns the id of
+// the run
+func (s *Store) Save(run Run, snapshot *metrics.Snapshot, packages map[string]map[string]float64) (id int64, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(`INSERT INTO runs (created_at, project, repository, directory, git_commit, git_branch,
+		git_dirty, analyser_version, config_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.CreatedAt.UTC().Format(time.RFC3339), run.Project, run.Repository, run.Directory, run.Commit,
+		run.Branch, run.Dirty, run.AnalyserVersion, run.ConfigHash)
+	if err != nil {
+		return 0, err
+	}
+	if id, err = res.LastInsertId(); err != nil {
+		return 0, err
+	}
+	if err = insertMetrics(tx, "run_metrics", "run_id", id, snapshot.Summary); err != nil {
+		return 0, err
+	}
+
+	var packageIDs = map[string]int64{}
+	var packageID = func(dir string) (int64, error) {
+		if pid, ok := packageIDs[dir]; ok {
+			return pid, nil
+		}
+		res, err := tx.Exec("INSERT INTO packages (run_id, path) VALUES (?, ?)", id, dir)
+		if err != nil {
+			return 0, err
+		}
+		pid, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		packageIDs[dir] = pid
+		return pid, insertMetrics(tx, "package_metrics", "package_id", pid, packages[dir])
+	}
+
+	fi, err := prepareFunctionInserts(tx)
+	if err != nil {
+		return 0, err
+	}
+	defer fi.close()
+	for _, file := range snapshot.Files {
+		pid, err := packageID(path.Dir(file.Path))
+		if err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec("INSERT INTO files (run_id, package_id, path) VALUES (?, ?, ?)", id, pid, file.Path)
+		if err != nil {
+			return 0, err
+		}
+		fid, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		if err := insertMetrics(tx, "file_metrics", "file_id", fid, file.Metrics); err != nil {
+			return 0, err
+		}
+		for i := range file.Functions {
+			if err := fi.insert(fid, &file.Functions[i]); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return id, tx.Commit()
+}
+
+// The statements inserting the functions of a run, prepared once
+type functionInserts struct {
+	function *sql.Stmt
+	metric   *sql.Stmt
+}
+
+func prepareFunctionInserts(tx *sql.Tx) (*functionInserts, error) {
+	function, err := tx.Prepare(`INSERT INTO functions (file_id, function_id, signature, start_line, end_line,
+		loc, cc, abc, abc_assignments, abc_branches, abc_conditionals, halstead_effort)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	metric, err := tx.Prepare("INSERT INTO function_metrics (function_id, metric, value) VALUES (?, ?, ?)")
+	if err != nil {
+		function.Close()
+		return nil, err
+	}
+	return &functionInserts{function: function, metric: metric}, nil
+}
+
+func (fi *functionInserts) close() {
+	fi.function.Close()
+	fi.metric.Close()
+}
+
+// Inserts the function with the metrics that have no column: the Halstead difficulty and the
+// values of the plugins
+func (fi *functionInserts) insert(fileID int64, f *metrics.FunctionSnapshot) error {
+	res, err := fi.function.Exec(fileID, f.ID, f.Signature, f.StartLine, f.EndLine, f.LOC, f.CC, f.ABC,
+		f.Assignments, f.Branches, f.Conditionals, f.HalsteadEffort)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := fi.metric.Exec(id, "halstead_difficulty", f.HalsteadDifficulty); err != nil {
+		return err
+	}
+	for name, value := range f.Metrics {
+		if _, err := fi.metric.Exec(id, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inserts the metric values of one row of the owner table
+func insertMetrics(tx *sql.Tx, table string, key string, id int64, values map[string]float64) error {
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s, metric, value) VALUES (?, ?, ?)", table, key))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for name, value := range values {
+		if _, err := stmt.Exec(id, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+// Runs the metric analyzers as a standalone checker, or as the vet tool:
+//
+//	go build -o code-stats-vet ./exp/vet
+//	go vet -vettool=$(pwd)/code-stats-vet ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/multichecker"
+
+	"github.com/zkulcsar/metrics/exp/analyzers"
+)
+
+func main() {
+	multichecker.Main(analyzers.All...)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/zkulcsar/metrics/exp/cache"
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+	"github.com/zkulcsar/metrics/internal/engine"
+)
+
+// Handles the 'watch' subcommand: re-analyses the modified files on every change and
+// re-renders the report
+func runWatch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	dirname := fs.String("d", "", "Directory containing Go files to watch")
+	configFile := fs.String("config", "", "Configuration file (default: discovered upward from the directory)")
+	nrOfWorkers := fs.Int("w", 0, "Nr of workers (default: from the configuration)")
+	output := fs.String("o", "", "Markdown file to rewrite on every change (default: the terminal)")
+	addr := fs.String("http", "", "Serve a live updating page on this address (eg.: localhost:8080)")
+	debounce := fs.Duration("debounce", 200*time.Millisecond, "Wait this long after a change for more changes")
+	fs.Parse(args)
+
+	if *dirname == "" {
+		fs.Usage()
+		return EXIT_ERROR
+	}
+	cfg, err := loadConfig(*configFile, *dirname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load configuration: %v\n", err)
+		return EXIT_ERROR
+	}
+	if *nrOfWorkers > 0 {
+		cfg.Workers = *nrOfWorkers
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = engine.DefaultWorkers()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+		return EXIT_ERROR
+	}
+	defer watcher.Close()
+	if err := watchDirs(watcher, *dirname, *dirname, &cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+		return EXIT_ERROR
+	}
+	ws, err := newWatchState(*dirname, &cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return EXIT_ERROR
+	}
+	var out = watchOutput{file: *output}
+	if *addr != "" {
+		out.live = serveLive(*addr)
+	}
+	out.publish(ws)
+	return ws.watch(watcher, *debounce, out)
+}
+
+// Re-measures the changed files once there are no more changes for the debounce period, until
+// the watcher is closed or interrupted
+func (ws *watchState) watch(watcher *fsnotify.Watcher, debounce time.Duration, out watchOutput) int {
+	var interrupt = make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	var pending = map[string]bool{}
+	var timer = time.NewTimer(0)
+	<-timer.C
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return EXIT_OK
+			}
+			if ws.changed(watcher, ev, pending) {
+				timer.Reset(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return EXIT_OK
+			}
+			fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+		case <-timer.C:
+			ws.update(slices.Sorted(maps.Keys(pending)))
+			pending = map[string]bool{}
+			out.publish(ws)
+		case <-interrupt:
+			return EXIT_OK
+		}
+	}
+}
+
+// Adds the paths to update after the event to pending, reports whether there are any
+func (ws *watchState) changed(watcher *fsnotify.Watcher, ev fsnotify.Event, pending map[string]bool) bool {
+	if ev.Has(fsnotify.Create) {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			// A new directory: watch it and measure what's already in it
+			if err := watchDirs(watcher, ws.root, ev.Name, ws.cfg); err != nil {
+				fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+			}
+			paths, _ := engine.Collect(ev.Name, ws.cfg, nil)
+			for _, p := range paths {
+				pending[p] = true
+			}
+			return true
+		}
+	}
+	// A removed or renamed directory takes its files along
+	if engine.Measured(ws.root, ev.Name, ws.cfg) || ws.has(ev.Name) ||
+		(ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)) && ws.hasDir(ev.Name) {
+		pending[ev.Name] = true
+		return true
+	}
+	return false
+}
+
+// Where the report goes on every update: a file, the terminal and/or a live page
+type watchOutput struct {
+	file string
+	live *liveReport
+}
+
+func serveLive(addr string) *liveReport {
+	var live = &liveReport{clients: map[chan string]bool{}}
+	go func() {
+		if err := http.ListenAndServe(addr, live); err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+			os.Exit(EXIT_ERROR)
+		}
+	}()
+	return live
+}
+
+func (out watchOutput) publish(ws *watchState) {
+	report, err := ws.render()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "render: %v\n", err)
+		return
+	}
+	switch {
+	case out.file != "":
+		if err := os.WriteFile(out.file, report, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "write report: %v\n", err)
+		}
+	case out.live == nil:
+		// Clear the terminal
+		fmt.Print("\033[H\033[2J")
+		os.Stdout.Write(report)
+	}
+	if out.live != nil {
+		out.live.publish(string(report))
+	}
+}
+
+// Adds dir and its subdirectories to the watcher, fsnotify doesn't watch recursively. The
+// directories the analysis excludes are left out.
+func watchDirs(watcher *fsnotify.Watcher, root string, dir string, cfg *config.Config) error {
+	dirs, err := engine.Dirs(root, dir, cfg)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := watcher.Add(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The metrics of the watched files, updated file by file. Only the summaries of the
+// directories with changed files are recalculated, the one of the project is merged from them.
+type watchState struct {
+	root     string
+	cfg      *config.Config
+	cache    *cache.Cache
+	opts     engine.MeasureOptions
+	files    map[string]watchedFile
+	packages map[string]*watchedPackage // By directory
+	errors   map[string]string          // Files that couldn't be measured, with the error
+	start    metrics.Snapshot           // At the start of the watch
+	updated  time.Time
+}
+
+// A measured file with what it adds to the report, calculated when it changes
+type watchedFile struct {
+	fm       metrics.FileMetric
+	snapshot metrics.FileSnapshot
+	counted  bool // Part of the summaries: not generated, or the generated files are included
+}
+
+type watchedPackage struct {
+	files   map[string]bool
+	summary *metrics.Aggregator // Nil when one of the files changed
+}
+
+func newWatchState(root string, cfg *config.Config) (*watchState, error) {
+	var ws = watchState{
+		root:     root,
+		cfg:      cfg,
+		cache:    openCache(cfg),
+		opts:     engine.NewMeasureOptions(cfg),
+		files:    map[string]watchedFile{},
+		packages: map[string]*watchedPackage{},
+		errors:   map[string]string{},
+		updated:  time.Now(),
+	}
+	ws.opts.Root = root
+	paths, err := engine.Collect(root, cfg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("walk directory %q: %w", root, err)
+	}
+	// The files that don't parse yet are listed as the ones broken during the watch
+	var opts = ws.opts
+	opts.KeepGoing = true
+	fileMetrics, diags, err := engine.MeasureFiles(context.Background(), paths, opts, ws.cache)
+	if err != nil {
+		return nil, fmt.Errorf("parse files: %w", err)
+	}
+	for _, fm := range fileMetrics {
+		ws.set(fm)
+	}
+	for _, d := range diags {
+		if d.Skipped {
+			ws.errors[d.Path] = d.Error()
+		}
+	}
+	var project = ws.summary()
+	var sm = project.Summary()
+	ws.start = ws.snapshot(&sm, project.PluginValues())
+	return &ws, nil
+}
+
+func (ws *watchState) has(path string) bool {
+	_, ok := ws.files[path]
+	return ok
+}
+
+// Whether there are files under the directory
+func (ws *watchState) hasDir(dir string) bool {
+	for pkg := range ws.packages {
+		if under(pkg, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func under(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// Measures the changed files again, forgets the removed ones
+func (ws *watchState) update(paths []string) {
+	for _, path := range paths {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			ws.remove(path)
+			continue
+		}
+		fm, _, err := engine.MeasureFile(path, ws.opts, ws.cache)
+		if err != nil {
+			// Most likely saved in the middle of an edit, the last metrics are kept until it's fixed
+			ws.errors[path] = err.Error()
+			continue
+		}
+		delete(ws.errors, path)
+		ws.set(fm)
+	}
+	ws.updated = time.Now()
+}
+
+// Keeps the measured file, marked as engine.SplitGenerated does
+func (ws *watchState) set(fm metrics.FileMetric) {
+	var fms = []metrics.FileMetric{fm}
+	kept, _ := engine.SplitGenerated(ws.root, ws.cfg, fms)
+	var path = fm.FileName()
+	ws.files[path] = watchedFile{fm: fms[0], snapshot: fms[0].Snapshot(ws.root), counted: len(kept) == 1}
+	var dir = filepath.Dir(path)
+	pkg, ok := ws.packages[dir]
+	if !ok {
+		pkg = &watchedPackage{files: map[string]bool{}}
+		ws.packages[dir] = pkg
+	}
+	pkg.files[path] = true
+	pkg.summary = nil
+}
+
+// Forgets the file, or every file under the directory
+func (ws *watchState) remove(path string) {
+	delete(ws.errors, path)
+	if ws.has(path) {
+		var dir = filepath.Dir(path)
+		delete(ws.files, path)
+		delete(ws.packages[dir].files, path)
+		ws.packages[dir].summary = nil
+		if len(ws.packages[dir].files) == 0 {
+			delete(ws.packages, dir)
+		}
+		return
+	}
+	for dir, pkg := range ws.packages {
+		if under(dir, path) {
+			for file := range pkg.files {
+				delete(ws.files, file)
+			}
+			delete(ws.packages, dir)
+		}
+	}
+	for file := range ws.errors {
+		if under(file, path) {
+			delete(ws.errors, file)
+		}
+	}
+}
+
+// The summary of the project: the directories with changed files are summarised again
+func (ws *watchState) summary() *metrics.Aggregator {
+	var project = metrics.NewAggregator(ws.cfg.Settings, metrics.QUANTILES_EXACT)
+	for _, dir := range slices.Sorted(maps.Keys(ws.packages)) {
+		var pkg = ws.packages[dir]
+		if pkg.summary == nil {
+			pkg.summary = metrics.NewAggregator(ws.cfg.Settings, metrics.QUANTILES_EXACT)
+			for _, path := range slices.Sorted(maps.Keys(pkg.files)) {
+				if wf := ws.files[path]; wf.counted {
+					pkg.summary.Add(&wf.fm)
+				}
+			}
+		}
+		project.Merge(pkg.summary)
+	}
+	return project
+}
+
+// The current results in the snapshot format, from the kept snapshots of the files
+func (ws *watchState) snapshot(sm *metrics.SummaryMetrics, plugins map[string]float64) metrics.Snapshot {
+	var snapshot = metrics.Snapshot{
+		Version:   metrics.SNAPSHOT_VERSION,
+		Project:   filepath.Base(ws.root),
+		CreatedAt: ws.updated.UTC(),
+		Summary:   metrics.SummaryValues(sm, plugins),
+		Files:     make([]metrics.FileSnapshot, 0, len(ws.files)),
+	}
+	for _, path := range slices.Sorted(maps.Keys(ws.files)) {
+		if wf := ws.files[path]; wf.counted {
+			snapshot.Files = append(snapshot.Files, wf.snapshot)
+		}
+	}
+	return snapshot
+}
+
+// The markdown report: the errors, the summary and the changes since the start of the watch
+func (ws *watchState) render() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Watching '%s', updated at %s.\n", ws.root, ws.updated.Format(time.TimeOnly))
+	for _, path := range slices.Sorted(maps.Keys(ws.errors)) {
+		fmt.Fprintf(&buf, "\n> %s\n", ws.errors[path])
+	}
+	var project = ws.summary()
+	var sm = project.Summary()
+	var plugins = project.PluginValues()
+	var current = ws.snapshot(&sm, plugins)
+	var data = newSummaryData(current.Project, ws.root, nil, &sm, nil, ws.cfg)
+	data.Plugins = plugins
+	for _, f := range current.Files {
+		data.Languages[cmp.Or(f.Language, metrics.LANGUAGE_GO)]++
+	}
+	if err := writeMarkdown(&buf, data); err != nil {
+		return nil, err
+	}
+	if err := writeTemplate(&buf, "diff", metrics.Compare(&ws.start, &current)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Serves the report as a page that is updated through server-sent events
+type liveReport struct {
+	mu      sync.Mutex
+	report  string
+	clients map[chan string]bool
+}
+
+func (lr *liveReport) publish(report string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.report = report
+	for c := range lr.clients {
+		select {
+		case c <- report:
+		default:
+			// A slow client only misses intermediate versions
+		}
+	}
+}
+
+func (lr *liveReport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		tmpl, err := template.ParseFiles("exp/templates/watch.html.tmpl")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lr.mu.Lock()
+		var report = lr.report
+		lr.mu.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		tmpl.Execute(w, report)
+	case "/events":
+		lr.events(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Streams every new version of the report as a JSON encoded string
+func (lr *liveReport) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	var c = make(chan string, 1)
+	lr.mu.Lock()
+	lr.clients[c] = true
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, c)
+		lr.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		select {
+		case report := <-c:
+			data, _ := json.Marshal(report)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
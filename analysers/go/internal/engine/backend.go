@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/zkulcsar/metrics/exp/cache"
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// Measures the files of a language. Go is measured natively, the other languages by external
+// analysers (see ExternalBackend); the results of every backend end up in the same report.
+type Backend interface {
+	Language() string
+	// Whether the file is to be measured by the backend
+	Accepts(path string) bool
+	// Measures the files, the results (unless they go to MeasureOptions.Sink) & the diagnostics
+	// (see MeasureOptions.KeepGoing) are sorted by file name. Once ctx is cancelled no new file is started and its error is
+	// returned.
+	Measure(ctx context.Context, paths []string, opts MeasureOptions) ([]metrics.FileMetric, []Diagnostic, error)
+}
+
+// The Go source files, test files excluded
+type goBackend struct {
+	cache *cache.Cache
+}
+
+func (b *goBackend) Language() string {
+	return metrics.LANGUAGE_GO
+}
+
+func (b *goBackend) Accepts(path string) bool {
+	// Skipping over "test" files
+	return filepath.Ext(path) == ".go" && !strings.Contains(path, "_test.go")
+}
+
+func (b *goBackend) Measure(ctx context.Context, paths []string, opts MeasureOptions) ([]metrics.FileMetric, []Diagnostic, error) {
+	return MeasureFiles(ctx, paths, opts, b.cache)
+}
+
+// Measures the files with an external analyser. The command is started once per worker and
+// gets one request per line on its stdin:
+//
+//	{"path": "/abs/dir/file.py", "disabled": ["loc"]}
+//
+// It answers every request, in order, with one line on its stdout:
+//
+//	{"path": "/abs/dir/file.py", "file": {...}}
+//	{"path": "/abs/dir/file.py", "error": "..."}
+//
+// The file is a metrics.ExternalFile, the disabled metric groups can be left out of it. The
+// stderr of the command is passed through. The command exits once its stdin is closed, it's
+// killed when a file times out or the run is cancelled.
+type ExternalBackend struct {
+	language   string
+	extensions []string
+	command    []string
+	dir        string
+}
+
+func NewExternalBackend(b config.Backend) *ExternalBackend {
+	return &ExternalBackend{language: b.Language, extensions: b.Extensions, command: b.Command, dir: b.Dir}
+}
+
+func (b *ExternalBackend) Language() string {
+	return b.language
+}
+
+func (b *ExternalBackend) Accepts(path string) bool {
+	return slices.Contains(b.extensions, filepath.Ext(path))
+}
+
+type externalRequest struct {
+	Path     string   `json:"path"`
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+type externalResponse struct {
+	Path  string                `json:"path"`
+	File  *metrics.ExternalFile `json:"file"`
+	Error string                `json:"error"`
+}
+
+func (b *ExternalBackend) Measure(ctx context.Context, paths []string, opts MeasureOptions) ([]metrics.FileMetric, []Diagnostic, error) {
+	var pool processPool
+	fileMetrics, diags, err := MeasureConcurrently(ctx, paths, opts, func(fctx context.Context, path string) (metrics.FileMetric, []Diagnostic, error) {
+		var p = pool.get()
+		if p == nil {
+			var err error
+			if p, err = b.start(ctx); err != nil {
+				return metrics.FileMetric{}, nil, err
+			}
+		}
+		var stop = context.AfterFunc(fctx, p.kill)
+		fm, err := p.measure(path, opts.Disabled)
+		if !stop() || p.broken {
+			// Killed or out of sync, the next file gets a new process
+			p.close()
+			return fm, nil, err
+		}
+		pool.put(p)
+		return fm, nil, err
+	})
+	if closeErr := pool.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return fileMetrics, diags, nil
+}
+
+// The idle processes of an analyser. Abandoned files (see measureFile) may finish after the
+// run, their processes are closed then.
+type processPool struct {
+	mu     sync.Mutex
+	idle   []*externalProcess
+	closed bool
+}
+
+func (pp *processPool) get() *externalProcess {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if len(pp.idle) == 0 {
+		return nil
+	}
+	var p = pp.idle[len(pp.idle)-1]
+	pp.idle = pp.idle[:len(pp.idle)-1]
+	return p
+}
+
+func (pp *processPool) put(p *externalProcess) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if pp.closed {
+		p.close()
+		return
+	}
+	pp.idle = append(pp.idle, p)
+}
+
+// Closes the idle processes, returns the first error
+func (pp *processPool) close() (err error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.closed = true
+	for _, p := range pp.idle {
+		if closeErr := p.close(); err == nil {
+			err = closeErr
+		}
+	}
+	pp.idle = nil
+	return
+}
+
+type externalProcess struct {
+	backend *ExternalBackend
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Scanner
+	broken  bool // The requests & responses are out of sync
+}
+
+func (b *ExternalBackend) start(ctx context.Context) (*externalProcess, error) {
+	var cmd = exec.CommandContext(ctx, b.command[0], b.command[1:]...)
+	cmd.Dir = b.dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start the %s analyser: %w", b.language, err)
+	}
+	slog.Debug("started analyser", "language", b.language, "pid", cmd.Process.Pid)
+	var scanner = bufio.NewScanner(stdout)
+	// A response holds every function of a file
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return &externalProcess{backend: b, cmd: cmd, stdin: stdin, stdout: scanner}, nil
+}
+
+func (p *externalProcess) measure(path string, disabled []string) (fm metrics.FileMetric, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	request, err := json.Marshal(externalRequest{Path: abs, Disabled: disabled})
+	if err != nil {
+		return
+	}
+	// Until the response is read
+	p.broken = true
+	if _, err = p.stdin.Write(append(request, '\n')); err != nil {
+		return fm, fmt.Errorf("%s analyser: write request: %w", p.backend.language, err)
+	}
+	if !p.stdout.Scan() {
+		err = p.stdout.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return fm, fmt.Errorf("%s analyser: read response for %s: %w", p.backend.language, path, err)
+	}
+	var response externalResponse
+	if err = json.Unmarshal(p.stdout.Bytes(), &response); err != nil {
+		return fm, fmt.Errorf("%s analyser: invalid response for %s: %w", p.backend.language, path, err)
+	}
+	p.broken = response.Path != abs
+	switch {
+	case response.Path != abs:
+		return fm, fmt.Errorf("%s analyser: expected the response for %s, got %q", p.backend.language, abs, response.Path)
+	case response.Error != "":
+		return fm, fmt.Errorf("%s: %s", path, response.Error)
+	case response.File == nil:
+		return fm, fmt.Errorf("%s analyser: no file in the response for %s", p.backend.language, path)
+	}
+	return metrics.NewExternalFileMetric(path, p.backend.language, *response.File, disabled), nil
+}
+
+func (p *externalProcess) kill() {
+	p.cmd.Process.Kill()
+}
+
+// Closes the stdin of the analyser and waits for it to exit
+func (p *externalProcess) close() error {
+	p.stdin.Close()
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s analyser: %w", p.backend.language, err)
+	}
+	return nil
+}
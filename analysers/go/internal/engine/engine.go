@@ -0,0 +1,280 @@
+// Package engine collects the selected files of a directory, measures them concurrently and
+// summarises the results. Go files are measured natively, the other languages by the backends
+// (see Backend).
+//
+// It's shared by the CLI (exp) and the library API (package analyzer), which wraps it with
+// types of its own.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zkulcsar/metrics/exp/cache"
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// The results of a run
+type Report struct {
+	Project  string
+	Root     string                        // The analysed directory
+	Summary  map[string]float64            // Named as the project gate metrics (eg.: cc_p95)
+	Packages map[string]map[string]float64 // By directory relative to Root, named as the package gate metrics
+	Files    []metrics.FileSnapshot        // Sorted by path, relative to Root
+	// The files that were skipped or measured partially (see MeasureOptions), by path
+	Diagnostics []Diagnostic
+	// The files & directories that were not measured, in the order of the walk
+	Excluded []Exclusion
+	// The generated files, they are not in Files unless they are included
+	Generated Generated
+
+	config      config.Config
+	fileMetrics []metrics.FileMetric
+	sm          metrics.SummaryMetrics
+	// Without the files (see config.Streaming)
+	streamed   bool
+	languages  map[string]int
+	plugins    map[string]float64
+	violations []metrics.Violation
+}
+
+// The effective configuration of the run
+func (r *Report) Config() *config.Config {
+	return &r.config
+}
+
+// The raw metrics of the files, sorted by file name. Nil if the run was streamed.
+func (r *Report) FileMetrics() []metrics.FileMetric {
+	return r.fileMetrics
+}
+
+// Whether the files were folded into the summaries as they were measured (see
+// config.Streaming). Then only the summaries, the violations & the diagnostics are kept.
+func (r *Report) Streamed() bool {
+	return r.streamed
+}
+
+// The nr of files measured by language
+func (r *Report) Languages() map[string]int {
+	if r.streamed {
+		return r.languages
+	}
+	var languages = map[string]int{}
+	for i := range r.fileMetrics {
+		languages[r.fileMetrics[i].Language()]++
+	}
+	return languages
+}
+
+// The project summaries of the plugins, see metrics.PluginValues
+func (r *Report) PluginValues() map[string]float64 {
+	if r.streamed {
+		return r.plugins
+	}
+	return metrics.PluginValues(r.fileMetrics)
+}
+
+func (r *Report) SummaryMetrics() *metrics.SummaryMetrics {
+	return &r.sm
+}
+
+// The results in the snapshot format, see metrics.Snapshot. A streamed run has the summary
+// only.
+func (r *Report) Snapshot() metrics.Snapshot {
+	var snapshot = metrics.NewSnapshot(r.Project, r.Root, r.fileMetrics, &r.sm)
+	if r.streamed {
+		snapshot.Summary = maps.Clone(r.Summary)
+	}
+	return snapshot
+}
+
+// Whether files were skipped or measured partially
+func (r *Report) Incomplete() bool {
+	return len(r.Diagnostics) > 0
+}
+
+// Evaluates the thresholds of the gate in the configuration, whether it's enabled or not. A
+// streamed run evaluated them on the way: the function & file violations come first, by file.
+func (r *Report) Violations() []metrics.Violation {
+	if r.streamed {
+		return r.violations
+	}
+	var gc = r.config.GateConfig()
+	return gc.Evaluate(r.Project, r.fileMetrics, &r.sm)
+}
+
+// Sets an option of Run
+type Option func(*options)
+
+type options struct {
+	config   *config.Config
+	workers  int
+	only     func(rel string) bool
+	cache    *cache.Cache
+	cacheSet bool
+	backends []Backend
+	progress func(Progress)
+}
+
+// The configuration to use, instead of the one discovered upward from the directory
+func WithConfig(cfg config.Config) Option {
+	return func(o *options) { o.config = &cfg }
+}
+
+// Overrides the nr of workers of the configuration
+func WithWorkers(n int) Option {
+	return func(o *options) { o.workers = n }
+}
+
+// Restricts the run to the files (relative to the directory) only accepts
+func WithFilter(only func(rel string) bool) Option {
+	return func(o *options) { o.only = only }
+}
+
+// The file metrics cache to use instead of the one of the configuration, nil disables it
+func WithCache(c *cache.Cache) Option {
+	return func(o *options) { o.cache, o.cacheSet = c, true }
+}
+
+// Adds backends of other languages, after the ones of the configuration. A file is measured by
+// the first backend accepting it, Go files always by the native one.
+func WithBackends(backends ...Backend) Option {
+	return func(o *options) { o.backends = append(o.backends, backends...) }
+}
+
+// Reports the progress of the measurements after every file, not concurrently
+func WithProgress(progress func(Progress)) Option {
+	return func(o *options) { o.progress = progress }
+}
+
+// The state of the measurements of a run
+type Progress struct {
+	Done    int    // Nr of files measured or skipped
+	Total   int    // Nr of files to measure
+	Path    string // The last file done
+	Elapsed time.Duration
+}
+
+// Files per second so far
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Done) / p.Elapsed.Seconds()
+}
+
+// The estimated time left at the current rate, 0 if there is no rate yet
+func (p Progress) ETA() time.Duration {
+	var rate = p.Rate()
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Total-p.Done) / rate * float64(time.Second))
+}
+
+// Measures the selected files of dir and calculates the summary. Once ctx is cancelled the walk
+// stops, no new file is started and its error is returned. With config.Streaming enabled the
+// files are not kept, see Report.Streamed. The generated files are only counted, unless
+// config.Generated includes them.
+func Run(ctx context.Context, dir string, opts ...Option) (*Report, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var cfg config.Config
+	if o.config != nil {
+		cfg = *o.config
+	} else {
+		var err error
+		if cfg, _, err = config.ForDir(dir); err != nil {
+			return nil, fmt.Errorf("load configuration: %w", err)
+		}
+	}
+	if o.workers > 0 {
+		cfg.Workers = o.workers
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = DefaultWorkers()
+	}
+	if !o.cacheSet {
+		// A cache that can't be opened only costs time
+		o.cache, _ = OpenCache(&cfg)
+	}
+
+	var backends = []Backend{&goBackend{cache: o.cache}}
+	for _, b := range cfg.Backends {
+		backends = append(backends, NewExternalBackend(b))
+	}
+	backends = append(backends, o.backends...)
+	paths, excluded, err := collect(ctx, dir, &cfg, o.only, backends)
+	if err != nil {
+		return nil, fmt.Errorf("walk directory %q: %w", dir, err)
+	}
+	var measureOpts = NewMeasureOptions(&cfg)
+	measureOpts.Root = dir
+	measureOpts.Progress = progress(paths, o.progress)
+	for i, b := range backends {
+		slog.Debug("collected", "language", b.Language(), "files", len(paths[i]))
+	}
+	if cfg.Streaming.Enabled {
+		return runStreaming(ctx, dir, cfg, measureOpts, backends, paths, excluded)
+	}
+	var fileMetrics = []metrics.FileMetric{}
+	var diags []Diagnostic
+	for i, b := range backends {
+		if len(paths[i]) == 0 {
+			continue
+		}
+		fms, ds, err := b.Measure(ctx, paths[i], measureOpts)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s files: %w", b.Language(), err)
+		}
+		fileMetrics = append(fileMetrics, fms...)
+		diags = append(diags, ds...)
+	}
+	// The sums over the files shouldn't depend on the order of the backends either
+	sort.Slice(fileMetrics, func(i, j int) bool { return fileMetrics[i].FileName() < fileMetrics[j].FileName() })
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Path < diags[j].Path })
+	fileMetrics, generated := SplitGenerated(dir, &cfg, fileMetrics)
+	var sm = metrics.NewSummaryMetrics(cfg.Settings)
+	sm.CalculateMetrics(fileMetrics)
+
+	var r = Report{
+		Project:     filepath.Base(dir),
+		Root:        dir,
+		Packages:    metrics.PackageValues(dir, fileMetrics, cfg.Settings),
+		Diagnostics: diags,
+		Excluded:    excluded,
+		Generated:   generated,
+		config:      cfg,
+		fileMetrics: fileMetrics,
+		sm:          sm,
+	}
+	var snapshot = r.Snapshot()
+	r.Summary, r.Files = snapshot.Summary, snapshot.Files
+	return &r, nil
+}
+
+// Reports the files of every backend as one run, nil if report is. The backends run one after
+// the other, so it's not called concurrently either.
+func progress(paths [][]string, report func(Progress)) func(path string) {
+	if report == nil {
+		return nil
+	}
+	var p Progress
+	for _, ps := range paths {
+		p.Total += len(ps)
+	}
+	var start = time.Now()
+	return func(path string) {
+		p.Done++
+		p.Path, p.Elapsed = path, time.Since(start)
+		report(p)
+	}
+}
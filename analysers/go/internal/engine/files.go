@@ -0,0 +1,311 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/zkulcsar/metrics/exp/cache"
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+const (
+	WORKER_PERCENT float64 = 0.8 // The percentage of the total number of cores to be used
+)
+
+// WORKER_PERCENT of the available cores, at least one
+func DefaultWorkers() int {
+	return max(1, int(math.Floor(float64(runtime.NumCPU())*WORKER_PERCENT)))
+}
+
+// The file metrics cache of the configuration, nil if it's disabled. The entries depend on the
+// registered plugins too.
+func OpenCache(cfg *config.Config) (*cache.Cache, error) {
+	if !cfg.Cache.Enabled {
+		return nil, nil
+	}
+	return cache.Open(cfg.Cache.Dir, metrics.ANALYSER_VERSION, strings.Join(disabledMetrics(cfg), ","),
+		strings.Join(metrics.PluginNames(), ","))
+}
+
+// The metric groups & plugins of the configuration that are not measured, and the LOC metrics
+// if 'cloc' is not installed
+func disabledMetrics(cfg *config.Config) []string {
+	var disabled = cfg.DisabledMetrics()
+	if cfg.Enabled(metrics.METRIC_LOC) && !metrics.ClocAvailable() {
+		disabled = append(disabled, metrics.METRIC_LOC)
+	}
+	return disabled
+}
+
+// How the files are measured
+type MeasureOptions struct {
+	// The analysed directory, the cache keys are the paths relative to it so that a moved or
+	// re-cloned tree (eg.: the worktree of 'history') still hits the cache
+	Root        string
+	Workers     int
+	Disabled    []string      // The metric groups & plugins not to measure
+	FileTimeout time.Duration // The limit per file, none if 0
+	// Record the files that can't be measured and go on with the rest, instead of stopping at
+	// the first one
+	KeepGoing bool
+	// Measure what parsed of the files with syntax errors, implies KeepGoing
+	Tolerant bool
+	// Gets the measured files one at a time, in no particular order, instead of them being
+	// returned. Not called concurrently.
+	Sink func(fm metrics.FileMetric)
+	// Called after every file, measured or not. Not called concurrently.
+	Progress func(path string)
+}
+
+// The options of the configuration
+func NewMeasureOptions(cfg *config.Config) MeasureOptions {
+	return MeasureOptions{
+		Workers:     cfg.Workers,
+		Disabled:    disabledMetrics(cfg),
+		FileTimeout: cfg.FileTimeout,
+		KeepGoing:   cfg.KeepGoing,
+		Tolerant:    cfg.Tolerant,
+	}
+}
+
+// The kinds of problems with a file
+const (
+	DIAGNOSTIC_SYNTAX     string = "syntax"     // Syntax errors
+	DIAGNOSTIC_UNREADABLE string = "unreadable" // The file can't be read
+	DIAGNOSTIC_LOC        string = "loc"        // 'cloc' failed on the file, the LOC metrics are missing
+	DIAGNOSTIC_TIMEOUT    string = "timeout"    // See MeasureOptions.FileTimeout
+	DIAGNOSTIC_FAILED     string = "failed"     // Anything else, eg.: an error of an external analyser
+)
+
+// Returned (wrapped) for the files that took longer than MeasureOptions.FileTimeout
+var ErrTimeout = errors.New("timed out")
+
+// A problem with a file. Skipped files are not measured at all, the metrics of the others are
+// incomplete.
+type Diagnostic struct {
+	Path    string
+	Kind    string // DIAGNOSTIC_*
+	Err     error
+	Skipped bool
+}
+
+func (d Diagnostic) Error() string {
+	return d.Err.Error()
+}
+
+// The diagnostic of a file that couldn't be measured
+func skipped(path string, err error) Diagnostic {
+	var d = Diagnostic{Path: path, Kind: DIAGNOSTIC_FAILED, Err: err, Skipped: true}
+	var syntaxErr scanner.ErrorList
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &syntaxErr):
+		d.Kind = DIAGNOSTIC_SYNTAX
+	case errors.As(err, &pathErr):
+		d.Kind = DIAGNOSTIC_UNREADABLE
+	case errors.Is(err, ErrTimeout):
+		d.Kind = DIAGNOSTIC_TIMEOUT
+	}
+	return d
+}
+
+type parseResult struct {
+	fm    metrics.FileMetric
+	diags []Diagnostic
+	err   error
+}
+
+// Measures the Go files, the ones found in the cache (if not nil) are not parsed again. Once
+// ctx is cancelled no new file is started and its error is returned.
+func MeasureFiles(ctx context.Context, paths []string, opts MeasureOptions, c *cache.Cache) ([]metrics.FileMetric, []Diagnostic, error) {
+	return MeasureConcurrently(ctx, paths, opts, func(_ context.Context, path string) (metrics.FileMetric, []Diagnostic, error) {
+		return MeasureFile(path, opts, c)
+	})
+}
+
+// Runs measure on the paths with opts.Workers workers. The first error cancels the rest,
+// unless opts.KeepGoing (or Tolerant) is set; the results (if there is no opts.Sink) & the
+// diagnostics are sorted by file name.
+func MeasureConcurrently(ctx context.Context, paths []string, opts MeasureOptions,
+	measure func(ctx context.Context, path string) (metrics.FileMetric, []Diagnostic, error)) ([]metrics.FileMetric, []Diagnostic, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var fileMetrics []metrics.FileMetric
+	if opts.Sink == nil {
+		fileMetrics = make([]metrics.FileMetric, 0, len(paths))
+	}
+	var diags []Diagnostic
+	var keepGoing = opts.KeepGoing || opts.Tolerant
+
+	g.Go(func() error {
+		defer close(jobs)
+		for _, p := range paths {
+			select {
+			case jobs <- p:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
+		return nil
+	})
+	for i := 0; i < max(1, min(opts.Workers, len(paths))); i++ {
+		g.Go(func() error {
+			for p := range jobs {
+				fm, fileDiags, err := measureFile(gctx, p, opts.FileTimeout, measure)
+				if err != nil && (!keepGoing || gctx.Err() != nil) {
+					return err
+				}
+				mu.Lock()
+				switch {
+				case err != nil:
+					var d = skipped(p, err)
+					slog.Info("file skipped", "path", p, "kind", d.Kind, "err", err)
+					diags = append(diags, d)
+				case opts.Sink != nil:
+					opts.Sink(fm)
+					diags = append(diags, fileDiags...)
+				default:
+					fileMetrics = append(fileMetrics, fm)
+					diags = append(diags, fileDiags...)
+				}
+				if opts.Progress != nil {
+					opts.Progress(p)
+				}
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	// The workers finish in any order, the sums over the files shouldn't depend on it
+	sort.Slice(fileMetrics, func(i, j int) bool { return fileMetrics[i].FileName() < fileMetrics[j].FileName() })
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Path < diags[j].Path })
+	return fileMetrics, diags, nil
+}
+
+// Measures a file, giving up on it when ctx is done or the timeout (if not 0) expires. The
+// parser can't be interrupted, an abandoned file is finished in the background.
+func measureFile(ctx context.Context, path string, timeout time.Duration,
+	measure func(ctx context.Context, path string) (metrics.FileMetric, []Diagnostic, error)) (metrics.FileMetric, []Diagnostic, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Buffered, so that an abandoned measurement can exit
+	done := make(chan parseResult, 1)
+	go func() {
+		fm, diags, err := measure(ctx, path)
+		done <- parseResult{fm: fm, diags: diags, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.fm, res.diags, res.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return metrics.FileMetric{}, nil, fmt.Errorf("%s: %w after %v", path, ErrTimeout, timeout)
+		}
+		return metrics.FileMetric{}, nil, ctx.Err()
+	}
+}
+
+// Measures a single Go file, through the cache if not nil. The diagnostics are the problems of
+// a file that is measured nevertheless (see MeasureOptions.Tolerant).
+func MeasureFile(filename string, opts MeasureOptions, c *cache.Cache) (fm metrics.FileMetric, diags []Diagnostic, err error) {
+	if c == nil {
+		return parse(filename, nil, opts)
+	}
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var key = c.Key(cacheName(opts.Root, filename), src)
+	if data, ok := c.Get(key); ok && json.Unmarshal(data, &fm) == nil {
+		// The entry may be stored from another copy of the tree
+		fm.Rename(filename)
+		return fm, nil, nil
+	}
+	if fm, diags, err = parse(filename, src, opts); err != nil {
+		return
+	}
+	// Incomplete results are measured again next time, a failing cache only costs time
+	if data, err := json.Marshal(fm); err == nil && len(diags) == 0 {
+		c.Put(key, data)
+	}
+	return fm, diags, nil
+}
+
+// The name of the file in the cache keys: relative to root, slash separated
+func cacheName(root string, filename string) string {
+	if root == "" {
+		return filepath.ToSlash(filename)
+	}
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Measures the file, reads it if src is nil. A failing 'cloc' only leaves the LOC metrics
+// empty, the syntax errors fail the file unless opts.Tolerant is set: then what parsed is
+// measured. Both are returned as diagnostics.
+func parse(filename string, src []byte, opts MeasureOptions) (fm metrics.FileMetric, diags []Diagnostic, err error) {
+	fset := token.NewFileSet()
+	slog.Debug("parse", "file", filename)
+	if src == nil {
+		if src, err = os.ReadFile(filename); err != nil {
+			return
+		}
+	}
+	tree, err := parser.ParseFile(fset, filename, src, parser.AllErrors)
+	var syntaxErr scanner.ErrorList
+	if err != nil && opts.Tolerant && tree != nil && errors.As(err, &syntaxErr) {
+		diags = append(diags, Diagnostic{Path: filename, Kind: DIAGNOSTIC_SYNTAX, Err: err})
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	//ast.Print(fset, tree)
+
+	fm = metrics.NewFileMetric(filename)
+	fm.Disable(opts.Disabled...)
+	fm.SetGenerated(isGenerated(filename, src))
+	if clocErr := fm.GenerateMetrics(fset, tree); clocErr != nil {
+		diags = append(diags, Diagnostic{Path: filename, Kind: DIAGNOSTIC_LOC, Err: fmt.Errorf("%s: cloc: %w", filename, clocErr)})
+	}
+	return
+}
+
+// Whether the file has the standard header of generated code (see ast.IsGenerated). The
+// comments are only parsed up to the package clause, the metrics don't need them.
+func isGenerated(filename string, src []byte) bool {
+	header, err := parser.ParseFile(token.NewFileSet(), filename, src, parser.PackageClauseOnly|parser.ParseComments)
+	return err == nil && ast.IsGenerated(header)
+}
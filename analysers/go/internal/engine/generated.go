@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// The generated code among the measured files (see config.Generated), reported separately
+type Generated struct {
+	Files   []string // Relative to the analysed directory, slash separated, sorted
+	CodeLOC int
+	Share   float64 // Of the code LOC of every measured file
+	// Whether they are measured with the rest of the files, otherwise they are left out of
+	// the summaries, the file results & the gate
+	Included bool
+}
+
+// Keeps the count of the generated files as the measured ones come
+type generatedCounter struct {
+	root     string
+	cfg      *config.Config
+	g        Generated
+	totalLOC int
+}
+
+func newGeneratedCounter(root string, cfg *config.Config) *generatedCounter {
+	return &generatedCounter{root: root, cfg: cfg, g: Generated{Files: []string{}, Included: cfg.Generated.Include}}
+}
+
+// Marks the file if it matches the generated patterns, counts it and reports whether it's to
+// be measured with the rest
+func (gc *generatedCounter) add(fm *metrics.FileMetric) bool {
+	var path = fm.FileName()
+	if rel, err := filepath.Rel(gc.root, path); err == nil {
+		path = rel
+	}
+	path = filepath.ToSlash(path)
+	if !fm.Generated() && gc.cfg.GeneratedPath(path) {
+		fm.SetGenerated(true)
+	}
+	gc.totalLOC += fm.CodeLOC()
+	if !fm.Generated() {
+		return true
+	}
+	gc.g.Files = append(gc.g.Files, path)
+	gc.g.CodeLOC += fm.CodeLOC()
+	return gc.g.Included
+}
+
+func (gc *generatedCounter) result() Generated {
+	sort.Strings(gc.g.Files)
+	if gc.totalLOC > 0 {
+		gc.g.Share = float64(gc.g.CodeLOC) / float64(gc.totalLOC)
+	}
+	return gc.g
+}
+
+// Marks the files of root matching the generated patterns of the configuration and leaves the
+// generated ones out, unless they are included
+func SplitGenerated(root string, cfg *config.Config, fileMetrics []metrics.FileMetric) ([]metrics.FileMetric, Generated) {
+	var gc = newGeneratedCounter(root, cfg)
+	var kept = make([]metrics.FileMetric, 0, len(fileMetrics))
+	for i := range fileMetrics {
+		if gc.add(&fileMetrics[i]) {
+			kept = append(kept, fileMetrics[i])
+		}
+	}
+	return kept, gc.result()
+}
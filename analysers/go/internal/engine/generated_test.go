@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+func TestIsGenerated(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		src       string
+		generated bool
+	}{
+		{"header", "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage p\n", true},
+		{"without a generator", "// Code generated DO NOT EDIT.\n\npackage p\n", false},
+		{"generator without details", "// Code generated by x DO NOT EDIT.\n\npackage p\n", true},
+		{"after a build constraint", "//go:build linux\n\n// Code generated by x. DO NOT EDIT.\n\npackage p\n", true},
+		{"in the package documentation", "// Package p does things.\n//\n// Code generated by x. DO NOT EDIT.\npackage p\n", true},
+		{"syntax error after the package clause", "// Code generated by x. DO NOT EDIT.\n\npackage p\n\nfunc {\n", true},
+		// The near-misses
+		{"after the package clause", "package p\n\n// Code generated by x. DO NOT EDIT.\n", false},
+		{"no trailing period", "// Code generated by x. DO NOT EDIT\n\npackage p\n", false},
+		{"trailing space", "// Code generated by x. DO NOT EDIT. \n\npackage p\n", false},
+		{"lower case", "// code generated by x. DO NOT EDIT.\n\npackage p\n", false},
+		{"not at the start of the line", "// Note: Code generated by x. DO NOT EDIT.\n\npackage p\n", false},
+		{"block comment", "/* Code generated by x. DO NOT EDIT. */\n\npackage p\n", false},
+		{"no package clause", "// Code generated by x. DO NOT EDIT.\n", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isGenerated("p.go", []byte(tc.src)); got != tc.generated {
+				t.Errorf("got %v, expected %v", got, tc.generated)
+			}
+		})
+	}
+}
+
+// A measured file with its nr of code lines, marked generated if it has the header
+func generatedFileMetric(t *testing.T, fileName string, codeLOC int, header bool) metrics.FileMetric {
+	t.Helper()
+	var fm metrics.FileMetric
+	var data = fmt.Sprintf(`{"file_name": %q, "generated": %v, "lines": {"Go": {"code": %d}}}`, fileName, header, codeLOC)
+	if err := json.Unmarshal([]byte(data), &fm); err != nil {
+		t.Fatal(err)
+	}
+	return fm
+}
+
+func TestSplitGenerated(t *testing.T) {
+	var root = filepath.FromSlash("/src/p")
+	var files = func() []metrics.FileMetric {
+		return []metrics.FileMetric{
+			generatedFileMetric(t, filepath.Join(root, "main.go"), 60, false),
+			generatedFileMetric(t, filepath.Join(root, "api", "api.pb.go"), 30, true),
+			generatedFileMetric(t, filepath.Join(root, "mocks", "store.go"), 10, false),
+		}
+	}
+	for _, tc := range []struct {
+		name     string
+		change   func(cfg *config.Config)
+		kept     []string
+		expected Generated
+	}{
+		{
+			name:     "header",
+			kept:     []string{"main.go", "mocks/store.go"},
+			expected: Generated{Files: []string{"api/api.pb.go"}, CodeLOC: 30, Share: 0.3},
+		},
+		{
+			name:     "header & patterns",
+			change:   func(cfg *config.Config) { cfg.Generated.Patterns = []string{"mocks/**"} },
+			kept:     []string{"main.go"},
+			expected: Generated{Files: []string{"api/api.pb.go", "mocks/store.go"}, CodeLOC: 40, Share: 0.4},
+		},
+		{
+			name: "included",
+			change: func(cfg *config.Config) {
+				cfg.Generated.Patterns, cfg.Generated.Include = []string{"store.go"}, true
+			},
+			kept:     []string{"main.go", "api/api.pb.go", "mocks/store.go"},
+			expected: Generated{Files: []string{"api/api.pb.go", "mocks/store.go"}, CodeLOC: 40, Share: 0.4, Included: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg = config.Default()
+			if tc.change != nil {
+				tc.change(&cfg)
+			}
+			kept, generated := SplitGenerated(root, &cfg, files())
+			var names []string
+			for i := range kept {
+				names = append(names, relPaths(t, root, []string{kept[i].FileName()})[0])
+				// Marked for the reports of the files
+				if kept[i].Generated() != slices.Contains(tc.expected.Files, names[i]) {
+					t.Errorf("%s: generated %v", names[i], kept[i].Generated())
+				}
+			}
+			if !reflect.DeepEqual(names, tc.kept) {
+				t.Errorf("kept %v, expected %v", names, tc.kept)
+			}
+			if !reflect.DeepEqual(generated, tc.expected) {
+				t.Errorf("got %+v, expected %+v", generated, tc.expected)
+			}
+		})
+	}
+
+	// Nothing measured
+	if _, generated := SplitGenerated(root, &config.Config{}, nil); generated.Share != 0 || len(generated.Files) != 0 {
+		t.Errorf("got %+v without files", generated)
+	}
+}
@@ -0,0 +1,411 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"github.com/zkulcsar/metrics/exp/config"
+)
+
+// Why a file or a directory is not measured
+const (
+	EXCLUDED_PATTERN    string = "exclude"    // Matches an exclude pattern
+	EXCLUDED_INCLUDE    string = "include"    // Matches none of the include patterns
+	EXCLUDED_GITIGNORE  string = "gitignore"  // Ignored by git
+	EXCLUDED_DEFAULT    string = "default"    // A vendor, testdata, hidden or _ directory
+	EXCLUDED_SYMLINK    string = "symlink"    // Not followed (see config.SymlinkPolicies), or already walked
+	EXCLUDED_UNREADABLE string = "unreadable" // A directory that can't be listed, with MeasureOptions.KeepGoing
+)
+
+// A file, or a directory with everything in it, that is not measured. Only the files one of the
+// backends would measure are listed, not every file of the tree.
+type Exclusion struct {
+	Path   string
+	Reason string // EXCLUDED_*
+	Dir    bool
+}
+
+// Lists the Go files under root to be measured, restricted to the ones (relative to root) only
+// accepts, if not nil
+func Collect(root string, cfg *config.Config, only func(rel string) bool) ([]string, error) {
+	paths, _, err := collect(context.Background(), root, cfg, only, []Backend{&goBackend{}})
+	if err != nil {
+		return nil, err
+	}
+	return paths[0], nil
+}
+
+// Lists the files under root to be measured by each of the backends, a file goes to the first
+// backend accepting it, and the ones that are excluded. The walk stops once ctx is cancelled.
+func collect(ctx context.Context, root string, cfg *config.Config, only func(rel string) bool, backends []Backend) ([][]string, []Exclusion, error) {
+	var s = newSelector(root, cfg)
+	var paths = make([][]string, len(backends))
+	var backend = func(path string) int {
+		return slices.IndexFunc(backends, func(b Backend) bool { return b.Accepts(path) })
+	}
+	var accept = func(path string) bool {
+		if backend(path) < 0 {
+			return false
+		}
+		// Not an exclusion, the rest of the files are simply not asked for
+		rel, err := filepath.Rel(root, path)
+		return err != nil || only == nil || only(rel)
+	}
+	var found = func(path string) {
+		var i = backend(path)
+		paths[i] = append(paths[i], path)
+	}
+	var visited = map[string]bool{}
+	if real, err := filepath.EvalSymlinks(s.abs); err == nil {
+		visited[real] = true
+	}
+	if err := s.walk(ctx, root, visited, accept, found); err != nil {
+		return nil, nil, err
+	}
+	return paths, s.excluded, nil
+}
+
+// Whether the Go file under root is to be measured
+func Measured(root string, path string, cfg *config.Config) bool {
+	// We can't measure but go source code only, the other languages need a backend
+	return (&goBackend{}).Accepts(path) && newSelector(root, cfg).selected(path)
+}
+
+// Lists dir, a directory under root, and the directories under it that are walked, eg.: to be
+// watched. None if dir itself is excluded. The symbolic links are not followed.
+func Dirs(root string, dir string, cfg *config.Config) ([]string, error) {
+	var s = newSelector(root, cfg)
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir || !s.keepGoing {
+				return err
+			}
+			return fs.SkipDir
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && (d.Name() == ".git" || s.dirReason(path) != "") {
+			return fs.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs, err
+}
+
+// Decides which files under root are measured: the include & exclude patterns, the default
+// excludes, what git ignores and the symbolic links, see config.Config
+type selector struct {
+	root      string
+	abs       string // root, absolute
+	cfg       *config.Config
+	keepGoing bool
+	ignores   map[string][]ignoreRule // The rules of the .gitignore files, by absolute directory
+	// The .gitignore files of the repository above root, closest first, then .git/info/exclude
+	outer    []ignoreSource
+	excluded []Exclusion
+}
+
+type ignoreSource struct {
+	dir   string // The patterns are relative to it
+	rules []ignoreRule
+}
+
+// A line of a .gitignore file
+type ignoreRule struct {
+	pattern string // doublestar, relative to the directory of the file
+	negate  bool
+	dirOnly bool
+}
+
+func newSelector(root string, cfg *config.Config) *selector {
+	var s = selector{
+		root:      root,
+		abs:       root,
+		cfg:       cfg,
+		keepGoing: cfg.KeepGoing || cfg.Tolerant,
+		ignores:   map[string][]ignoreRule{},
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		s.abs = abs
+	}
+	if cfg.Gitignore {
+		s.loadOuter()
+	}
+	return &s
+}
+
+// Walks top (root or a followed symbolic link under it), accept tells the files of interest,
+// found gets the ones that are not excluded
+func (s *selector) walk(ctx context.Context, top string, visited map[string]bool, accept func(path string) bool, found func(path string)) error {
+	return filepath.WalkDir(top, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == s.root || !s.keepGoing {
+				return err
+			}
+			s.exclude(path, EXCLUDED_UNREADABLE, true)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch {
+		case path == top:
+			return nil
+		case d.IsDir():
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			if reason := s.dirReason(path); reason != "" {
+				s.exclude(path, reason, true)
+				return fs.SkipDir
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			return s.symlink(ctx, path, visited, accept, found)
+		case d.Type().IsRegular():
+			s.file(path, accept, found)
+		}
+		return nil
+	})
+}
+
+func (s *selector) file(path string, accept func(path string) bool, found func(path string)) {
+	if !accept(path) {
+		return
+	}
+	if reason := s.fileReason(path); reason != "" {
+		s.exclude(path, reason, false)
+		return
+	}
+	found(path)
+}
+
+// Follows the link according to the policy. A directory is walked once, whether it's reached
+// through links or not.
+func (s *selector) symlink(ctx context.Context, path string, visited map[string]bool, accept func(path string) bool, found func(path string)) error {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		slog.Debug("broken symbolic link", "path", path, "err", err)
+		return nil
+	case !info.IsDir() && s.cfg.Symlinks == config.SYMLINKS_SKIP:
+		if accept(path) {
+			s.exclude(path, EXCLUDED_SYMLINK, false)
+		}
+		return nil
+	case !info.IsDir():
+		s.file(path, accept, found)
+		return nil
+	case s.cfg.Symlinks != config.SYMLINKS_FOLLOW:
+		s.exclude(path, EXCLUDED_SYMLINK, true)
+		return nil
+	}
+	if reason := s.dirReason(path); reason != "" {
+		s.exclude(path, reason, true)
+		return nil
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(s.abs, s.rel(path)))
+	if err != nil || overlaps(visited, real) {
+		s.exclude(path, EXCLUDED_SYMLINK, true)
+		return nil
+	}
+	visited[real] = true
+	// WalkDir doesn't follow its root either, unless it's resolved as a directory
+	return s.walk(ctx, path+string(filepath.Separator), visited, accept, found)
+}
+
+// Whether dir is in, or contains, a directory that is walked already
+func overlaps(visited map[string]bool, dir string) bool {
+	for v := range visited {
+		if dir == v || strings.HasPrefix(dir, v+string(filepath.Separator)) || strings.HasPrefix(v, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *selector) exclude(path string, reason string, dir bool) {
+	slog.Debug("excluded", "path", path, "reason", reason, "dir", dir)
+	s.excluded = append(s.excluded, Exclusion{Path: path, Reason: reason, Dir: dir})
+}
+
+// Whether the file is measured, through the directories above it. It's not checked whether
+// they are symbolic links.
+func (s *selector) selected(path string) bool {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		// Not under root, the patterns are all there is
+		return err != nil || s.cfg.Selected(rel)
+	}
+	var dir = s.root
+	for _, name := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if name == "." {
+			break
+		}
+		dir = filepath.Join(dir, name)
+		if name == ".git" || s.dirReason(dir) != "" {
+			return false
+		}
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 && s.cfg.Symlinks == config.SYMLINKS_SKIP {
+		return false
+	}
+	return s.fileReason(path) == ""
+}
+
+// Why the directory under root is excluded, empty if it's not
+func (s *selector) dirReason(path string) string {
+	var name = filepath.Base(path)
+	switch {
+	case s.cfg.DefaultExcludes && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")):
+		return EXCLUDED_DEFAULT
+	case s.cfg.Excluded(s.rel(path)):
+		return EXCLUDED_PATTERN
+	case s.cfg.Gitignore && s.ignored(path, true):
+		return EXCLUDED_GITIGNORE
+	}
+	return ""
+}
+
+// Why the file under root is excluded, empty if it's not
+func (s *selector) fileReason(path string) string {
+	var rel = s.rel(path)
+	switch {
+	case s.cfg.Excluded(rel):
+		return EXCLUDED_PATTERN
+	case s.cfg.Gitignore && s.ignored(path, false):
+		return EXCLUDED_GITIGNORE
+	case !s.cfg.Included(rel):
+		return EXCLUDED_INCLUDE
+	}
+	return ""
+}
+
+func (s *selector) rel(path string) string {
+	if rel, err := filepath.Rel(s.root, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// Whether git ignores the path under root. The closest .gitignore that has a matching rule
+// decides, .git/info/exclude comes last.
+func (s *selector) ignored(path string, dir bool) bool {
+	var abs = filepath.Join(s.abs, s.rel(path))
+	for d := filepath.Dir(abs); ; d = filepath.Dir(d) {
+		if ignored, ok := matchRules(s.rules(d), d, abs, dir); ok {
+			return ignored
+		}
+		if d == s.abs || d == filepath.Dir(d) {
+			break
+		}
+	}
+	for _, src := range s.outer {
+		if ignored, ok := matchRules(src.rules, src.dir, abs, dir); ok {
+			return ignored
+		}
+	}
+	return false
+}
+
+// The rules of the .gitignore in dir, read once
+func (s *selector) rules(dir string) []ignoreRule {
+	rules, ok := s.ignores[dir]
+	if !ok {
+		rules = readIgnoreFile(filepath.Join(dir, ".gitignore"))
+		s.ignores[dir] = rules
+	}
+	return rules
+}
+
+// Collects the .gitignore files between root and the top of its repository, and the
+// .git/info/exclude of the repository. Outside of a repository only the .gitignore files under
+// root count.
+func (s *selector) loadOuter() {
+	for dir := s.abs; ; {
+		if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			if info.IsDir() {
+				s.outer = append(s.outer, ignoreSource{dir, readIgnoreFile(filepath.Join(dir, ".git", "info", "exclude"))})
+			}
+			return
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			s.outer = nil
+			return
+		}
+		dir = parent
+		s.outer = append(s.outer, ignoreSource{dir, s.rules(dir)})
+	}
+}
+
+// The last matching rule wins, ok is false if none of them matches
+func matchRules(rules []ignoreRule, dir string, abs string, isDir bool) (ignored bool, ok bool) {
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return false, false
+	}
+	rel = filepath.ToSlash(rel)
+	for i := len(rules) - 1; i >= 0; i-- {
+		var r = rules[i]
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if doublestar.MatchUnvalidated(r.pattern, rel) {
+			return !r.negate, true
+		}
+	}
+	return false, false
+}
+
+// The rules of a .gitignore file, none if it can't be read
+func readIgnoreFile(fileName string) (rules []ignoreRule) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// A .gitignore line: a pattern without a '/' (but a trailing one) matches at any depth, the
+// others are relative to the directory of the file
+func parseIgnoreRule(line string) (r ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return r, false
+	}
+	if strings.HasPrefix(line, "!") {
+		r.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	if line == "" || !doublestar.ValidatePattern(line) {
+		return r, false
+	}
+	if strings.Contains(line, "/") {
+		r.pattern = strings.TrimPrefix(line, "/")
+	} else {
+		r.pattern = "**/" + line
+	}
+	return r, true
+}
@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/zkulcsar/metrics/exp/config"
+)
+
+// Creates the files under root, the ones ending with / are directories
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		var path = filepath.Join(root, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The paths relative to root, slash separated
+func relPaths(t *testing.T, root string, paths []string) []string {
+	t.Helper()
+	var rels = []string{}
+	for _, p := range paths {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	return rels
+}
+
+func TestDirs(t *testing.T) {
+	var root = t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":     "build/\n",
+		"a/b/":           "",
+		"build/x/":       "",
+		"gen/":           "",
+		"vendor/m/":      "",
+		".cache/":        "",
+		"_old/":          "",
+		"a/testdata/":    "",
+		"a/.gitignore":   "tmp\n",
+		"a/tmp/":         "",
+		"a/b/c/":         "",
+		"a/b/c/file.txt": "",
+	})
+	var cfg = config.Default()
+	cfg.Exclude = []string{"gen/**"}
+
+	dirs, err := Dirs(root, root, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = []string{".", "a", "a/b", "a/b/c"}
+	if got := relPaths(t, root, dirs); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	// A directory created under root, eg.: during a watch
+	dirs, err = Dirs(root, filepath.Join(root, "a", "b"), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relPaths(t, root, dirs); !reflect.DeepEqual(got, []string{"a/b", "a/b/c"}) {
+		t.Errorf("got %v under a/b", got)
+	}
+	dirs, err = Dirs(root, filepath.Join(root, "a", "tmp"), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 0 {
+		t.Errorf("got %v under the ignored a/tmp", dirs)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    map[string]string
+		links    map[string]string // Symbolic links to the targets, relative to the link; "../out" is outside of root
+		change   func(cfg *config.Config)
+		expected []string          // The measured files
+		excluded map[string]string // The reason, the directories end with /
+	}{
+		{
+			name:     "gitignore negation",
+			files:    map[string]string{".gitignore": "*.pb.go\n!keep.pb.go\n", "a.go": "", "x.pb.go": "", "sub/y.pb.go": "", "sub/keep.pb.go": ""},
+			expected: []string{"a.go", "sub/keep.pb.go"},
+			excluded: map[string]string{"sub/y.pb.go": EXCLUDED_GITIGNORE, "x.pb.go": EXCLUDED_GITIGNORE},
+		},
+		{
+			name:     "gitignore directory patterns",
+			files:    map[string]string{".gitignore": "build/\nold.go/\n/root.go\n", "build/a.go": "", "lib/build/b.go": "", "old.go": "", "root.go": "", "lib/root.go": ""},
+			expected: []string{"lib/root.go", "old.go"},
+			excluded: map[string]string{"build/": EXCLUDED_GITIGNORE, "lib/build/": EXCLUDED_GITIGNORE, "root.go": EXCLUDED_GITIGNORE},
+		},
+		{
+			name: "nested gitignore",
+			files: map[string]string{
+				".gitignore": "*.gen.go\n", "a.gen.go": "", "local.go": "",
+				"sub/.gitignore": "!x.gen.go\nlocal.go\n", "sub/x.gen.go": "", "sub/y.gen.go": "", "sub/local.go": "",
+			},
+			expected: []string{"local.go", "sub/x.gen.go"},
+			excluded: map[string]string{"a.gen.go": EXCLUDED_GITIGNORE, "sub/local.go": EXCLUDED_GITIGNORE, "sub/y.gen.go": EXCLUDED_GITIGNORE},
+		},
+		{
+			name:     "gitignore disabled",
+			files:    map[string]string{".gitignore": "*.pb.go\nbuild/\n", "x.pb.go": "", "build/a.go": ""},
+			change:   func(cfg *config.Config) { cfg.Gitignore = false },
+			expected: []string{"build/a.go", "x.pb.go"},
+		},
+		{
+			name:     "default excludes",
+			files:    map[string]string{"main.go": "", "vendor/v.go": "", "a/vendor/v.go": "", "testdata/t.go": "", ".hidden/h.go": "", "_old/o.go": "", "a/b.go": ""},
+			expected: []string{"a/b.go", "main.go"},
+			excluded: map[string]string{
+				".hidden/": EXCLUDED_DEFAULT, "_old/": EXCLUDED_DEFAULT, "a/vendor/": EXCLUDED_DEFAULT, "testdata/": EXCLUDED_DEFAULT, "vendor/": EXCLUDED_DEFAULT,
+			},
+		},
+		{
+			name:     "default excludes disabled",
+			files:    map[string]string{"main.go": "", "vendor/v.go": "", "_old/o.go": "", ".git/x.go": ""},
+			change:   func(cfg *config.Config) { cfg.DefaultExcludes = false },
+			expected: []string{"_old/o.go", "main.go", "vendor/v.go"},
+		},
+		{
+			name:  "exclude & include patterns",
+			files: map[string]string{"main.go": "", "pkg/a.go": "", "pkg/gen/g.go": "", "pkg/a_mock.go": ""},
+			change: func(cfg *config.Config) {
+				cfg.Include, cfg.Exclude = []string{"pkg/**"}, []string{"pkg/gen", "**/*_mock.go"}
+			},
+			expected: []string{"pkg/a.go"},
+			excluded: map[string]string{"main.go": EXCLUDED_INCLUDE, "pkg/a_mock.go": EXCLUDED_PATTERN, "pkg/gen/": EXCLUDED_PATTERN},
+		},
+		{
+			name:     "symlinks skipped",
+			files:    map[string]string{"real/r.go": ""},
+			links:    map[string]string{"link.go": "real/r.go", "ext": "../out", "broken.go": "nowhere.go"},
+			change:   func(cfg *config.Config) { cfg.Symlinks = config.SYMLINKS_SKIP },
+			expected: []string{"real/r.go"},
+			excluded: map[string]string{"ext/": EXCLUDED_SYMLINK, "link.go": EXCLUDED_SYMLINK},
+		},
+		{
+			name:     "symlinked files",
+			files:    map[string]string{"real/r.go": ""},
+			links:    map[string]string{"link.go": "real/r.go", "ext": "../out", "broken.go": "nowhere.go"},
+			expected: []string{"link.go", "real/r.go"},
+			excluded: map[string]string{"ext/": EXCLUDED_SYMLINK},
+		},
+		{
+			name:     "symlinks followed",
+			files:    map[string]string{"real/r.go": ""},
+			links:    map[string]string{"link.go": "real/r.go", "ext": "../out", "more": "../out", "inner": "real"},
+			change:   func(cfg *config.Config) { cfg.Symlinks = config.SYMLINKS_FOLLOW },
+			expected: []string{"ext/e.go", "link.go", "real/r.go"},
+			// Walked already, through the root or ext
+			excluded: map[string]string{"ext/back/": EXCLUDED_SYMLINK, "ext/root/": EXCLUDED_SYMLINK, "inner/": EXCLUDED_SYMLINK, "more/": EXCLUDED_SYMLINK},
+		},
+		{
+			name:     "symlink loops",
+			files:    map[string]string{"a.go": ""},
+			links:    map[string]string{"ext": "../out", "self": ".", "loop/up": ".."},
+			change:   func(cfg *config.Config) { cfg.Symlinks = config.SYMLINKS_FOLLOW },
+			expected: []string{"a.go", "ext/e.go"},
+			excluded: map[string]string{"ext/back/": EXCLUDED_SYMLINK, "ext/root/": EXCLUDED_SYMLINK, "loop/up/": EXCLUDED_SYMLINK, "self/": EXCLUDED_SYMLINK},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var base = t.TempDir()
+			var root = filepath.Join(base, "root")
+			writeTree(t, root, tc.files)
+			// Outside of root: a file, a loop & a link back to root
+			writeTree(t, base, map[string]string{"out/e.go": ""})
+			for link, target := range map[string]string{"out/back": ".", "out/root": "../root"} {
+				if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+					t.Skip("no symbolic links:", err)
+				}
+			}
+			for link, target := range tc.links {
+				var path = filepath.Join(root, filepath.FromSlash(link))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(filepath.FromSlash(target), path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var cfg = config.Default()
+			if tc.change != nil {
+				tc.change(&cfg)
+			}
+
+			paths, excluded, err := collect(t.Context(), root, &cfg, nil, []Backend{&goBackend{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got = relPaths(t, root, paths[0])
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("measured %v, expected %v", got, tc.expected)
+			}
+			var reasons = map[string]string{}
+			for _, e := range excluded {
+				var rel = relPaths(t, root, []string{e.Path})[0]
+				if e.Dir {
+					rel += "/"
+				}
+				reasons[rel] = e.Reason
+			}
+			if tc.excluded == nil {
+				tc.excluded = map[string]string{}
+			}
+			if !reflect.DeepEqual(reasons, tc.excluded) {
+				t.Errorf("excluded %v, expected %v", reasons, tc.excluded)
+			}
+
+			// A file is measured on its own the same way as in the walk
+			for _, e := range excluded {
+				if !e.Dir && Measured(root, e.Path, &cfg) {
+					t.Errorf("%s is excluded (%s), but measured", e.Path, e.Reason)
+				}
+			}
+			for _, p := range paths[0] {
+				// Not the ones in a followed directory, Measured doesn't resolve the links above the file
+				if rel := relPaths(t, root, []string{p})[0]; !strings.Contains(rel, "/") || !isLink(t, root, rel) {
+					if !Measured(root, p, &cfg) {
+						t.Errorf("%s is measured, but not on its own", rel)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Whether the first directory of rel is a symbolic link
+func isLink(t *testing.T, root string, rel string) bool {
+	t.Helper()
+	info, err := os.Lstat(filepath.Join(root, strings.SplitN(rel, "/", 2)[0]))
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+func TestMeasured(t *testing.T) {
+	var root = t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore": "ignored/\n*.pb.go\n",
+		"main.go":    "", "README.md": "", "main_test.go": "",
+		"ignored/a.go": "", "vendor/v.go": "", "gen/g.go": "", ".git/x.go": "", "x.pb.go": "",
+	})
+	var cfg = config.Default()
+	cfg.Exclude = []string{"gen/**"}
+	for _, tc := range []struct {
+		path     string
+		measured bool
+	}{
+		{"main.go", true},
+		{"new.go", true}, // Doesn't exist yet
+		{"README.md", false},
+		{"main_test.go", false},
+		{"ignored/a.go", false},
+		{"vendor/v.go", false},
+		{"gen/g.go", false},
+		{".git/x.go", false},
+		{"x.pb.go", false},
+	} {
+		if got := Measured(root, filepath.Join(root, filepath.FromSlash(tc.path)), &cfg); got != tc.measured {
+			t.Errorf("%s: measured %v, expected %v", tc.path, got, tc.measured)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/zkulcsar/metrics/exp/config"
+	"github.com/zkulcsar/metrics/exp/metrics"
+)
+
+// Measures the files like Run, but folds each of them into the project & package aggregators
+// as soon as it's measured; the function & file thresholds are evaluated on the way. The
+// package collectors of the plugins need every file of a package, they are not run.
+func runStreaming(ctx context.Context, dir string, cfg config.Config, opts MeasureOptions, backends []Backend, paths [][]string, excluded []Exclusion) (*Report, error) {
+	var gc = cfg.GateConfig()
+	var project = metrics.NewAggregator(cfg.Settings, cfg.Streaming.Quantiles)
+	var packages = map[string]*metrics.Aggregator{}
+	var violations = map[string][]metrics.Violation{}
+	var r = Report{
+		Project:   filepath.Base(dir),
+		Root:      dir,
+		Files:     []metrics.FileSnapshot{},
+		Excluded:  excluded,
+		config:    cfg,
+		streamed:  true,
+		languages: map[string]int{},
+	}
+
+	var generated = newGeneratedCounter(dir, &cfg)
+	opts.Sink = func(fm metrics.FileMetric) {
+		if !generated.add(&fm) {
+			return
+		}
+		project.Add(&fm)
+		var pkg = filepath.Dir(fm.FileName())
+		if packages[pkg] == nil {
+			packages[pkg] = metrics.NewAggregator(cfg.Settings, cfg.Streaming.Quantiles)
+		}
+		packages[pkg].Add(&fm)
+		r.languages[fm.Language()]++
+		if vs := gc.EvaluateFile(&fm); len(vs) > 0 {
+			violations[fm.FileName()] = vs
+		}
+	}
+	for i, b := range backends {
+		if len(paths[i]) == 0 {
+			continue
+		}
+		_, ds, err := b.Measure(ctx, paths[i], opts)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s files: %w", b.Language(), err)
+		}
+		r.Diagnostics = append(r.Diagnostics, ds...)
+	}
+	sort.SliceStable(r.Diagnostics, func(i, j int) bool { return r.Diagnostics[i].Path < r.Diagnostics[j].Path })
+	r.Generated = generated.result()
+
+	r.sm = project.Summary()
+	r.plugins = project.PluginValues()
+	r.Summary = metrics.SummaryValues(&r.sm, r.plugins)
+	// The gate names the packages by directory, the report relative to dir
+	var byDir = make(map[string]map[string]float64, len(packages))
+	r.Packages = make(map[string]map[string]float64, len(packages))
+	for pkg, a := range packages {
+		var psm = a.Summary()
+		var values = metrics.SummaryValues(&psm, a.PluginValues())
+		byDir[pkg] = values
+		if rel, err := filepath.Rel(dir, pkg); err == nil {
+			pkg = rel
+		}
+		r.Packages[filepath.ToSlash(pkg)] = values
+	}
+
+	// The files came in any order
+	var files = make([]string, 0, len(violations))
+	for file := range violations {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		r.violations = append(r.violations, violations[file]...)
+	}
+	r.violations = append(r.violations, gc.EvaluateSummaries(r.Project, r.Summary, byDir)...)
+	return &r, nil
+}
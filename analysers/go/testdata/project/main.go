@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+
+	"example.com/project/pkg"
+)
+
+func main() {
+	fmt.Println(pkg.Classify(3))
+}
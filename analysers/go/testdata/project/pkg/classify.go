@@ -0,0 +1,30 @@
+package pkg
+
+// Classify names the size of n
+func Classify(n int) string {
+	switch {
+	case n < 0:
+		return "negative"
+	case n == 0:
+		return "zero"
+	case n < 10 && n%2 == 0:
+		return "small even"
+	case n < 10:
+		return "small"
+	}
+	return "large"
+}
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Add(d int) {
+	if d > 0 {
+		c.n += d
+	}
+}
+
+func (c *Counter) Value() int {
+	return c.n
+}
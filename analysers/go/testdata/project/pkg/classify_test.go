@@ -0,0 +1,9 @@
+package pkg
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	if Classify(0) != "zero" {
+		t.Fail()
+	}
+}
@@ -1,6 +1,8 @@
 from argparse import ArgumentParser
 from metrics import FileMetrics
+import json
 import logging
+import sys
 
 
 def main():
@@ -9,9 +11,13 @@ def main():
         "-f",
         dest="filename",
         type=str,
-        required=True,
         help="Python source file to analyse",
     )
+    parser.add_argument(
+        "--jsonl",
+        action="store_true",
+        help="Serve the JSON-lines requests of the Go analyser on stdin/stdout instead",
+    )
     parser.add_argument(
         "--log",
         dest="loglevel",
@@ -20,6 +26,8 @@ def main():
         help="The log level to set, one of DEBUG, INFO, WARNING, ERROR",
     )
     args = parser.parse_args()
+    if args.filename is None and not args.jsonl:
+        parser.error("either -f or --jsonl is required")
     # set the log level
     log_level = getattr(logging, args.loglevel.upper(), None)
     if not isinstance(log_level, int):
@@ -29,12 +37,38 @@ def main():
         format="%(asctime)s, %(levelname)s, %(module)s/%(funcName)s:%(lineno)d: %(message)s",
     )
 
+    if args.jsonl:
+        serve_jsonl()
+        return
+
     fms = FileMetrics(args.filename)
     fms.generate_metrics()
 
     print_metrics(fms)
 
 
+def serve_jsonl():
+    """Answers the requests of the Go analyser (one JSON per line) until stdin is closed
+
+    Request:  {"path": "/abs/file.py", "disabled": ["loc"]}
+    Response: {"path": "/abs/file.py", "file": {...}} or {"path": "/abs/file.py", "error": "..."}
+    """
+    for line in sys.stdin:
+        if not line.strip():
+            continue
+        request = json.loads(line)
+        path = request["path"]
+        disabled = frozenset(request.get("disabled") or [])
+        try:
+            fms = FileMetrics(path)
+            fms.generate_metrics(disabled)
+            response = {"path": path, "file": fms.to_dict(disabled)}
+        except Exception as e:
+            logging.exception(f"measure {path}")
+            response = {"path": path, "error": f"{type(e).__name__}: {e}"}
+        print(json.dumps(response), flush=True)
+
+
 def print_metrics(fms: FileMetrics):
     print(f"File metrics: {fms}")
 
@@ -41,8 +41,9 @@ class Sum:
 @dataclass(frozen=True)
 class FileClocStat:
     header: Header
-    Python: Python
     SUM: Sum
+    # 'cloc' leaves out the files without lines (eg.: an empty __init__.py)
+    Python: "Python | None" = None
 
 
 def _file_cloc(file_name: str) -> FileClocStat:
@@ -2,6 +2,8 @@ import ast
 from collections import Counter
 from logging import getLogger
 from .cloc import _file_cloc
+from .function import FunctionMetrics
+from .halstead import HalsteadMetrics
 
 log = getLogger(__name__)
 
@@ -13,7 +15,7 @@ class FileMetrics(ast.NodeVisitor):
         self.filename = filename
         self.fileabcmetric = None
         self.abcmetrics = None  #   []ABCMetric
-        self.filehalstead = None  # HalsteadMetric
+        self.filehalstead = HalsteadMetrics()
         self.cyclocmetric = None  # []CyclomaticComplexityMetric
         # Basic file metrics
         self.nrofimports = 0
@@ -21,10 +23,12 @@ class FileMetrics(ast.NodeVisitor):
         self.nroffunctiondeclarations = 0
         self.nrOflines = None  #                FileClocStat
         self.classses = Counter()  #              int
+        self.functions = []  # [FunctionMetrics] of the functions & methods
         # Formatting
         self.tabs = -1
 
-    def generate_metrics(self):
+    def generate_metrics(self, disabled: frozenset[str] = frozenset()):
+        """Measures the file, except the disabled metric groups ('loc', 'halstead')"""
         file = None
         try:
             # TODO: open file
@@ -33,6 +37,8 @@ class FileMetrics(ast.NodeVisitor):
                 file.read(), filename=self.filename
             )  # , mode='exec', type_comments=False, feature_version=None
             self.visit(root)
+            if "halstead" not in disabled:
+                self.filehalstead.visit(root)
         finally:
             # TODO: close the file
             if file != None:
@@ -40,7 +46,8 @@ class FileMetrics(ast.NodeVisitor):
 
         # set the metrics
         self.nrofimports = len(self.imports)
-        self.nrOflines = _file_cloc(self.filename)
+        if "loc" not in disabled:
+            self.nrOflines = _file_cloc(self.filename)
 
     def visit_Import(self, node: ast.Import):
         self._count_imports(node)
@@ -55,14 +62,19 @@ class FileMetrics(ast.NodeVisitor):
             match type(ch):
                 case ast.FunctionDef:
                     self.classses[node.name] += 1
+                    self.functions.append(FunctionMetrics(ch, node.name))
+                case ast.AsyncFunctionDef:
+                    self.functions.append(FunctionMetrics(ch, node.name))
                 case ast.Lambda:
                     self.classses[node.name] += 1
 
     def visit_FunctionDef(self, node: ast.FunctionDef):
         self.nroffunctiondeclarations += 1
+        self.functions.append(FunctionMetrics(node))
 
     def visit_AsyncFunctionDef(self, node: ast.AsyncFunctionDef):
         self.nroffunctiondeclarations += 1
+        self.functions.append(FunctionMetrics(node))
 
     def visit_Lambda(self, node: ast.Lambda):
         self.nroffunctiondeclarations
@@ -98,6 +110,25 @@ class FileMetrics(ast.NodeVisitor):
         self.tabs -= 1
         return ret
 
+    def to_dict(self, disabled: frozenset[str] = frozenset()) -> dict:
+        """The file of the JSON-lines responses, see metrics.ExternalFile of the Go analyser"""
+        halstead = "halstead" not in disabled
+        result = {
+            "imports": sorted(self.imports),
+            "structs": self._nr_of_classes(),
+            "operators": self.filehalstead.operators if halstead else {},
+            "operands": self.filehalstead.operands if halstead else {},
+            "functions": [f.to_dict(halstead) for f in self.functions],
+        }
+        if self.nrOflines is not None:
+            lines = self.nrOflines.Python
+            result["lines"] = {
+                "code": lines.code if lines else 0,
+                "comment": lines.comment if lines else 0,
+                "blank": lines.blank if lines else 0,
+            }
+        return result
+
     def __str__(self) -> str:
         return (
             f"File,"
@@ -0,0 +1,81 @@
+import ast
+from .halstead import HalsteadMetrics
+
+# The decision points of the Cyclomatic Complexity, the boolean operators are counted by operand
+_DECISIONS = (
+    ast.If,
+    ast.IfExp,
+    ast.For,
+    ast.AsyncFor,
+    ast.While,
+    ast.comprehension,
+    ast.ExceptHandler,
+    ast.match_case,
+)
+
+_ASSIGNMENTS = (ast.Assign, ast.AugAssign, ast.AnnAssign, ast.NamedExpr)
+
+
+class FunctionMetrics:
+    """CC, ABC & Halstead of a function, as the Go analyser measures them
+
+    - CC: the decision points (if, loops, except, case, boolean operators), without the +1
+    - ABC: assignments, calls & conditionals (if/else, case, except, comparisons)
+    """
+
+    def __init__(self, node: ast.FunctionDef | ast.AsyncFunctionDef, class_name: str | None = None) -> None:
+        self.id = node.name if class_name is None else f"{class_name}.{node.name}"
+        self.signature = _signature(node)
+        self.start_line = node.lineno
+        self.end_line = node.end_lineno or node.lineno
+        self.cc = 0
+        self.assignments = 0
+        self.branches = 0
+        self.conditionals = 0
+        self.halstead = HalsteadMetrics()
+        self._measure(node)
+
+    def _measure(self, node: ast.AST):
+        for n in ast.walk(node):
+            if isinstance(n, _DECISIONS):
+                self.cc += 1
+            if isinstance(n, _ASSIGNMENTS):
+                self.assignments += 1
+            match n:
+                case ast.BoolOp(values=values):
+                    self.cc += len(values) - 1
+                case ast.Call():
+                    self.branches += 1
+                case ast.If(orelse=orelse):
+                    self.conditionals += 2 if orelse else 1
+                case ast.match_case() | ast.ExceptHandler():
+                    self.conditionals += 1
+                case ast.Compare(ops=ops):
+                    self.conditionals += len(ops)
+        self.halstead.visit(node)
+
+    def to_dict(self, halstead: bool = True) -> dict:
+        """The function of the JSON-lines responses, see metrics.ExternalFunction of the Go analyser"""
+        return {
+            "id": self.id,
+            "signature": self.signature,
+            "start_line": self.start_line,
+            "end_line": self.end_line,
+            "cc": self.cc,
+            "assignments": self.assignments,
+            "branches": self.branches,
+            "conditionals": self.conditionals,
+            "operators": self.halstead.operators if halstead else {},
+            "operands": self.halstead.operands if halstead else {},
+        }
+
+
+def _signature(node: ast.FunctionDef | ast.AsyncFunctionDef) -> str:
+    args = node.args
+    names = [a.arg for a in args.posonlyargs + args.args]
+    if args.vararg is not None:
+        names.append(args.vararg.arg)
+    names += [a.arg for a in args.kwonlyargs]
+    if args.kwarg is not None:
+        names.append(args.kwarg.arg)
+    return f"{node.name}({', '.join(names)})"
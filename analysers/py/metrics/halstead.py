@@ -0,0 +1,104 @@
+import ast
+from collections import Counter
+
+# The operators by node type, the operand nodes are handled by the visit_ methods
+_OPERATORS = {
+    # Arithmetic, bitwise & boolean operators
+    ast.Add: "+",
+    ast.Sub: "-",
+    ast.Mult: "*",
+    ast.MatMult: "@",
+    ast.Div: "/",
+    ast.FloorDiv: "//",
+    ast.Mod: "%",
+    ast.Pow: "**",
+    ast.LShift: "<<",
+    ast.RShift: ">>",
+    ast.BitOr: "|",
+    ast.BitXor: "^",
+    ast.BitAnd: "&",
+    ast.And: "and",
+    ast.Or: "or",
+    ast.Not: "not",
+    ast.Invert: "~",
+    ast.UAdd: "+",
+    ast.USub: "-",
+    # Comparisons
+    ast.Eq: "==",
+    ast.NotEq: "!=",
+    ast.Lt: "<",
+    ast.LtE: "<=",
+    ast.Gt: ">",
+    ast.GtE: ">=",
+    ast.Is: "is",
+    ast.IsNot: "is not",
+    ast.In: "in",
+    ast.NotIn: "not in",
+    # Assignments, calls, indexing
+    ast.Assign: "=",
+    ast.AugAssign: "=",
+    ast.AnnAssign: "=",
+    ast.NamedExpr: ":=",
+    ast.Call: "()",
+    ast.Subscript: "[]",
+    ast.Slice: ":",
+    ast.Starred: "*",
+    ast.Attribute: ".",
+    # Keywords
+    ast.FunctionDef: "def",
+    ast.AsyncFunctionDef: "async def",
+    ast.ClassDef: "class",
+    ast.Lambda: "lambda",
+    ast.Return: "return",
+    ast.Yield: "yield",
+    ast.YieldFrom: "yield from",
+    ast.Await: "await",
+    ast.If: "if",
+    ast.IfExp: "if",
+    ast.For: "for",
+    ast.AsyncFor: "async for",
+    ast.comprehension: "for",
+    ast.While: "while",
+    ast.Break: "break",
+    ast.Continue: "continue",
+    ast.Try: "try",
+    ast.ExceptHandler: "except",
+    ast.Raise: "raise",
+    ast.With: "with",
+    ast.AsyncWith: "async with",
+    ast.Match: "match",
+    ast.match_case: "case",
+    ast.Delete: "del",
+    ast.Assert: "assert",
+    ast.Global: "global",
+    ast.Nonlocal: "nonlocal",
+    ast.Import: "import",
+    ast.ImportFrom: "import",
+}
+
+
+class HalsteadMetrics(ast.NodeVisitor):
+    """The Halstead operators & operands of a tree, the measures are calculated by the Go analyser"""
+
+    def __init__(self) -> None:
+        self.operators = Counter()
+        self.operands = Counter()
+
+    def visit_Name(self, node: ast.Name):
+        self.operands[node.id] += 1
+
+    def visit_Constant(self, node: ast.Constant):
+        self.operands[repr(node.value)] += 1
+
+    def visit_arg(self, node: ast.arg):
+        self.operands[node.arg] += 1
+
+    def visit_Attribute(self, node: ast.Attribute):
+        self.operands[node.attr] += 1
+        self.generic_visit(node)
+
+    def generic_visit(self, node: ast.AST):
+        operator = _OPERATORS.get(type(node))
+        if operator is not None:
+            self.operators[operator] += 1
+        return super().generic_visit(node)